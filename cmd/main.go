@@ -9,19 +9,66 @@ import (
 func main() {
 	internal.InitConfig("config.json")
 	internal.InitDB()
+	internal.InitRealtime()
+	internal.InitMailer()
+	internal.InitWebPush()
+	internal.StartBackgroundJobs()
 
 	http.HandleFunc("/", internal.HomeHandler)
 	http.HandleFunc("/login", internal.LoginHandler)
 	http.HandleFunc("/register", internal.RegisterHandler)
 	http.HandleFunc("/posts", internal.PostsHandler)
+	http.HandleFunc("/posts/mode", internal.FeedModeHandler)
+	http.HandleFunc("/tag", internal.TagHandler)
+	http.HandleFunc("/users/autocomplete", internal.MentionAutocompleteHandler)
 	http.HandleFunc("/logout", internal.LogoutHandler)
 	http.HandleFunc("/profile", internal.ProfileHandler)
 	http.HandleFunc("/create-post", internal.CreatePostHandler)
+	http.HandleFunc("/post/delete", internal.DeletePostHandler)
+	http.HandleFunc("/post/edit", internal.EditPostHandler)
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
+	http.HandleFunc("/uploads/previews/", internal.LinkPreviewImageHandler)
+	http.HandleFunc("/uploads/", internal.MediaHandler)
 	http.HandleFunc("/find-friends", internal.FindFriendsHandler)
+	http.HandleFunc("/friends", internal.FriendsHandler)
+	http.HandleFunc("/friends/suggestions/dismiss", internal.SuggestionDismissHandler)
+	http.HandleFunc("/block", internal.BlockHandler)
+	http.HandleFunc("/follow", internal.FollowHandler)
+	http.HandleFunc("/friend-lists", internal.FriendListsHandler)
+	http.HandleFunc("/comment", internal.CommentHandler)
+	http.HandleFunc("/report", internal.ReportHandler)
+	http.HandleFunc("/moderation", internal.ModerationHandler)
+	http.HandleFunc("/moderation/action", internal.ModerationActionHandler)
+	http.HandleFunc("/notifications", internal.NotificationsHandler)
+	http.HandleFunc("/notifications/read", internal.NotificationReadHandler)
+	http.HandleFunc("/react", internal.ReactionHandler)
+	http.HandleFunc("/admin/content-policy", internal.ContentPolicyHandler)
+	http.HandleFunc("/admin/audit", internal.AuditLogHandler)
+	http.HandleFunc("/admin/audit.csv", internal.AuditLogExportHandler)
+	http.HandleFunc("/change-password", internal.ChangePasswordHandler)
+	http.HandleFunc("/account", internal.AccountHandler)
+	http.HandleFunc("/account/export", internal.DataExportHandler)
+	http.HandleFunc("/account/export/download", internal.DataExportDownloadHandler)
+	http.HandleFunc("/account/delete", internal.AccountDeletionHandler)
+	http.HandleFunc("/account/followers", internal.FollowersSettingHandler)
+	http.HandleFunc("/settings/email", internal.EmailSettingsHandler)
+	http.HandleFunc("/settings/privacy", internal.PrivacySettingsHandler)
+	http.HandleFunc("/email/unsubscribe", internal.EmailUnsubscribeHandler)
+	http.HandleFunc("/push/key", internal.PushKeyHandler)
+	http.HandleFunc("/push/subscription", internal.PushSubscriptionHandler)
+	http.HandleFunc("/sw.js", internal.ServiceWorkerHandler)
+	http.HandleFunc("/messages", internal.MessagesHandler)
+	http.HandleFunc("/messages/new", internal.NewDirectMessageHandler)
+	http.HandleFunc("/messages/conversation", internal.ConversationHandler)
+	http.HandleFunc("/messages/send", internal.SendMessageHandler)
+	http.HandleFunc("/messages/delete", internal.DeleteMessageHandler)
+	http.HandleFunc("/messages/group/new", internal.NewGroupChatHandler)
+	http.HandleFunc("/messages/group/manage", internal.ManageGroupChatHandler)
+	http.HandleFunc("/ws", internal.RealtimeHandler)
+	http.HandleFunc("/events/feed", internal.FeedEventsHandler)
 
 	log.Println("Сервер запущен на http://localhost:8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := http.ListenAndServe(":8080", internal.RequireActiveUser(http.DefaultServeMux)); err != nil {
 		log.Fatal(err)
 	}
 }
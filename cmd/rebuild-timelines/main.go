@@ -0,0 +1,32 @@
+// Команда rebuild-timelines пересобирает материализованные ленты постов.
+// Без флагов пересобирает ленты всех пользователей и заново определяет,
+// посты каких авторов подмешиваются при чтении; с -user — ленту одного пользователя
+package main
+
+import (
+	"flag"
+	"log"
+	"social-network/internal"
+)
+
+func main() {
+	configPath := flag.String("config", "config.json", "путь к файлу конфигурации")
+	userID := flag.Int("user", 0, "ID пользователя, чью ленту нужно пересобрать")
+	flag.Parse()
+
+	internal.InitConfig(*configPath)
+	internal.InitDB()
+
+	if *userID > 0 {
+		if err := internal.RebuildTimeline(*userID); err != nil {
+			log.Fatal("Ошибка при пересборке ленты:", err)
+		}
+		log.Printf("Лента пользователя %d пересобрана\n", *userID)
+		return
+	}
+
+	if err := internal.RebuildAllTimelines(); err != nil {
+		log.Fatal("Ошибка при пересборке лент:", err)
+	}
+	log.Println("Ленты пересобраны")
+}
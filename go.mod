@@ -7,6 +7,6 @@ require golang.org/x/crypto v0.28.0
 require (
 	github.com/golang-jwt/jwt/v4 v4.5.1
 	github.com/gorilla/securecookie v1.1.2 // indirect
-	github.com/gorilla/sessions v1.4.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
+	github.com/gorilla/sessions v1.4.0
+	github.com/lib/pq v1.10.9
 )
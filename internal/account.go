@@ -0,0 +1,294 @@
+// internal/account.go
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ChangePasswordHandler рендерит форму смены пароля и обновляет пароль пользователя
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	data := map[string]string{}
+	if r.Method == http.MethodPost {
+		currentPassword := r.FormValue("current_password")
+		newPassword := r.FormValue("new_password")
+
+		var hashedPassword string
+		err := DB.QueryRow("SELECT password_hash FROM users WHERE id = $1", userID).Scan(&hashedPassword)
+		if err != nil {
+			log.Println("Ошибка при получении пароля пользователя:", err)
+			http.Error(w, "Ошибка при запросе к базе данных", http.StatusInternalServerError)
+			return
+		}
+
+		if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(currentPassword)) != nil {
+			data["ErrorMsg"] = "Неверный текущий пароль"
+			Audit(r, userID, AuditPasswordChange, "user", userID, "failed: wrong current password")
+		} else if len(newPassword) < 5 {
+			data["ErrorMsg"] = "Пароль должен быть длиной не менее 5 символов"
+		} else {
+			passwordHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+			if err != nil {
+				log.Printf("Ошибка хеширования пароля: %v\n", err)
+				http.Error(w, "Ошибка при хешировании пароля", http.StatusInternalServerError)
+				return
+			}
+
+			_, err = DB.Exec("UPDATE users SET password_hash = $1 WHERE id = $2", passwordHash, userID)
+			if err != nil {
+				log.Println("Ошибка при обновлении пароля:", err)
+				http.Error(w, "Ошибка при смене пароля", http.StatusInternalServerError)
+				return
+			}
+
+			Audit(r, userID, AuditPasswordChange, "user", userID, "")
+			data["SuccessMsg"] = "Пароль изменён"
+		}
+	}
+
+	renderTemplate(w, "change-password.html", data)
+}
+
+// AccountHandler показывает страницу управления аккаунтом: выгрузку данных и удаление
+func AccountHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+
+	exports, err := ListDataExports(userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке выгрузок:", err)
+		http.Error(w, "Ошибка при загрузке страницы аккаунта", http.StatusInternalServerError)
+		return
+	}
+
+	var requestedAt sql.NullTime
+	var acceptFollowers bool
+	err = DB.QueryRow(`
+		SELECT deletion_requested_at, accept_followers FROM users WHERE id = $1
+	`, userID).Scan(&requestedAt, &acceptFollowers)
+	if err != nil {
+		log.Println("Ошибка при получении статуса удаления:", err)
+		http.Error(w, "Ошибка при загрузке страницы аккаунта", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Header          HeaderData
+		Exports         []DataExport
+		DeletionPending bool
+		DeletionAt      time.Time
+		GraceDays       int
+		AcceptFollowers bool
+		ErrorMsg        string
+	}{
+		Header:          header,
+		Exports:         exports,
+		DeletionPending: requestedAt.Valid,
+		DeletionAt:      requestedAt.Time.Add(AppConfig.DeletionGracePeriod()),
+		GraceDays:       int(AppConfig.DeletionGracePeriod().Hours() / 24),
+		AcceptFollowers: acceptFollowers,
+		ErrorMsg:        r.URL.Query().Get("error"),
+	}
+
+	renderTemplate(w, "account.html", data)
+}
+
+// AccountDeletionHandler запрашивает удаление аккаунта или отменяет запрос.
+// Удаление выполняется фоновой задачей по истечении льготного периода
+func AccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	if r.FormValue("action") == "cancel" {
+		_, err = DB.Exec(`UPDATE users SET deletion_requested_at = NULL WHERE id = $1`, userID)
+		if err != nil {
+			log.Println("Ошибка при отмене удаления аккаунта:", err)
+			http.Error(w, "Ошибка при отмене удаления", http.StatusInternalServerError)
+			return
+		}
+		Audit(r, userID, AuditDeletionCancel, "user", userID, "")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+
+	// Удаление подтверждается паролем
+	var hashedPassword string
+	err = DB.QueryRow("SELECT password_hash FROM users WHERE id = $1", userID).Scan(&hashedPassword)
+	if err != nil {
+		log.Println("Ошибка при получении пароля пользователя:", err)
+		http.Error(w, "Ошибка при запросе к базе данных", http.StatusInternalServerError)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(r.FormValue("password"))) != nil {
+		http.Redirect(w, r, "/account?error=Неверный+пароль", http.StatusSeeOther)
+		return
+	}
+
+	_, err = DB.Exec(`UPDATE users SET deletion_requested_at = NOW() WHERE id = $1 AND deletion_requested_at IS NULL`, userID)
+	if err != nil {
+		log.Println("Ошибка при запросе удаления аккаунта:", err)
+		http.Error(w, "Ошибка при удалении аккаунта", http.StatusInternalServerError)
+		return
+	}
+	Audit(r, userID, AuditDeletionRequest, "user", userID, "")
+
+	// Завершаем сессию, восстановить аккаунт можно, снова войдя в него
+	session, _ := store.Get(r, "session-name")
+	delete(session.Values, "userID")
+	session.Save(r, w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// purgeDeletedAccounts окончательно удаляет аккаунты, у которых истёк льготный период
+func purgeDeletedAccounts() error {
+	rows, err := DB.Query(`
+		SELECT id
+		FROM users
+		WHERE deletion_requested_at < $1 AND deleted_at IS NULL
+	`, time.Now().Add(-AppConfig.DeletionGracePeriod()))
+	if err != nil {
+		return err
+	}
+
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		if err := purgeAccount(userID); err != nil {
+			log.Printf("Ошибка при удалении аккаунта %d: %v\n", userID, err)
+		}
+	}
+	return nil
+}
+
+// purgeAccount удаляет посты, комментарии, дружбы, уведомления и загруженные файлы
+// пользователя, а саму запись анонимизирует: она нужна для журнала аудита и
+// обработанных жалоб. Сессии перестают действовать благодаря отметке deleted_at
+func purgeAccount(userID int) error {
+	files, err := userUploadedFiles(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`DELETE FROM comments WHERE user_id = $1`,
+		`DELETE FROM posts WHERE user_id = $1`,
+		`DELETE FROM friendships WHERE user_id = $1 OR friend_id = $1`,
+		`DELETE FROM friend_lists WHERE owner_id = $1`,
+		`DELETE FROM friend_list_members WHERE user_id = $1`,
+		`DELETE FROM timelines WHERE user_id = $1`,
+		`DELETE FROM follows WHERE follower_id = $1 OR followee_id = $1`,
+		`DELETE FROM user_blocks WHERE blocker_id = $1 OR blocked_id = $1`,
+		`DELETE FROM friend_suggestions WHERE user_id = $1 OR suggested_id = $1`,
+		`DELETE FROM friend_suggestion_dismissals WHERE user_id = $1 OR suggested_id = $1`,
+		`DELETE FROM messages WHERE sender_id = $1`,
+		`DELETE FROM conversation_members WHERE user_id = $1`,
+		`DELETE FROM notifications WHERE user_id = $1`,
+		`DELETE FROM post_reactions WHERE user_id = $1`,
+		`DELETE FROM email_outbox WHERE user_id = $1`,
+		`DELETE FROM email_preferences WHERE user_id = $1`,
+		`DELETE FROM push_subscriptions WHERE user_id = $1`,
+		`DELETE FROM realtime_events WHERE user_id = $1`,
+		`DELETE FROM data_exports WHERE user_id = $1`,
+		`UPDATE users
+		 SET username = 'deleted_' || id, email = 'deleted_' || id || '@deleted.invalid',
+		     password_hash = '', avatar_url = NULL, deleted_at = NOW()
+		 WHERE id = $1`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return fmt.Errorf("%s: %w", statement, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, filePath := range files {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			log.Println("Ошибка при удалении файла пользователя:", err)
+		}
+	}
+
+	_, err = DB.Exec(`
+		INSERT INTO audit_log (action, target_type, target_id, details)
+		VALUES ($1, 'user', $2, $3)
+	`, AuditAccountPurge, userID, fmt.Sprintf("files removed: %d", len(files)))
+	return err
+}
+
+// userUploadedFiles возвращает пути ко всем загруженным пользователем файлам,
+// включая архивы выгрузок
+func userUploadedFiles(userID int) ([]string, error) {
+	rows, err := DB.Query(`
+		SELECT a.path FROM post_attachments a JOIN posts p ON p.id = a.post_id WHERE p.user_id = $1
+		UNION
+		SELECT avatar_url FROM users WHERE id = $1 AND avatar_url IS NOT NULL
+		UNION
+		SELECT file_path FROM data_exports WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []string
+	for rows.Next() {
+		var storedPath string
+		if err := rows.Scan(&storedPath); err != nil {
+			return nil, err
+		}
+		if path := uploadedFilePath(storedPath); path != "" {
+			files = append(files, path)
+		} else if strings.HasPrefix(filepath.Clean(storedPath), filepath.Clean(exportDir)+string(filepath.Separator)) {
+			files = append(files, storedPath)
+		}
+	}
+	return files, rows.Err()
+}
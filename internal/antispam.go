@@ -0,0 +1,250 @@
+// internal/antispam.go
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// TrustLevel отражает степень доверия к аккаунту. Уровень растёт с возрастом
+// аккаунта и активностью, от него зависят ограничения на частоту действий
+type TrustLevel int
+
+const (
+	TrustNew TrustLevel = iota
+	TrustBasic
+	TrustMember
+	TrustTrusted
+)
+
+// trustRequirement описывает условия получения уровня доверия. Комментарии
+// учитываются только к чужим постам: оставленные (Given) и полученные (Taken)
+type trustRequirement struct {
+	Level            TrustLevel
+	MinAgeDays       int
+	MinPosts         int
+	MinFriends       int
+	MinCommentsGiven int
+	MinCommentsTaken int
+}
+
+// trustRequirements перечислены от высшего уровня к низшему
+var trustRequirements = []trustRequirement{
+	{Level: TrustTrusted, MinAgeDays: 30, MinPosts: 20, MinFriends: 10, MinCommentsGiven: 10, MinCommentsTaken: 20},
+	{Level: TrustMember, MinAgeDays: 7, MinPosts: 5, MinFriends: 3, MinCommentsGiven: 3, MinCommentsTaken: 3},
+	{Level: TrustBasic, MinAgeDays: 1, MinPosts: 1},
+}
+
+// velocityLimits задаёт лимиты действий в час для каждого уровня доверия
+var velocityLimits = map[TrustLevel]struct {
+	FriendRequests int
+	Posts          int
+}{
+	TrustNew:     {FriendRequests: 5, Posts: 3},
+	TrustBasic:   {FriendRequests: 15, Posts: 10},
+	TrustMember:  {FriendRequests: 40, Posts: 30},
+	TrustTrusted: {FriendRequests: 100, Posts: 60},
+}
+
+// duplicateMinLength — минимальная длина текста, для которой ищутся дубликаты.
+// Короткие фразы вроде «Привет!» совпадают у разных людей естественным образом
+const duplicateMinLength = 20
+
+var ErrRateLimited = errors.New("слишком много действий за последний час, попробуйте позже")
+
+var trustLevelLabels = map[TrustLevel]string{
+	TrustNew:     "Новичок",
+	TrustBasic:   "Участник",
+	TrustMember:  "Постоянный участник",
+	TrustTrusted: "Доверенный",
+}
+
+func (l TrustLevel) String() string {
+	return trustLevelLabels[l]
+}
+
+// GetTrustLevel вычисляет уровень доверия пользователя
+func GetTrustLevel(userID int) (TrustLevel, error) {
+	var role string
+	var ageDays, posts, friends, commentsGiven, commentsTaken int
+	err := DB.QueryRow(`
+		SELECT u.role,
+		       EXTRACT(DAY FROM NOW() - u.registration_date)::INT,
+		       (SELECT COUNT(*) FROM posts WHERE user_id = u.id AND hidden_at IS NULL AND held_at IS NULL),
+		       (SELECT COUNT(*) FROM users f WHERE f.deleted_at IS NULL AND f.id IN (
+		            SELECT friend_id FROM friendships WHERE user_id = u.id
+		            UNION
+		            SELECT user_id FROM friendships WHERE friend_id = u.id)),
+		       (SELECT COUNT(*) FROM comments c JOIN posts p ON p.id = c.post_id
+		        WHERE c.user_id = u.id AND p.user_id != u.id AND c.hidden_at IS NULL AND c.held_at IS NULL),
+		       (SELECT COUNT(*) FROM comments c JOIN posts p ON p.id = c.post_id
+		        WHERE p.user_id = u.id AND c.user_id != u.id AND c.hidden_at IS NULL)
+		FROM users u
+		WHERE u.id = $1
+	`, userID).Scan(&role, &ageDays, &posts, &friends, &commentsGiven, &commentsTaken)
+	if err != nil {
+		return TrustNew, err
+	}
+
+	if role == RoleModerator || role == RoleAdmin {
+		return TrustTrusted, nil
+	}
+	for _, req := range trustRequirements {
+		if ageDays >= req.MinAgeDays && posts >= req.MinPosts && friends >= req.MinFriends &&
+			commentsGiven >= req.MinCommentsGiven && commentsTaken >= req.MinCommentsTaken {
+			return req.Level, nil
+		}
+	}
+	return TrustNew, nil
+}
+
+// CheckFriendRequestRate проверяет, не превышен ли лимит добавления друзей.
+// При превышении аккаунт отправляется на проверку модератору
+func CheckFriendRequestRate(userID int) error {
+	level, err := GetTrustLevel(userID)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	err = DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM friendships
+		WHERE user_id = $1 AND created_at > NOW() - INTERVAL '1 hour'
+	`, userID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count >= velocityLimits[level].FriendRequests {
+		flagAccount(userID, fmt.Sprintf("Превышен лимит заявок в друзья: %d за час (уровень доверия «%s»)", count, level))
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// CheckPostActivity проверяет лимит публикаций и ищет признаки спама в тексте поста
+func CheckPostActivity(userID int, content string) (PolicyDecision, error) {
+	decision := PolicyDecision{Action: PolicyAllow, Content: content}
+
+	level, err := GetTrustLevel(userID)
+	if err != nil {
+		return decision, err
+	}
+
+	var count int
+	err = DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM posts
+		WHERE user_id = $1 AND created_at > NOW() - INTERVAL '1 hour'
+	`, userID).Scan(&count)
+	if err != nil {
+		return decision, err
+	}
+	if count >= velocityLimits[level].Posts {
+		flagAccount(userID, fmt.Sprintf("Превышен лимит постов: %d за час (уровень доверия «%s»)", count, level))
+		decision.Action = PolicyReject
+		decision.Reason = ErrRateLimited.Error()
+		return decision, nil
+	}
+	return checkSpamSignals(userID, level, content)
+}
+
+// CheckEditedPostActivity ищет признаки спама в новом тексте поста. Лимит
+// публикаций не проверяется: редактирование не создаёт новый пост
+func CheckEditedPostActivity(userID int, content string) (PolicyDecision, error) {
+	level, err := GetTrustLevel(userID)
+	if err != nil {
+		return PolicyDecision{Action: PolicyAllow, Content: content}, err
+	}
+	return checkSpamSignals(userID, level, content)
+}
+
+// checkSpamSignals ищет в тексте поста признаки спама с учётом уровня доверия автора
+func checkSpamSignals(userID int, level TrustLevel, content string) (PolicyDecision, error) {
+	decision := PolicyDecision{Action: PolicyAllow, Content: content}
+	if level >= TrustTrusted {
+		return decision, nil
+	}
+
+	// Одинаковый текст от нескольких аккаунтов — типичный признак спам-рассылки
+	if hash := contentHash(content); hash != "" {
+		var accounts int
+		err := DB.QueryRow(`
+			SELECT COUNT(DISTINCT user_id)
+			FROM posts
+			WHERE content_hash = $1 AND user_id != $2 AND created_at > NOW() - INTERVAL '24 hours'
+		`, hash, userID).Scan(&accounts)
+		if err != nil {
+			return decision, err
+		}
+		if accounts >= 2 || (accounts >= 1 && level == TrustNew) {
+			decision.Action = PolicyHold
+			decision.Reason = fmt.Sprintf("Такой же текст недавно опубликовали другие аккаунты (%d)", accounts)
+			decision.ReportReason = "spam_heuristics"
+			return decision, nil
+		}
+	}
+
+	// Ссылки от совсем новых аккаунтов проверяет модератор
+	if level == TrustNew && urlPattern.MatchString(content) {
+		decision.Action = PolicyHold
+		decision.Reason = "Ссылка в посте нового аккаунта"
+		decision.ReportReason = "spam_heuristics"
+	}
+	return decision, nil
+}
+
+// contentHash возвращает отпечаток нормализованного текста для поиска дубликатов.
+// Регистр, пунктуация и пробелы не учитываются. Для коротких текстов возвращается пустая строка
+func contentHash(content string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(content) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	normalized := b.String()
+	if len([]rune(normalized)) < duplicateMinLength {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// flagAccount отправляет профиль на проверку модератору, если по нему ещё нет открытой жалобы
+func flagAccount(userID int, details string) {
+	var exists bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM reports
+			WHERE target_type = 'profile' AND target_id = $1 AND reason = 'velocity'
+			  AND status IN ('open', 'claimed')
+		)
+	`, userID).Scan(&exists)
+	if err != nil {
+		log.Println("Ошибка при проверке жалоб на аккаунт:", err)
+		return
+	}
+	if exists {
+		return
+	}
+
+	if err := CreateReport(0, ReportTargetProfile, userID, "velocity", details); err != nil {
+		log.Println("Ошибка при создании жалобы на подозрительный аккаунт:", err)
+	}
+}
+
+// stricterDecision возвращает более строгое из двух решений
+func stricterDecision(a, b PolicyDecision) PolicyDecision {
+	if policyActionPriority[b.Action] > policyActionPriority[a.Action] {
+		b.Content = a.Content
+		return b
+	}
+	return a
+}
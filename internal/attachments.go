@@ -0,0 +1,313 @@
+// internal/attachments.go
+package internal
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Виды вложений поста
+const (
+	AttachmentImage = "image"
+	AttachmentVideo = "video"
+)
+
+const (
+	// maxPostAttachments — сколько файлов можно прикрепить к посту
+	maxPostAttachments = 10
+	// maxPostVideos — сколько из них может быть видео
+	maxPostVideos = 2
+	// maxImageSize и maxVideoSize ограничивают размер одного файла
+	maxImageSize = 10 << 20
+	maxVideoSize = 50 << 20
+	// maxPostUploadSize ограничивает весь запрос с файлами поста
+	maxPostUploadSize = maxPostAttachments*maxImageSize + maxPostVideos*maxVideoSize
+	// maxAltText ограничивает длину описания вложения
+	maxAltText = 500
+
+	// attachmentDir — поддиректория uploadDir для вложений постов
+	attachmentDir = "posts"
+)
+
+var (
+	ErrTooManyAttachments = errors.New("к посту можно прикрепить не больше 10 файлов, из них не больше 2 видео")
+	ErrAttachmentType     = errors.New("можно прикреплять только изображения JPEG, PNG, GIF, WebP и видео MP4, WebM")
+	ErrAttachmentTooLarge = errors.New("изображение должно быть не больше 10 МБ, видео — не больше 50 МБ")
+	ErrUploadTooLarge     = errors.New("файлы слишком большие")
+)
+
+// attachmentTypes — допустимые типы файлов: вид вложения и расширение.
+// Тип определяется по содержимому файла, а не по имени или заголовку браузера
+var attachmentTypes = map[string]struct{ Kind, Ext string }{
+	"image/jpeg": {AttachmentImage, ".jpg"},
+	"image/png":  {AttachmentImage, ".png"},
+	"image/gif":  {AttachmentImage, ".gif"},
+	"image/webp": {AttachmentImage, ".webp"},
+	"video/mp4":  {AttachmentVideo, ".mp4"},
+	"video/webm": {AttachmentVideo, ".webm"},
+}
+
+// Attachment — файл, прикреплённый к посту
+type Attachment struct {
+	ID      int
+	Kind    string
+	Path    string
+	URL     string
+	AltText string
+}
+
+// IsVideo сообщает, что вложение — видео
+func (a Attachment) IsVideo() bool {
+	return a.Kind == AttachmentVideo
+}
+
+// attachmentUpload — проверенный файл из формы, ещё не сохранённый на диск
+type attachmentUpload struct {
+	header  *multipart.FileHeader
+	kind    string
+	ext     string
+	altText string
+}
+
+// parsePostForm разбирает форму поста вместе с файлами, ограничивая размер запроса
+func parsePostForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPostUploadSize)
+	err := r.ParseMultipartForm(32 << 20)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrUploadTooLarge
+	}
+	if err != nil && err != http.ErrNotMultipart {
+		return err
+	}
+	return nil
+}
+
+// formAttachments проверяет файлы из поля attachments разобранной формы
+// и сопоставляет им описания из полей alt
+func formAttachments(r *http.Request) ([]attachmentUpload, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+	return checkAttachments(r.MultipartForm.File["attachments"], r.MultipartForm.Value["alt"])
+}
+
+// checkAttachments проверяет количество, типы и размеры загруженных файлов.
+// altTexts — описания файлов в том же порядке, что и файлы
+func checkAttachments(headers []*multipart.FileHeader, altTexts []string) ([]attachmentUpload, error) {
+	if len(headers) > maxPostAttachments {
+		return nil, ErrTooManyAttachments
+	}
+
+	uploads := make([]attachmentUpload, 0, len(headers))
+	videos := 0
+	for i, header := range headers {
+		kind, ext, err := sniffAttachment(header)
+		if err != nil {
+			return nil, err
+		}
+		if kind == AttachmentVideo {
+			videos++
+			if videos > maxPostVideos {
+				return nil, ErrTooManyAttachments
+			}
+		}
+		if (kind == AttachmentImage && header.Size > maxImageSize) || header.Size > maxVideoSize {
+			return nil, ErrAttachmentTooLarge
+		}
+
+		upload := attachmentUpload{header: header, kind: kind, ext: ext}
+		if i < len(altTexts) {
+			upload.altText = normalizeAltText(altTexts[i])
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+// sniffAttachment определяет тип файла по первым байтам
+func sniffAttachment(header *multipart.FileHeader) (kind, ext string, err error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", "", ErrAttachmentType
+	}
+	fileType, ok := attachmentTypes[http.DetectContentType(head[:n])]
+	if !ok {
+		return "", "", ErrAttachmentType
+	}
+	return fileType.Kind, fileType.Ext, nil
+}
+
+// normalizeAltText убирает лишние пробелы и ограничивает длину описания
+func normalizeAltText(altText string) string {
+	return truncateRunes(strings.Join(strings.Fields(altText), " "), maxAltText)
+}
+
+// saveAttachments сохраняет файлы под случайными именами и возвращает вложения в порядке загрузки
+func saveAttachments(uploads []attachmentUpload) ([]Attachment, error) {
+	var saved []Attachment
+	for _, upload := range uploads {
+		path, err := saveAttachmentFile(upload)
+		if err != nil {
+			removeAttachmentFiles(saved)
+			return nil, err
+		}
+		saved = append(saved, Attachment{Kind: upload.kind, Path: path, AltText: upload.altText})
+	}
+	return saved, nil
+}
+
+// saveAttachmentFile сохраняет один файл в uploads/posts
+func saveAttachmentFile(upload attachmentUpload) (string, error) {
+	file, err := upload.header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err != nil {
+		return "", err
+	}
+	return saveUpload(attachmentDir, hex.EncodeToString(name)+upload.ext, file)
+}
+
+// removeAttachmentFiles удаляет файлы вложений с диска
+func removeAttachmentFiles(attachments []Attachment) {
+	for _, attachment := range attachments {
+		if err := os.Remove(attachment.Path); err != nil && !os.IsNotExist(err) {
+			log.Println("Ошибка при удалении вложения:", err)
+		}
+	}
+}
+
+// insertPostAttachments добавляет вложения в конец списка вложений поста
+func insertPostAttachments(tx *sql.Tx, postID int, attachments []Attachment) error {
+	for _, attachment := range attachments {
+		_, err := tx.Exec(`
+			INSERT INTO post_attachments (post_id, position, kind, path, alt_text)
+			SELECT $1, COALESCE(MAX(position) + 1, 0), $2, $3, $4
+			FROM post_attachments WHERE post_id = $1
+		`, postID, attachment.Kind, attachment.Path, attachment.AltText)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListPostAttachments возвращает вложения поста по порядку
+func ListPostAttachments(postID int) ([]Attachment, error) {
+	attachments, err := loadAttachments([]int{postID})
+	return attachments[postID], err
+}
+
+// loadAttachments возвращает вложения постов, сгруппированные по ID поста
+func loadAttachments(postIDs []int) (map[int][]Attachment, error) {
+	attachments := make(map[int][]Attachment)
+	if len(postIDs) == 0 {
+		return attachments, nil
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, post_id, kind, path, alt_text
+		FROM post_attachments
+		WHERE post_id = ANY($1)
+		ORDER BY post_id, position
+	`, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var attachment Attachment
+		var postID int
+		if err := rows.Scan(&attachment.ID, &postID, &attachment.Kind, &attachment.Path, &attachment.AltText); err != nil {
+			return nil, err
+		}
+		attachment.URL = uploadURL(attachment.Path)
+		attachments[postID] = append(attachments[postID], attachment)
+	}
+	return attachments, rows.Err()
+}
+
+// attachMedia подгружает вложения к списку постов
+func attachMedia(posts []Post) {
+	postIDs := make([]int, 0, len(posts))
+	for _, post := range posts {
+		postIDs = append(postIDs, post.ID)
+	}
+
+	attachments, err := loadAttachments(postIDs)
+	if err != nil {
+		log.Println("Ошибка при загрузке вложений:", err)
+		return
+	}
+	for i := range posts {
+		posts[i].Attachments = attachments[posts[i].ID]
+	}
+}
+
+// MediaHandler отдаёт файлы вложений постов тем, кому виден пост: автору,
+// в том числе пока пост на проверке, модераторам и аудитории опубликованного поста
+func MediaHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	path := uploadedFilePath(r.URL.Path)
+	if path == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var postID, authorID int
+	err = DB.QueryRow(`
+		SELECT p.id, p.user_id
+		FROM post_attachments a
+		JOIN posts p ON p.id = a.post_id
+		WHERE a.path = $1
+	`, path).Scan(&postID, &authorID)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	var visible bool
+	if err == nil {
+		visible, err = CanViewPost(postID, userID)
+	}
+	if err != nil {
+		log.Println("Ошибка при проверке доступа к вложению:", err)
+		http.Error(w, "Ошибка при загрузке файла", http.StatusInternalServerError)
+		return
+	}
+	if !visible && authorID != userID && !IsModerator(userID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeFile(w, r, filepath.Clean(path))
+}
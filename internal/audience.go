@@ -0,0 +1,280 @@
+// internal/audience.go
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// Аудитория поста
+const (
+	AudiencePublic  = "public"  // все пользователи
+	AudienceFriends = "friends" // все друзья автора
+	AudienceLists   = "lists"   // друзья из выбранных списков
+	AudiencePrivate = "private" // только автор
+)
+
+// maxFriendListName ограничивает длину названия списка друзей
+const maxFriendListName = 50
+
+var (
+	ErrInvalidAudience    = errors.New("некорректная аудитория поста")
+	ErrNoAudienceLists    = errors.New("выберите хотя бы один список друзей")
+	ErrInvalidListName    = errors.New("название списка должно содержать от 1 до 50 символов")
+	ErrFriendListNotFound = errors.New("список друзей не найден")
+)
+
+// audienceOptions — варианты аудитории для формы создания поста
+var audienceOptions = []Option{
+	{Value: AudiencePublic, Label: "Все"},
+	{Value: AudienceFriends, Label: "Друзья"},
+	{Value: AudienceLists, Label: "Списки друзей"},
+	{Value: AudiencePrivate, Label: "Только я"},
+}
+
+// isValidAudience проверяет, что аудитория входит в список допустимых
+func isValidAudience(audience string) bool {
+	return hasOption(audienceOptions, audience)
+}
+
+// audienceLabel возвращает название аудитории для отображения
+func audienceLabel(audience string) string {
+	for _, option := range audienceOptions {
+		if option.Value == audience {
+			return option.Label
+		}
+	}
+	return audience
+}
+
+// visiblePostCondition возвращает SQL-условие видимости поста p для пользователя viewer.
+// viewer — выражение SQL, например "$1" или "r.id". Списки друзей сужают круг друзей,
+// поэтому участник списка, переставший быть другом, пост больше не видит
+func visiblePostCondition(viewer string) string {
+	friends := `EXISTS (
+			SELECT 1 FROM friendships f
+			WHERE (f.user_id = p.user_id AND f.friend_id = ` + viewer + `)
+			   OR (f.user_id = ` + viewer + ` AND f.friend_id = p.user_id)
+		)`
+	return `(p.user_id = ` + viewer + `
+		OR p.audience = 'public'
+		OR (p.audience = 'friends' AND ` + friends + `)
+		OR (p.audience = 'lists' AND ` + friends + ` AND EXISTS (
+			SELECT 1 FROM post_audience_lists pal
+			JOIN friend_list_members m ON m.list_id = pal.list_id
+			WHERE pal.post_id = p.id AND m.user_id = ` + viewer + `
+		)))`
+}
+
+// CanViewPost проверяет, что пост опубликован и доступен пользователю
+func CanViewPost(postID, viewerID int) (bool, error) {
+	var visible bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM posts p
+			WHERE p.id = $1 AND p.hidden_at IS NULL AND p.held_at IS NULL
+			  AND `+visiblePostCondition("$2")+`
+		)
+	`, postID, viewerID).Scan(&visible)
+	return visible, err
+}
+
+// FriendList — именованный список друзей пользователя
+type FriendList struct {
+	ID      int
+	Name    string
+	Members []User
+}
+
+// ListFriendLists возвращает списки друзей пользователя вместе с участниками
+func ListFriendLists(ownerID int) ([]FriendList, error) {
+	rows, err := DB.Query(`
+		SELECT l.id, l.name, u.id, u.username
+		FROM friend_lists l
+		LEFT JOIN friend_list_members m ON m.list_id = l.id
+		LEFT JOIN users u ON u.id = m.user_id AND u.deleted_at IS NULL
+		WHERE l.owner_id = $1
+		ORDER BY l.name, l.id, u.username
+	`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lists []FriendList
+	for rows.Next() {
+		var listID int
+		var name string
+		var memberID sql.NullInt64
+		var username sql.NullString
+		if err := rows.Scan(&listID, &name, &memberID, &username); err != nil {
+			return nil, err
+		}
+		if len(lists) == 0 || lists[len(lists)-1].ID != listID {
+			lists = append(lists, FriendList{ID: listID, Name: name})
+		}
+		if memberID.Valid {
+			list := &lists[len(lists)-1]
+			list.Members = append(list.Members, User{ID: int(memberID.Int64), Username: username.String})
+		}
+	}
+	return lists, rows.Err()
+}
+
+// CreateFriendList создаёт пустой список друзей
+func CreateFriendList(ownerID int, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxFriendListName {
+		return ErrInvalidListName
+	}
+	_, err := DB.Exec(`
+		INSERT INTO friend_lists (owner_id, name)
+		VALUES ($1, $2)
+		ON CONFLICT (owner_id, name) DO NOTHING
+	`, ownerID, name)
+	return err
+}
+
+// DeleteFriendList удаляет список. Посты, видимые только этому списку, остаются видны одному автору
+func DeleteFriendList(ownerID, listID int) error {
+	_, err := DB.Exec(`DELETE FROM friend_lists WHERE id = $1 AND owner_id = $2`, listID, ownerID)
+	return err
+}
+
+// AddFriendListMember добавляет друга в список владельца
+func AddFriendListMember(ownerID, listID, userID int) error {
+	friends, err := AreFriends(ownerID, userID)
+	if err != nil {
+		return err
+	}
+	if !friends {
+		return ErrNotFriends
+	}
+
+	result, err := DB.Exec(`
+		INSERT INTO friend_list_members (list_id, user_id)
+		SELECT id, $3 FROM friend_lists WHERE id = $1 AND owner_id = $2
+		ON CONFLICT DO NOTHING
+	`, listID, ownerID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// Либо списка нет, либо друг уже в нём
+		var exists bool
+		err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM friend_lists WHERE id = $1 AND owner_id = $2)`, listID, ownerID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrFriendListNotFound
+		}
+	}
+	return nil
+}
+
+// RemoveFriendListMember убирает пользователя из списка владельца
+func RemoveFriendListMember(ownerID, listID, userID int) error {
+	_, err := DB.Exec(`
+		DELETE FROM friend_list_members
+		WHERE list_id = (SELECT id FROM friend_lists WHERE id = $1 AND owner_id = $2) AND user_id = $3
+	`, listID, ownerID, userID)
+	return err
+}
+
+// setPostAudienceLists привязывает пост к спискам автора. Чужие списки игнорируются
+func setPostAudienceLists(tx *sql.Tx, postID, ownerID int, listIDs []int) error {
+	result, err := tx.Exec(`
+		INSERT INTO post_audience_lists (post_id, list_id)
+		SELECT $1, id FROM friend_lists WHERE owner_id = $2 AND id = ANY($3)
+	`, postID, ownerID, pq.Array(listIDs))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNoAudienceLists
+	}
+	return nil
+}
+
+// FriendListsHandler показывает списки друзей и изменяет их
+func FriendListsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		listID, _ := strconv.Atoi(r.FormValue("list_id"))
+		memberID, _ := strconv.Atoi(r.FormValue("user_id"))
+
+		switch r.FormValue("action") {
+		case "create":
+			err = CreateFriendList(userID, r.FormValue("name"))
+		case "delete":
+			err = DeleteFriendList(userID, listID)
+		case "add":
+			err = AddFriendListMember(userID, listID, memberID)
+		case "remove":
+			err = RemoveFriendListMember(userID, listID, memberID)
+		default:
+			http.Error(w, "Неизвестное действие", http.StatusBadRequest)
+			return
+		}
+		switch {
+		case err == ErrInvalidListName || err == ErrNotFriends || err == ErrFriendListNotFound:
+			http.Redirect(w, r, "/friend-lists?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+			return
+		case err != nil:
+			log.Println("Ошибка при изменении списка друзей:", err)
+			http.Error(w, "Не удалось изменить список друзей", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/friend-lists", http.StatusSeeOther)
+		return
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+
+	lists, err := ListFriendLists(userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке списков друзей:", err)
+		http.Error(w, "Ошибка при загрузке списков друзей", http.StatusInternalServerError)
+		return
+	}
+
+	friends, err := ListFriends(userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке друзей:", err)
+		http.Error(w, "Ошибка при загрузке списков друзей", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Header   HeaderData
+		Lists    []FriendList
+		Friends  []User
+		ErrorMsg string
+	}{
+		Header:   header,
+		Lists:    lists,
+		Friends:  friends,
+		ErrorMsg: r.URL.Query().Get("error"),
+	}
+
+	renderTemplate(w, "friend-lists.html", data)
+}
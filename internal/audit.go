@@ -0,0 +1,285 @@
+// internal/audit.go
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Действия, записываемые в журнал аудита
+const (
+	AuditLoginSuccess      = "login_success"
+	AuditLoginFailed       = "login_failed"
+	AuditLogout            = "logout"
+	AuditRegister          = "register"
+	AuditPasswordChange    = "password_change"
+	AuditFriendAdd         = "friend_add"
+	AuditUserBlock         = "user_block"
+	AuditUserUnblock       = "user_unblock"
+	AuditReportCreate      = "report_create"
+	AuditReportClaim       = "report_claim"
+	AuditReportResolve     = "report_resolve"
+	AuditReportDismiss     = "report_dismiss"
+	AuditContentPolicyEdit = "content_policy_edit"
+	AuditAuditLogExport    = "audit_export"
+	AuditDataExport        = "data_export"
+	AuditDeletionRequest   = "account_deletion_request"
+	AuditDeletionCancel    = "account_deletion_cancel"
+	AuditAccountPurge      = "account_purge"
+)
+
+// auditActionLabels содержит подписи действий для страницы журнала
+var auditActionLabels = map[string]string{
+	AuditLoginSuccess:      "Вход",
+	AuditLoginFailed:       "Неудачный вход",
+	AuditLogout:            "Выход",
+	AuditRegister:          "Регистрация",
+	AuditPasswordChange:    "Смена пароля",
+	AuditFriendAdd:         "Добавление друга",
+	AuditReportCreate:      "Жалоба",
+	AuditReportClaim:       "Жалоба взята в работу",
+	AuditReportResolve:     "Жалоба решена",
+	AuditReportDismiss:     "Жалоба отклонена",
+	AuditContentPolicyEdit: "Изменение фильтра контента",
+	AuditAuditLogExport:    "Выгрузка журнала аудита",
+	AuditDataExport:        "Выгрузка персональных данных",
+	AuditDeletionRequest:   "Запрос на удаление аккаунта",
+	AuditDeletionCancel:    "Отмена удаления аккаунта",
+	AuditAccountPurge:      "Удаление аккаунта",
+}
+
+// auditPageSize ограничивает количество записей на странице журнала
+const auditPageSize = 200
+
+// AuditEntry представляет запись журнала аудита
+type AuditEntry struct {
+	ID         int64
+	ActorID    int
+	ActorName  string
+	Action     string
+	TargetType string
+	TargetID   int
+	IP         string
+	UserAgent  string
+	Details    string
+	CreatedAt  time.Time
+}
+
+// AuditFilter содержит условия поиска по журналу
+type AuditFilter struct {
+	User   string
+	Action string
+	From   string
+	To     string
+}
+
+func auditActionLabel(action string) string {
+	if label, ok := auditActionLabels[action]; ok {
+		return label
+	}
+	return action
+}
+
+// clientIP возвращает IP-адрес клиента. Заголовок X-Forwarded-For учитывается
+// только если сервер работает за доверенным прокси. Берётся последний адрес —
+// его добавил сам прокси, а адреса левее клиент может подставить любые
+func clientIP(r *http.Request) string {
+	if AppConfig.TrustProxyHeaders {
+		forwarded := r.Header.Values("X-Forwarded-For")
+		if len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := net.ParseIP(strings.TrimSpace(hops[len(hops)-1])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Audit добавляет запись в журнал аудита. Ошибки записи только логируются,
+// чтобы сбой журнала не ломал основное действие. actorID = 0 означает анонимного пользователя
+func Audit(r *http.Request, actorID int, action, targetType string, targetID int, details string) {
+	_, err := DB.Exec(`
+		INSERT INTO audit_log (actor_id, action, target_type, target_id, ip, user_agent, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, nullableID(actorID), action, targetType, targetID, clientIP(r), r.UserAgent(), details)
+	if err != nil {
+		log.Printf("Ошибка при записи в журнал аудита (%s): %v\n", action, err)
+	}
+}
+
+// SearchAuditLog ищет записи журнала по фильтру, новые записи идут первыми
+func SearchAuditLog(filter AuditFilter, limit int) ([]AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.User != "" {
+		if id, err := strconv.Atoi(filter.User); err == nil {
+			addCondition("a.actor_id = $%d", id)
+		} else {
+			addCondition("u.username ILIKE $%d", filter.User)
+		}
+	}
+	if filter.Action != "" {
+		addCondition("a.action = $%d", filter.Action)
+	}
+	if from, err := time.Parse("2006-01-02", filter.From); err == nil {
+		addCondition("a.created_at >= $%d", from)
+	}
+	if to, err := time.Parse("2006-01-02", filter.To); err == nil {
+		addCondition("a.created_at < $%d", to.AddDate(0, 0, 1))
+	}
+
+	query := `
+		SELECT a.id, COALESCE(a.actor_id, 0), COALESCE(u.username, ''), a.action, a.target_type,
+		       a.target_id, a.ip, a.user_agent, a.details, a.created_at
+		FROM audit_log a
+		LEFT JOIN users u ON u.id = a.actor_id`
+	if len(conditions) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(conditions, " AND ")
+	}
+	query += "\n\t\tORDER BY a.created_at DESC, a.id DESC"
+	if limit > 0 {
+		query += fmt.Sprintf("\n\t\tLIMIT %d", limit)
+	}
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.ActorName, &e.Action, &e.TargetType,
+			&e.TargetID, &e.IP, &e.UserAgent, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func auditFilterFromRequest(r *http.Request) AuditFilter {
+	query := r.URL.Query()
+	return AuditFilter{
+		User:   strings.TrimSpace(query.Get("user")),
+		Action: query.Get("action"),
+		From:   query.Get("from"),
+		To:     query.Get("to"),
+	}
+}
+
+// AuditLogHandler показывает журнал аудита с поиском (только для администраторов)
+func AuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+	if !header.IsAdmin {
+		http.Error(w, "Доступ запрещён", http.StatusForbidden)
+		return
+	}
+
+	filter := auditFilterFromRequest(r)
+	entries, err := SearchAuditLog(filter, auditPageSize)
+	if err != nil {
+		log.Println("Ошибка при поиске по журналу аудита:", err)
+		http.Error(w, "Ошибка при загрузке журнала аудита", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Header  HeaderData
+		Filter  AuditFilter
+		Actions map[string]string
+		Entries []AuditEntry
+		Query   string
+	}{
+		Header:  header,
+		Filter:  filter,
+		Actions: auditActionLabels,
+		Entries: entries,
+		Query:   r.URL.RawQuery,
+	}
+
+	renderTemplate(w, "audit-log.html", data)
+}
+
+// AuditLogExportHandler выгружает журнал аудита в CSV с теми же фильтрами, что и страница
+func AuditLogExportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+	if role, err := GetUserRole(userID); err != nil || role != RoleAdmin {
+		http.Error(w, "Доступ запрещён", http.StatusForbidden)
+		return
+	}
+
+	filter := auditFilterFromRequest(r)
+	entries, err := SearchAuditLog(filter, 0)
+	if err != nil {
+		log.Println("Ошибка при выгрузке журнала аудита:", err)
+		http.Error(w, "Ошибка при выгрузке журнала аудита", http.StatusInternalServerError)
+		return
+	}
+	Audit(r, userID, AuditAuditLogExport, "", 0, r.URL.RawQuery)
+
+	fileName := "audit-" + time.Now().Format("20060102-150405") + ".csv"
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "created_at", "actor_id", "actor", "action", "target_type", "target_id", "ip", "user_agent", "details"})
+	for _, e := range entries {
+		writer.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt.Format(time.RFC3339),
+			strconv.Itoa(e.ActorID),
+			csvSafe(e.ActorName),
+			e.Action,
+			e.TargetType,
+			strconv.Itoa(e.TargetID),
+			csvSafe(e.IP),
+			csvSafe(e.UserAgent),
+			csvSafe(e.Details),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Println("Ошибка при записи CSV журнала аудита:", err)
+	}
+}
+
+// csvSafe экранирует значения, которые табличные редакторы могут выполнить как формулу
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,39 @@
+// internal/audit_test.go
+package internal
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	previous := AppConfig.TrustProxyHeaders
+	t.Cleanup(func() { AppConfig.TrustProxyHeaders = previous })
+
+	tests := []struct {
+		trustProxy bool
+		forwarded  []string
+		want       string
+	}{
+		{false, []string{"203.0.113.7"}, "192.0.2.1"},
+		{true, nil, "192.0.2.1"},
+		{true, []string{"203.0.113.7"}, "203.0.113.7"},
+		// Адреса левее последнего подставил клиент
+		{true, []string{"10.0.0.1, 203.0.113.7"}, "203.0.113.7"},
+		{true, []string{"10.0.0.1", "203.0.113.7 "}, "203.0.113.7"},
+		{true, []string{"2001:db8::1"}, "2001:db8::1"},
+		{true, []string{"203.0.113.7, =HYPERLINK(\"x\")"}, "192.0.2.1"},
+		{true, []string{""}, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		AppConfig.TrustProxyHeaders = tt.trustProxy
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		for _, value := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("доверие прокси %v, X-Forwarded-For %q: %q, ожидался %q", tt.trustProxy, tt.forwarded, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// internal/comments.go
+package internal
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrPostNotFound = errors.New("пост не найден")
+
+// Comment представляет комментарий к посту
+type Comment struct {
+	ID        int
+	PostID    int
+	AuthorID  int
+	Author    string
+	Content   string
+	CreatedAt string
+	Mentions  map[string]int // упомянутые пользователи: имя → ID
+}
+
+// AddComment сохраняет комментарий к посту, доступному пользователю. Задержанный фильтром
+// комментарий не показывается до проверки модератором
+func AddComment(postID, userID int, content string, held bool) (int, error) {
+	visible, err := CanViewPost(postID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if !visible {
+		return 0, ErrPostNotFound
+	}
+
+	var commentID, authorID int
+	err = DB.QueryRow(`
+		INSERT INTO comments (post_id, user_id, content, held_at)
+		VALUES ($1, $2, $3, CASE WHEN $4 THEN NOW() END)
+		RETURNING id, (SELECT user_id FROM posts WHERE id = $1)
+	`, postID, userID, content, held).Scan(&commentID, &authorID)
+	if err != nil {
+		return 0, err
+	}
+	if err := saveCommentMentions(commentID, content); err != nil {
+		log.Println("Ошибка при сохранении упоминаний:", err)
+	}
+
+	// Задержанный комментарий автор поста и упомянутые пользователи пока не видят, поэтому не уведомляем
+	if !held {
+		if err := Notify(authorID, NotificationComment, userID, "post", postID, "Новый комментарий к вашему посту"); err != nil {
+			log.Println("Ошибка при создании уведомления:", err)
+		}
+		if err := notifyMentions(ReportTargetComment, commentID, nil); err != nil {
+			log.Println("Ошибка при уведомлении об упоминаниях:", err)
+		}
+	}
+	return commentID, nil
+}
+
+// LoadComments возвращает видимые комментарии к постам, сгруппированные по ID поста
+func LoadComments(postIDs []int) (map[int][]Comment, error) {
+	comments := make(map[int][]Comment)
+	if len(postIDs) == 0 {
+		return comments, nil
+	}
+
+	rows, err := DB.Query(`
+		SELECT c.id, c.post_id, c.user_id, u.username, c.content, c.created_at
+		FROM comments c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.post_id = ANY($1) AND c.hidden_at IS NULL AND c.held_at IS NULL
+		ORDER BY c.created_at
+	`, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var comment Comment
+		var createdAt time.Time
+		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.AuthorID, &comment.Author, &comment.Content, &createdAt); err != nil {
+			return nil, err
+		}
+		comment.CreatedAt = createdAt.Format("02.01.2006 15:04")
+		comments[comment.PostID] = append(comments[comment.PostID], comment)
+	}
+	return comments, rows.Err()
+}
+
+// attachComments подгружает комментарии к списку постов
+func attachComments(posts []Post) {
+	postIDs := make([]int, 0, len(posts))
+	for _, post := range posts {
+		postIDs = append(postIDs, post.ID)
+	}
+
+	comments, err := LoadComments(postIDs)
+	if err != nil {
+		log.Println("Ошибка при загрузке комментариев:", err)
+		return
+	}
+	for i := range posts {
+		posts[i].Comments = comments[posts[i].ID]
+	}
+}
+
+// CommentHandler добавляет комментарий к посту
+func CommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil || postID <= 0 {
+		http.Error(w, "Некорректный ID поста", http.StatusBadRequest)
+		return
+	}
+
+	// Проверяем текст по правилам фильтра контента
+	decision := CheckContent(r.FormValue("content"), false)
+	if decision.Action == PolicyReject {
+		http.Error(w, decision.Reason, http.StatusBadRequest)
+		return
+	}
+
+	held := decision.Action == PolicyHold
+	commentID, err := AddComment(postID, userID, decision.Content, held)
+	if err == ErrPostNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при сохранении комментария:", err)
+		http.Error(w, "Ошибка при создании комментария", http.StatusInternalServerError)
+		return
+	}
+	if held {
+		holdForReview(ReportTargetComment, commentID, userID, decision)
+	}
+
+	redirectBack(w, r, "/posts")
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"time"
 )
 
 type Config struct {
@@ -15,6 +16,63 @@ type Config struct {
 	DBPassword string `json:"DBPassword"`
 	DBName     string `json:"DBName"`
 	SSLMode    string `json:"SSLMode"`
+
+	// TrustProxyHeaders включает использование X-Forwarded-For для определения IP клиента.
+	// Включайте только если сервер работает за доверенным обратным прокси
+	TrustProxyHeaders bool `json:"TrustProxyHeaders"`
+
+	// AccountDeletionGraceDays — сколько дней аккаунт можно восстановить после запроса на удаление
+	AccountDeletionGraceDays int `json:"AccountDeletionGraceDays"`
+
+	// PubSub выбирает доставку событий реального времени между экземплярами:
+	// "memory" (по умолчанию, один экземпляр) или "postgres" (LISTEN/NOTIFY)
+	PubSub string `json:"PubSub"`
+
+	// Настройки исходящей почты. Без SMTPHost письма только записываются в лог
+	SMTPHost     string `json:"SMTPHost"`
+	SMTPPort     string `json:"SMTPPort"`
+	SMTPUsername string `json:"SMTPUsername"`
+	SMTPPassword string `json:"SMTPPassword"`
+	SMTPFrom     string `json:"SMTPFrom"`
+
+	// BaseURL — внешний адрес сайта для ссылок в письмах
+	BaseURL string `json:"BaseURL"`
+
+	// EmailSigningKey подписывает ссылки отписки в письмах. Если не задан,
+	// ключ создаётся при первом запуске и хранится в БД
+	EmailSigningKey string `json:"EmailSigningKey"`
+
+	// VAPID-ключи для push-уведомлений в формате base64url: закрытый ключ — 32 байта,
+	// открытый — несжатая точка P-256. Если ключей нет, они создаются при запуске и выводятся в лог.
+	// VAPIDSubject — контакт для сервисов доставки, mailto: или https:
+	VAPIDPublicKey  string `json:"VAPIDPublicKey"`
+	VAPIDPrivateKey string `json:"VAPIDPrivateKey"`
+	VAPIDSubject    string `json:"VAPIDSubject"`
+
+	// TimelineFanoutLimit — число читателей, начиная с которого посты автора не раскладываются
+	// по лентам при публикации, а подмешиваются при чтении. По умолчанию 1000
+	TimelineFanoutLimit int `json:"TimelineFanoutLimit"`
+
+	// LinkPreviewAllowPrivate разрешает загружать превью ссылок из частных сетей
+	// и с нестандартных портов. Только для разработки и тестов с локальным сервером
+	LinkPreviewAllowPrivate bool `json:"LinkPreviewAllowPrivate"`
+}
+
+// DeletionGracePeriod возвращает срок, после которого аккаунт удаляется окончательно
+func (c Config) DeletionGracePeriod() time.Duration {
+	days := c.AccountDeletionGraceDays
+	if days <= 0 {
+		days = 14
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// FanoutLimit возвращает порог читателей для раскладки постов по лентам
+func (c Config) FanoutLimit() int {
+	if c.TimelineFanoutLimit <= 0 {
+		return 1000
+	}
+	return c.TimelineFanoutLimit
 }
 
 var AppConfig Config
@@ -0,0 +1,487 @@
+// internal/contentpolicy.go
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Типы правил фильтра контента
+const (
+	RuleTypeWord   = "word"
+	RuleTypeRegex  = "regex"
+	RuleTypeDomain = "domain"
+)
+
+// Действия, которые фильтр применяет к контенту. Порядок важен: более строгое
+// действие имеет больший приоритет
+const (
+	PolicyAllow  = "allow"
+	PolicyMask   = "mask"
+	PolicyHold   = "hold"
+	PolicyReject = "reject"
+)
+
+var policyActionPriority = map[string]int{
+	PolicyAllow:  0,
+	PolicyMask:   1,
+	PolicyHold:   2,
+	PolicyReject: 3,
+}
+
+// policyCacheTTL определяет, как часто правила перечитываются из БД. Это позволяет
+// подхватывать изменения, сделанные на других экземплярах сервера
+const policyCacheTTL = time.Minute
+
+var ErrInvalidRule = errors.New("некорректное правило фильтра")
+
+// ContentRule представляет правило фильтра контента
+type ContentRule struct {
+	ID        int
+	Type      string
+	Pattern   string
+	Action    string
+	Enabled   bool
+	CreatedAt time.Time
+}
+
+// PolicyDecision описывает результат проверки контента. ReportReason задаёт
+// причину системной жалобы, если контент задержан для проверки
+type PolicyDecision struct {
+	Action       string
+	Content      string
+	Reason       string
+	ReportReason string
+}
+
+type compiledRule struct {
+	ContentRule
+	re *regexp.Regexp
+}
+
+// ContentPolicy содержит настройки и скомпилированные правила фильтра
+type ContentPolicy struct {
+	MinLength int
+	MaxLength int
+	rules     []compiledRule
+}
+
+var (
+	policyMu       sync.RWMutex
+	cachedPolicy   *ContentPolicy
+	policyLoadedAt time.Time
+)
+
+// urlPattern находит ссылки в тексте: с протоколом или начинающиеся с www.
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// trimURLMatch отбрасывает с конца найденной ссылки знаки препинания и непарную
+// закрывающую скобку: они считаются концом предложения, а не частью адреса
+func trimURLMatch(link string) string {
+	link = strings.TrimRight(link, ".,;:!?'")
+	if strings.HasSuffix(link, ")") && strings.Count(link, "(") < strings.Count(link, ")") {
+		link = link[:len(link)-1]
+	}
+	return link
+}
+
+// wordBoundary окружает слово проверкой границ, работающей и для кириллицы
+const wordBoundary = `(?:^|[^\p{L}\p{N}_])`
+
+// compileRule подготавливает правило к проверке текста
+func compileRule(rule ContentRule) (compiledRule, error) {
+	compiled := compiledRule{ContentRule: rule}
+	switch rule.Type {
+	case RuleTypeWord:
+		word := strings.TrimSpace(rule.Pattern)
+		if word == "" {
+			return compiled, ErrInvalidRule
+		}
+		compiled.re = regexp.MustCompile(`(?i)` + wordBoundary + `(` + regexp.QuoteMeta(word) + `)(?:$|[^\p{L}\p{N}_])`)
+	case RuleTypeRegex:
+		re, err := regexp.Compile(`(?i)(?:` + rule.Pattern + `)`)
+		if err != nil {
+			return compiled, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+		compiled.re = re
+	case RuleTypeDomain:
+		domain := strings.ToLower(strings.Trim(strings.TrimSpace(rule.Pattern), "."))
+		if domain == "" || strings.ContainsAny(domain, "/ ") {
+			return compiled, ErrInvalidRule
+		}
+		compiled.Pattern = domain
+	default:
+		return compiled, ErrInvalidRule
+	}
+
+	if _, ok := policyActionPriority[rule.Action]; !ok || rule.Action == PolicyAllow {
+		return compiled, ErrInvalidRule
+	}
+	return compiled, nil
+}
+
+// loadContentPolicy читает настройки и включённые правила из БД
+func loadContentPolicy() (*ContentPolicy, error) {
+	policy := &ContentPolicy{}
+	err := DB.QueryRow(`SELECT min_length, max_length FROM content_policy_settings WHERE id = 1`).
+		Scan(&policy.MinLength, &policy.MaxLength)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	rules, err := ListContentRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		compiled, err := compileRule(rule)
+		if err != nil {
+			log.Printf("Правило фильтра #%d пропущено: %v\n", rule.ID, err)
+			continue
+		}
+		policy.rules = append(policy.rules, compiled)
+	}
+	return policy, nil
+}
+
+// currentContentPolicy возвращает закешированную политику, перечитывая её по истечении TTL
+func currentContentPolicy() *ContentPolicy {
+	policyMu.RLock()
+	policy, loadedAt := cachedPolicy, policyLoadedAt
+	policyMu.RUnlock()
+	if policy != nil && time.Since(loadedAt) < policyCacheTTL {
+		return policy
+	}
+
+	fresh, err := loadContentPolicy()
+	if err != nil {
+		log.Println("Ошибка при загрузке правил фильтра:", err)
+		if policy != nil {
+			return policy
+		}
+		return &ContentPolicy{}
+	}
+
+	policyMu.Lock()
+	cachedPolicy, policyLoadedAt = fresh, time.Now()
+	policyMu.Unlock()
+	return fresh
+}
+
+// invalidateContentPolicy сбрасывает кеш после изменения правил администратором
+func invalidateContentPolicy() {
+	policyMu.Lock()
+	cachedPolicy = nil
+	policyMu.Unlock()
+}
+
+// CheckContent проверяет текст поста или комментария по текущей политике
+func CheckContent(content string, hasMedia bool) PolicyDecision {
+	return currentContentPolicy().Check(content, hasMedia)
+}
+
+// Check применяет ограничения длины и правила к тексту
+func (p *ContentPolicy) Check(content string, hasMedia bool) PolicyDecision {
+	decision := PolicyDecision{Action: PolicyAllow, Content: content}
+	length := utf8.RuneCountInString(strings.TrimSpace(content))
+
+	if length == 0 && !hasMedia {
+		return PolicyDecision{Action: PolicyReject, Content: content, Reason: "Нельзя опубликовать пустое сообщение"}
+	}
+	if p.MinLength > 0 && length < p.MinLength && !hasMedia {
+		return PolicyDecision{Action: PolicyReject, Content: content,
+			Reason: fmt.Sprintf("Текст должен быть не короче %d символов", p.MinLength)}
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return PolicyDecision{Action: PolicyReject, Content: content,
+			Reason: fmt.Sprintf("Текст должен быть не длиннее %d символов", p.MaxLength)}
+	}
+
+	for _, rule := range p.rules {
+		matches := rule.match(decision.Content)
+		if len(matches) == 0 {
+			continue
+		}
+
+		if rule.Action == PolicyMask {
+			decision.Content = maskRanges(decision.Content, matches)
+		}
+		if policyActionPriority[rule.Action] > policyActionPriority[decision.Action] {
+			decision.Action = rule.Action
+			decision.Reason = rule.describe()
+			decision.ReportReason = "policy"
+		}
+	}
+
+	if decision.Action == PolicyReject {
+		decision.Content = content
+	}
+	return decision
+}
+
+// match возвращает байтовые диапазоны текста, нарушающие правило
+func (r compiledRule) match(content string) [][2]int {
+	var ranges [][2]int
+	if r.Type == RuleTypeDomain {
+		// Ссылка обрезается так же, как при показе текста, иначе знак препинания
+		// в конце попал бы в имя сайта и ссылка прошла бы мимо правила
+		for _, loc := range urlPattern.FindAllStringIndex(content, -1) {
+			link := trimURLMatch(content[loc[0]:loc[1]])
+			if hostMatchesDomain(link, r.Pattern) {
+				ranges = append(ranges, [2]int{loc[0], loc[0] + len(link)})
+			}
+		}
+		return ranges
+	}
+
+	if r.Type == RuleTypeRegex {
+		// Берётся всё совпадение: группы внутри шаблона принадлежат автору правила
+		for _, loc := range r.re.FindAllStringIndex(content, -1) {
+			if loc[1] > loc[0] {
+				ranges = append(ranges, [2]int{loc[0], loc[1]})
+			}
+		}
+		return ranges
+	}
+
+	// Для слов продолжаем поиск сразу после найденного слова, чтобы разделитель
+	// между двумя подряд идущими совпадениями не был поглощён первым из них
+	for pos := 0; pos < len(content); {
+		loc := r.re.FindStringSubmatchIndex(content[pos:])
+		if loc == nil {
+			break
+		}
+		ranges = append(ranges, [2]int{pos + loc[2], pos + loc[3]})
+		pos += loc[3]
+	}
+	return ranges
+}
+
+func (r compiledRule) describe() string {
+	switch r.Type {
+	case RuleTypeWord:
+		return "Текст содержит запрещённое слово"
+	case RuleTypeDomain:
+		return "Текст содержит ссылку на запрещённый сайт"
+	default:
+		return "Текст нарушает правила сообщества"
+	}
+}
+
+// hostMatchesDomain проверяет, относится ли ссылка к домену или его поддоменам.
+// Точка в конце имени сайта не меняет адрес. Ссылку, которую не удалось разобрать,
+// считаем нарушающей правило: по ней нельзя проверить, куда она ведёт
+func hostMatchesDomain(link, domain string) bool {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	parsed, err := url.Parse(link)
+	if err != nil || parsed.Hostname() == "" {
+		return true
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// maskRanges заменяет символы в указанных диапазонах звёздочками
+func maskRanges(content string, ranges [][2]int) string {
+	var b strings.Builder
+	last := 0
+	for _, rng := range ranges {
+		if rng[0] < last {
+			continue
+		}
+		b.WriteString(content[last:rng[0]])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(content[rng[0]:rng[1]])))
+		last = rng[1]
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// ListContentRules возвращает все правила фильтра
+func ListContentRules() ([]ContentRule, error) {
+	rows, err := DB.Query(`
+		SELECT id, rule_type, pattern, action, enabled, created_at
+		FROM content_rules
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []ContentRule
+	for rows.Next() {
+		var rule ContentRule
+		if err := rows.Scan(&rule.ID, &rule.Type, &rule.Pattern, &rule.Action, &rule.Enabled, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// AddContentRule проверяет и сохраняет новое правило
+func AddContentRule(ruleType, pattern, action string) error {
+	rule := ContentRule{Type: ruleType, Pattern: pattern, Action: action, Enabled: true}
+	compiled, err := compileRule(rule)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		INSERT INTO content_rules (rule_type, pattern, action)
+		VALUES ($1, $2, $3)
+	`, ruleType, compiled.Pattern, action)
+	if err == nil {
+		invalidateContentPolicy()
+	}
+	return err
+}
+
+// SetContentRuleEnabled включает или выключает правило
+func SetContentRuleEnabled(ruleID int, enabled bool) error {
+	_, err := DB.Exec(`UPDATE content_rules SET enabled = $2 WHERE id = $1`, ruleID, enabled)
+	if err == nil {
+		invalidateContentPolicy()
+	}
+	return err
+}
+
+// DeleteContentRule удаляет правило
+func DeleteContentRule(ruleID int) error {
+	_, err := DB.Exec(`DELETE FROM content_rules WHERE id = $1`, ruleID)
+	if err == nil {
+		invalidateContentPolicy()
+	}
+	return err
+}
+
+// SaveContentLengthLimits сохраняет ограничения длины текста (0 — без ограничения)
+func SaveContentLengthLimits(minLength, maxLength int) error {
+	if minLength < 0 || maxLength < 0 || (maxLength > 0 && minLength > maxLength) {
+		return ErrInvalidRule
+	}
+	_, err := DB.Exec(`
+		INSERT INTO content_policy_settings (id, min_length, max_length, updated_at)
+		VALUES (1, $1, $2, NOW())
+		ON CONFLICT (id) DO UPDATE SET min_length = $1, max_length = $2, updated_at = NOW()
+	`, minLength, maxLength)
+	if err == nil {
+		invalidateContentPolicy()
+	}
+	return err
+}
+
+// holdForReview отправляет задержанный контент в очередь модерации и уведомляет автора
+func holdForReview(targetType string, targetID, authorID int, decision PolicyDecision) {
+	if err := CreateReport(0, targetType, targetID, decision.ReportReason, decision.Reason); err != nil {
+		log.Println("Ошибка при создании жалобы на задержанный контент:", err)
+	}
+	message := "Ваша публикация отправлена на проверку модератору: " + decision.Reason
+	if err := Notify(authorID, NotificationContentHeld, 0, targetType, targetID, message); err != nil {
+		log.Println("Ошибка при отправке уведомления о задержке контента:", err)
+	}
+}
+
+// ContentPolicyHandler показывает и изменяет настройки фильтра контента (только для администраторов)
+func ContentPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+	if !header.IsAdmin {
+		http.Error(w, "Доступ запрещён", http.StatusForbidden)
+		return
+	}
+
+	var errorMsg string
+	if r.Method == http.MethodPost {
+		ruleID, _ := strconv.Atoi(r.FormValue("rule_id"))
+		switch r.FormValue("action") {
+		case "save_limits":
+			minLength, _ := strconv.Atoi(r.FormValue("min_length"))
+			maxLength, _ := strconv.Atoi(r.FormValue("max_length"))
+			err = SaveContentLengthLimits(minLength, maxLength)
+		case "add_rule":
+			err = AddContentRule(r.FormValue("rule_type"), r.FormValue("pattern"), r.FormValue("rule_action"))
+		case "enable_rule":
+			err = SetContentRuleEnabled(ruleID, true)
+		case "disable_rule":
+			err = SetContentRuleEnabled(ruleID, false)
+		case "delete_rule":
+			err = DeleteContentRule(ruleID)
+		default:
+			http.Error(w, "Неизвестное действие", http.StatusBadRequest)
+			return
+		}
+
+		if errors.Is(err, ErrInvalidRule) {
+			errorMsg = err.Error()
+		} else if err != nil {
+			log.Println("Ошибка при изменении правил фильтра:", err)
+			http.Error(w, "Ошибка при сохранении правил фильтра", http.StatusInternalServerError)
+			return
+		} else {
+			details := r.FormValue("action")
+			if ruleID > 0 {
+				details += fmt.Sprintf(", rule_id: %d", ruleID)
+			}
+			if r.FormValue("action") == "save_limits" {
+				details += fmt.Sprintf(", min_length: %s, max_length: %s", r.FormValue("min_length"), r.FormValue("max_length"))
+			}
+			if pattern := r.FormValue("pattern"); pattern != "" {
+				details += fmt.Sprintf(", %s %q -> %s", r.FormValue("rule_type"), pattern, r.FormValue("rule_action"))
+			}
+			Audit(r, userID, AuditContentPolicyEdit, "content_policy", ruleID, details)
+			http.Redirect(w, r, "/admin/content-policy", http.StatusSeeOther)
+			return
+		}
+	}
+
+	rules, err := ListContentRules()
+	if err != nil {
+		log.Println("Ошибка при загрузке правил фильтра:", err)
+		http.Error(w, "Ошибка при загрузке правил фильтра", http.StatusInternalServerError)
+		return
+	}
+	policy := currentContentPolicy()
+
+	data := struct {
+		Header    HeaderData
+		ErrorMsg  string
+		MinLength int
+		MaxLength int
+		Rules     []ContentRule
+	}{
+		Header:    header,
+		ErrorMsg:  errorMsg,
+		MinLength: policy.MinLength,
+		MaxLength: policy.MaxLength,
+		Rules:     rules,
+	}
+
+	renderTemplate(w, "content-policy.html", data)
+}
@@ -0,0 +1,105 @@
+// internal/contentpolicy_test.go
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDomainRuleMatchesRenderedLinks(t *testing.T) {
+	rule, err := compileRule(ContentRule{Type: RuleTypeDomain, Pattern: "Evil.com.", Action: PolicyReject, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &ContentPolicy{rules: []compiledRule{rule}}
+
+	cases := []struct {
+		content string
+		blocked bool
+	}{
+		{"см. https://evil.com", true},
+		{"см. https://evil.com, там всё", true},
+		{"в конце предложения https://evil.com.", true},
+		{"вопрос https://evil.com?", true},
+		{"точка в имени сайта https://evil.com./path", true},
+		{"в скобках (https://evil.com)", true},
+		{"поддомен https://sub.EVIL.com/path", true},
+		{"без протокола www.evil.com!", true},
+		{"с портом http://evil.com:8080/", true},
+		{"неразбираемая ссылка https://evil.com/%zz", true},
+		{"неразбираемая ссылка без сайта https://%zz", true},
+		{"другой сайт https://notevil.com", false},
+		{"сайт в параметре https://good.com/?q=evil.com", false},
+		{"просто текст про evil.com", false},
+	}
+	for _, c := range cases {
+		decision := policy.Check(c.content, false)
+		if blocked := decision.Action == PolicyReject; blocked != c.blocked {
+			t.Errorf("%q: blocked = %v, want %v", c.content, blocked, c.blocked)
+		}
+
+		// Ссылка, которую рендерер покажет на запрещённый сайт, должна блокироваться
+		if _, _, href := findURL(c.content); href != "" && !c.blocked && hostMatchesDomain(href, "evil.com") {
+			t.Errorf("%q: рендерер показывает ссылку %q, которую фильтр пропускает", c.content, href)
+		}
+	}
+}
+
+func TestDomainRuleMasksOnlyLink(t *testing.T) {
+	rule, err := compileRule(ContentRule{Type: RuleTypeDomain, Pattern: "evil.com", Action: PolicyMask, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &ContentPolicy{rules: []compiledRule{rule}}
+
+	link := "https://evil.com"
+	decision := policy.Check("см. "+link+", там всё", false)
+	if want := "см. " + strings.Repeat("*", len(link)) + ", там всё"; decision.Content != want {
+		t.Errorf("got %q, want %q", decision.Content, want)
+	}
+}
+
+func TestRegexRuleMasksWholeMatch(t *testing.T) {
+	cases := []struct {
+		pattern, content, want string
+	}{
+		{`sp[a@]m`, "Это SP@M и spam", "Это **** и ****"},
+		// Группы шаблона не сужают совпадение
+		{`(free) money`, "free money здесь", "********** здесь"},
+		// Скобки шаблона не могут выйти за пределы обёртки правила
+		{`foo)|(bar`, "foo и bar", "*** и ***"},
+		{`x*`, "без совпадений", "без совпадений"},
+	}
+	for _, c := range cases {
+		rule, err := compileRule(ContentRule{Type: RuleTypeRegex, Pattern: c.pattern, Action: PolicyMask, Enabled: true})
+		if err != nil {
+			t.Errorf("%q: %v", c.pattern, err)
+			continue
+		}
+		policy := &ContentPolicy{rules: []compiledRule{rule}}
+		if got := policy.Check(c.content, false).Content; got != c.want {
+			t.Errorf("%q в %q: got %q, want %q", c.pattern, c.content, got, c.want)
+		}
+	}
+}
+
+func TestHostMatchesDomain(t *testing.T) {
+	cases := []struct {
+		link  string
+		match bool
+	}{
+		{"https://evil.com", true},
+		{"https://evil.com.", true},
+		{"https://EVIL.COM/x", true},
+		{"www.a.evil.com", true},
+		{"https://evil.com/%zz", true},
+		{"https://", true},
+		{"https://evil.community", false},
+		{"https://notevil.com", false},
+	}
+	for _, c := range cases {
+		if got := hostMatchesDomain(c.link, "evil.com"); got != c.match {
+			t.Errorf("%q: got %v, want %v", c.link, got, c.match)
+		}
+	}
+}
@@ -0,0 +1,533 @@
+// internal/dataexport.go
+package internal
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportDir — директория для готовых архивов с персональными данными
+const exportDir = "./exports"
+
+// exportRetention — сколько хранится готовый архив
+const exportRetention = 7 * 24 * time.Hour
+
+// Статусы задач выгрузки
+const (
+	ExportStatusPending = "pending"
+	ExportStatusRunning = "running"
+	ExportStatusReady   = "ready"
+	ExportStatusFailed  = "failed"
+)
+
+// DataExport представляет задачу выгрузки персональных данных
+type DataExport struct {
+	ID          int
+	Status      string
+	CreatedAt   time.Time
+	CompletedAt sql.NullTime
+}
+
+// personalData — содержимое data.json в архиве
+type personalData struct {
+	GeneratedAt time.Time        `json:"generated_at"`
+	Profile     exportProfile    `json:"profile"`
+	Posts       []exportPost     `json:"posts"`
+	Comments    []exportComment  `json:"comments"`
+	Reactions   []exportReaction `json:"reactions"`
+	Friendships []exportFriend   `json:"friendships"`
+	Follows     []exportFriend   `json:"follows"`
+	Reports     []exportReport   `json:"reports"`
+	Messages    []exportMessage  `json:"messages"`
+}
+
+type exportProfile struct {
+	ID               int       `json:"id"`
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	RegistrationDate time.Time `json:"registration_date"`
+	Avatar           string    `json:"avatar,omitempty"`
+}
+
+type exportPost struct {
+	ID        int                `json:"id"`
+	Content   string             `json:"content"`
+	Media     []exportAttachment `json:"media,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+type exportAttachment struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	AltText string `json:"alt_text,omitempty"`
+}
+
+type exportComment struct {
+	ID        int       `json:"id"`
+	PostID    int       `json:"post_id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportReaction struct {
+	PostID    int       `json:"post_id"`
+	Reaction  string    `json:"reaction"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportFriend struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Direction string    `json:"direction"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportMessage struct {
+	ConversationID int       `json:"conversation_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type exportReport struct {
+	TargetType string    `json:"target_type"`
+	TargetID   int       `json:"target_id"`
+	Reason     string    `json:"reason"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// RequestDataExport ставит задачу выгрузки в очередь, если у пользователя нет незавершённой
+func RequestDataExport(userID int) error {
+	_, err := DB.Exec(`
+		INSERT INTO data_exports (user_id)
+		SELECT $1
+		WHERE NOT EXISTS (
+			SELECT 1 FROM data_exports WHERE user_id = $1 AND status IN ('pending', 'running')
+		)
+	`, userID)
+	return err
+}
+
+// ListDataExports возвращает задачи выгрузки пользователя
+func ListDataExports(userID int) ([]DataExport, error) {
+	rows, err := DB.Query(`
+		SELECT id, status, created_at, completed_at
+		FROM data_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT 10
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []DataExport
+	for rows.Next() {
+		var e DataExport
+		if err := rows.Scan(&e.ID, &e.Status, &e.CreatedAt, &e.CompletedAt); err != nil {
+			return nil, err
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+// processDataExports выполняет задачи выгрузки из очереди. Задачи забираются
+// с SKIP LOCKED, поэтому несколько экземпляров сервера не обработают одну задачу дважды
+func processDataExports() error {
+	for {
+		var exportID, userID int
+		err := DB.QueryRow(`
+			UPDATE data_exports
+			SET status = 'running'
+			WHERE id = (
+				SELECT id FROM data_exports
+				WHERE status = 'pending'
+				ORDER BY created_at
+				FOR UPDATE SKIP LOCKED
+				LIMIT 1
+			)
+			RETURNING id, user_id
+		`).Scan(&exportID, &userID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		filePath, err := buildDataExport(exportID, userID)
+		if err != nil {
+			log.Printf("Ошибка при выгрузке данных пользователя %d: %v\n", userID, err)
+			_, err = DB.Exec(`
+				UPDATE data_exports SET status = 'failed', error = $2, completed_at = NOW() WHERE id = $1
+			`, exportID, err.Error())
+		} else {
+			_, err = DB.Exec(`
+				UPDATE data_exports SET status = 'ready', file_path = $2, completed_at = NOW() WHERE id = $1
+			`, exportID, filePath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// buildDataExport собирает ZIP-архив с data.json и загруженными пользователем файлами
+func buildDataExport(exportID, userID int) (string, error) {
+	data, media, err := collectPersonalData(userID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(exportDir, 0o700); err != nil {
+		return "", fmt.Errorf("не удалось создать директорию для выгрузок: %v", err)
+	}
+	filePath := filepath.Join(exportDir, fmt.Sprintf("export-%d-%d.zip", userID, exportID))
+	tmpPath := filePath + ".tmp"
+
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("не удалось создать архив: %v", err)
+	}
+	defer os.Remove(tmpPath)
+
+	archive := zip.NewWriter(out)
+	entry, err := archive.Create("data.json")
+	if err != nil {
+		out.Close()
+		return "", err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		out.Close()
+		return "", err
+	}
+
+	for archivePath, sourcePath := range media {
+		if err := addFileToZip(archive, archivePath, sourcePath); err != nil {
+			// Отсутствующий файл не должен ломать всю выгрузку
+			log.Printf("Файл %s не добавлен в выгрузку: %v\n", sourcePath, err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return filePath, os.Rename(tmpPath, filePath)
+}
+
+func addFileToZip(archive *zip.Writer, archivePath, sourcePath string) error {
+	in, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	entry, err := archive.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, in)
+	return err
+}
+
+// uploadedFilePath возвращает путь к файлу в директории загрузок или пустую строку,
+// если путь указывает за её пределы (например, на статический аватар по умолчанию)
+func uploadedFilePath(storedPath string) string {
+	if storedPath == "" {
+		return ""
+	}
+	cleaned := filepath.Clean(strings.TrimPrefix(storedPath, "/"))
+	uploads := filepath.Clean(uploadDir)
+	if !strings.HasPrefix(cleaned, uploads+string(filepath.Separator)) {
+		return ""
+	}
+	return cleaned
+}
+
+// collectPersonalData собирает все данные пользователя. Второе значение —
+// соответствие путей в архиве путям к исходным файлам
+func collectPersonalData(userID int) (personalData, map[string]string, error) {
+	data := personalData{GeneratedAt: time.Now()}
+	media := make(map[string]string)
+
+	addMedia := func(storedPath string) string {
+		source := uploadedFilePath(storedPath)
+		if source == "" {
+			return ""
+		}
+		archivePath := "media/" + filepath.Base(source)
+		media[archivePath] = source
+		return archivePath
+	}
+
+	var avatarURL sql.NullString
+	err := DB.QueryRow(`
+		SELECT id, username, email, registration_date, avatar_url
+		FROM users
+		WHERE id = $1
+	`, userID).Scan(&data.Profile.ID, &data.Profile.Username, &data.Profile.Email, &data.Profile.RegistrationDate, &avatarURL)
+	if err != nil {
+		return data, nil, err
+	}
+	data.Profile.Avatar = addMedia(avatarURL.String)
+
+	rows, err := DB.Query(`
+		SELECT id, content, created_at
+		FROM posts
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return data, nil, err
+	}
+	for rows.Next() {
+		var post exportPost
+		if err := rows.Scan(&post.ID, &post.Content, &post.CreatedAt); err != nil {
+			rows.Close()
+			return data, nil, err
+		}
+		data.Posts = append(data.Posts, post)
+	}
+	rows.Close()
+
+	postIDs := make([]int, 0, len(data.Posts))
+	for _, post := range data.Posts {
+		postIDs = append(postIDs, post.ID)
+	}
+	attachments, err := loadAttachments(postIDs)
+	if err != nil {
+		return data, nil, err
+	}
+	for i, post := range data.Posts {
+		for _, attachment := range attachments[post.ID] {
+			if path := addMedia(attachment.Path); path != "" {
+				data.Posts[i].Media = append(data.Posts[i].Media, exportAttachment{Kind: attachment.Kind, Path: path, AltText: attachment.AltText})
+			}
+		}
+	}
+
+	rows, err = DB.Query(`
+		SELECT id, post_id, content, created_at
+		FROM comments
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return data, nil, err
+	}
+	for rows.Next() {
+		var comment exportComment
+		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.Content, &comment.CreatedAt); err != nil {
+			rows.Close()
+			return data, nil, err
+		}
+		data.Comments = append(data.Comments, comment)
+	}
+	rows.Close()
+
+	rows, err = DB.Query(`
+		SELECT post_id, reaction, created_at
+		FROM post_reactions
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return data, nil, err
+	}
+	for rows.Next() {
+		var reaction exportReaction
+		if err := rows.Scan(&reaction.PostID, &reaction.Reaction, &reaction.CreatedAt); err != nil {
+			rows.Close()
+			return data, nil, err
+		}
+		data.Reactions = append(data.Reactions, reaction)
+	}
+	rows.Close()
+
+	rows, err = DB.Query(`
+		SELECT u.id, u.username, 'added_by_me', f.created_at
+		FROM friendships f JOIN users u ON u.id = f.friend_id
+		WHERE f.user_id = $1
+		UNION ALL
+		SELECT u.id, u.username, 'added_me', f.created_at
+		FROM friendships f JOIN users u ON u.id = f.user_id
+		WHERE f.friend_id = $1
+		ORDER BY 4
+	`, userID)
+	if err != nil {
+		return data, nil, err
+	}
+	for rows.Next() {
+		var friend exportFriend
+		if err := rows.Scan(&friend.UserID, &friend.Username, &friend.Direction, &friend.CreatedAt); err != nil {
+			rows.Close()
+			return data, nil, err
+		}
+		data.Friendships = append(data.Friendships, friend)
+	}
+	rows.Close()
+
+	rows, err = DB.Query(`
+		SELECT u.id, u.username, 'following', f.created_at
+		FROM follows f JOIN users u ON u.id = f.followee_id
+		WHERE f.follower_id = $1
+		UNION ALL
+		SELECT u.id, u.username, 'follower', f.created_at
+		FROM follows f JOIN users u ON u.id = f.follower_id
+		WHERE f.followee_id = $1
+		ORDER BY 4
+	`, userID)
+	if err != nil {
+		return data, nil, err
+	}
+	for rows.Next() {
+		var follow exportFriend
+		if err := rows.Scan(&follow.UserID, &follow.Username, &follow.Direction, &follow.CreatedAt); err != nil {
+			rows.Close()
+			return data, nil, err
+		}
+		data.Follows = append(data.Follows, follow)
+	}
+	rows.Close()
+
+	rows, err = DB.Query(`
+		SELECT target_type, target_id, reason, status, created_at
+		FROM reports
+		WHERE reporter_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return data, nil, err
+	}
+	for rows.Next() {
+		var report exportReport
+		if err := rows.Scan(&report.TargetType, &report.TargetID, &report.Reason, &report.Status, &report.CreatedAt); err != nil {
+			rows.Close()
+			return data, nil, err
+		}
+		data.Reports = append(data.Reports, report)
+	}
+	rows.Close()
+
+	rows, err = DB.Query(`
+		SELECT conversation_id, body, created_at
+		FROM messages
+		WHERE sender_id = $1 AND kind = 'text'
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return data, nil, err
+	}
+	for rows.Next() {
+		var message exportMessage
+		if err := rows.Scan(&message.ConversationID, &message.Body, &message.CreatedAt); err != nil {
+			rows.Close()
+			return data, nil, err
+		}
+		data.Messages = append(data.Messages, message)
+	}
+	rows.Close()
+
+	return data, media, nil
+}
+
+// cleanupDataExports удаляет устаревшие архивы
+func cleanupDataExports() error {
+	rows, err := DB.Query(`
+		DELETE FROM data_exports
+		WHERE completed_at < $1
+		RETURNING file_path
+	`, time.Now().Add(-exportRetention))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var filePath string
+		if err := rows.Scan(&filePath); err != nil {
+			return err
+		}
+		if filePath != "" {
+			if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+				log.Println("Ошибка при удалении архива выгрузки:", err)
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// DataExportHandler ставит в очередь выгрузку персональных данных
+func DataExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	if err := RequestDataExport(userID); err != nil {
+		log.Println("Ошибка при создании задачи выгрузки:", err)
+		http.Error(w, "Ошибка при создании выгрузки", http.StatusInternalServerError)
+		return
+	}
+	Audit(r, userID, AuditDataExport, "user", userID, "")
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// DataExportDownloadHandler отдаёт готовый архив его владельцу
+func DataExportDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	exportID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Некорректный ID выгрузки", http.StatusBadRequest)
+		return
+	}
+
+	var filePath string
+	err = DB.QueryRow(`
+		SELECT file_path
+		FROM data_exports
+		WHERE id = $1 AND user_id = $2 AND status = 'ready'
+	`, exportID, userID).Scan(&filePath)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при получении выгрузки:", err)
+		http.Error(w, "Ошибка при загрузке выгрузки", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="my-data.zip"`)
+	http.ServeFile(w, r, filePath)
+}
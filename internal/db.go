@@ -11,13 +11,15 @@ import (
 
 var DB *sql.DB
 
-func InitDB() {
-	var err error
-	// Строка подключения с использованием конфигурации
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+// connectionString возвращает строку подключения к БД из конфигурации
+func connectionString() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		AppConfig.DBHost, AppConfig.DBPort, AppConfig.DBUser, AppConfig.DBPassword, AppConfig.DBName, AppConfig.SSLMode)
+}
 
-	DB, err = sql.Open("postgres", connStr)
+func InitDB() {
+	var err error
+	DB, err = sql.Open("postgres", connectionString())
 	if err != nil {
 		log.Fatal("Ошибка подключения к БД:", err)
 	}
@@ -28,4 +30,7 @@ func InitDB() {
 		log.Fatal("Ошибка подключения к БД:", err)
 	}
 	fmt.Println("Успешное подключение к БД!")
+
+	// Применяем миграции схемы
+	migrateDB()
 }
@@ -0,0 +1,77 @@
+// internal/db_test.go
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// openTestDB подключает тест к БД из TEST_DATABASE_URL и применяет миграции.
+// Базовые таблицы users, posts и friendships, как и на сервере, должны уже быть созданы.
+// Без TEST_DATABASE_URL тест пропускается
+func openTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL не задан")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+
+	previous := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = previous
+		db.Close()
+	})
+	migrateDB()
+}
+
+// createTestUser создаёт пользователя и удаляет его вместе с постами и дружбой после теста
+func createTestUser(t *testing.T, name string) int {
+	t.Helper()
+	username := fmt.Sprintf("%s_%d", name, time.Now().UnixNano())
+	var userID int
+	err := DB.QueryRow(`
+		INSERT INTO users (username, email, password_hash) VALUES ($1, $2, '') RETURNING id
+	`, username, username+"@example.com").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		for _, statement := range []string{
+			`DELETE FROM posts WHERE user_id = $1`,
+			`DELETE FROM friendships WHERE user_id = $1 OR friend_id = $1`,
+			`DELETE FROM users WHERE id = $1`,
+		} {
+			if _, err := DB.Exec(statement, userID); err != nil {
+				t.Errorf("%s: %v", statement, err)
+			}
+		}
+	})
+	return userID
+}
+
+// inRepoRoot переходит на время теста в корень репозитория, откуда загружаются шаблоны
+func inRepoRoot(t *testing.T) {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+}
@@ -0,0 +1,596 @@
+// internal/emails.go
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// Частота дайджестов
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// Области отписки: всё сразу, только дайджест или один тип уведомлений
+const (
+	UnsubscribeAll    = "all"
+	UnsubscribeDigest = "digest"
+)
+
+const (
+	// emailMaxAttempts — сколько раз пытаемся отправить письмо
+	emailMaxAttempts = 5
+	// emailRetention — сколько хранятся отправленные и окончательно не отправленные письма
+	emailRetention = 7 * 24 * time.Hour
+	// digestPostLimit — сколько постов попадает в один дайджест
+	digestPostLimit = 20
+	// digestExcerptLength — длина отрывка поста в дайджесте
+	digestExcerptLength = 300
+)
+
+// emailNotificationKinds — уведомления, о которых можно получать письма
+var emailNotificationKinds = []Option{
+	{Value: NotificationFriendAdded, Label: "Вас добавили в друзья"},
+	{Value: NotificationFollow, Label: "Новые подписчики"},
+	{Value: NotificationMention, Label: "Упоминания"},
+	{Value: NotificationComment, Label: "Комментарии к вашим постам"},
+	{Value: NotificationReaction, Label: "Реакции на ваши посты"},
+	{Value: NotificationReportResolved, Label: "Решения по вашим жалобам"},
+	{Value: NotificationContentHeld, Label: "Публикации, отправленные на проверку"},
+}
+
+// digestOptions — варианты частоты дайджеста
+var digestOptions = []Option{
+	{Value: DigestOff, Label: "Не присылать"},
+	{Value: DigestDaily, Label: "Раз в день"},
+	{Value: DigestWeekly, Label: "Раз в неделю"},
+}
+
+// EmailPreferences — почтовые настройки пользователя
+type EmailPreferences struct {
+	ImmediateKinds []string
+	Digest         string
+}
+
+// Wants проверяет, хочет ли пользователь получать письма об уведомлениях этого типа
+func (p EmailPreferences) Wants(kind string) bool {
+	for _, k := range p.ImmediateKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+var emailSigningKey []byte
+
+// initEmailSigningKey загружает ключ подписи ссылок отписки. Если ключ не задан
+// в конфигурации, он создаётся один раз и хранится в БД, чтобы ссылки из уже
+// отправленных писем работали после перезапуска и на всех экземплярах сервера
+func initEmailSigningKey() {
+	if AppConfig.EmailSigningKey != "" {
+		emailSigningKey = []byte(AppConfig.EmailSigningKey)
+		return
+	}
+	key, err := loadOrCreateSecret("email_signing_key")
+	if err != nil {
+		log.Fatal("Ошибка при загрузке ключа подписи ссылок отписки:", err)
+	}
+	emailSigningKey = key
+}
+
+// loadOrCreateSecret возвращает секрет из БД, создавая его при первом запуске.
+// Если несколько экземпляров запускаются одновременно, все получат один секрет
+func loadOrCreateSecret(name string) ([]byte, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	_, err := DB.Exec(`
+		INSERT INTO app_secrets (name, value) VALUES ($1, $2)
+		ON CONFLICT (name) DO NOTHING
+	`, name, base64.RawURLEncoding.EncodeToString(random))
+	if err != nil {
+		return nil, err
+	}
+
+	var value string
+	err = DB.QueryRow(`SELECT value FROM app_secrets WHERE name = $1`, name).Scan(&value)
+	return []byte(value), err
+}
+
+// siteURL возвращает абсолютный адрес страницы сайта
+func siteURL(path string) string {
+	base := strings.TrimRight(AppConfig.BaseURL, "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return base + path
+}
+
+// unsubscribeSignature подписывает ссылку отписки для пользователя и области
+func unsubscribeSignature(userID int, scope string) string {
+	mac := hmac.New(sha256.New, emailSigningKey)
+	fmt.Fprintf(mac, "unsubscribe:%d:%s", userID, scope)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// unsubscribeURL возвращает подписанную ссылку отписки
+func unsubscribeURL(userID int, scope string) string {
+	query := url.Values{
+		"u":   {strconv.Itoa(userID)},
+		"s":   {scope},
+		"sig": {unsubscribeSignature(userID, scope)},
+	}
+	return siteURL("/email/unsubscribe?" + query.Encode())
+}
+
+// defaultEmailPreferences возвращает настройки пользователя, который их ещё не сохранял
+func defaultEmailPreferences() EmailPreferences {
+	return EmailPreferences{
+		ImmediateKinds: []string{NotificationFriendAdded, NotificationComment, NotificationMention},
+		Digest:         DigestWeekly,
+	}
+}
+
+// GetEmailPreferences возвращает почтовые настройки пользователя или настройки по умолчанию
+func GetEmailPreferences(userID int) (EmailPreferences, error) {
+	prefs := defaultEmailPreferences()
+	err := DB.QueryRow(`
+		SELECT immediate_kinds, digest FROM email_preferences WHERE user_id = $1
+	`, userID).Scan(pq.Array(&prefs.ImmediateKinds), &prefs.Digest)
+	if err == sql.ErrNoRows {
+		return prefs, nil
+	}
+	return prefs, err
+}
+
+// SaveEmailPreferences сохраняет почтовые настройки пользователя
+func SaveEmailPreferences(userID int, prefs EmailPreferences) error {
+	kinds := []string{}
+	for _, option := range emailNotificationKinds {
+		if prefs.Wants(option.Value) {
+			kinds = append(kinds, option.Value)
+		}
+	}
+	switch prefs.Digest {
+	case DigestOff, DigestDaily, DigestWeekly:
+	default:
+		prefs.Digest = DigestWeekly
+	}
+
+	_, err := DB.Exec(`
+		INSERT INTO email_preferences (user_id, immediate_kinds, digest)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET immediate_kinds = EXCLUDED.immediate_kinds, digest = EXCLUDED.digest, updated_at = NOW()
+	`, userID, pq.Array(kinds), prefs.Digest)
+	return err
+}
+
+// Unsubscribe отключает письма указанной области
+func Unsubscribe(userID int, scope string) error {
+	prefs, err := GetEmailPreferences(userID)
+	if err != nil {
+		return err
+	}
+	switch scope {
+	case UnsubscribeAll:
+		prefs.ImmediateKinds = nil
+		prefs.Digest = DigestOff
+	case UnsubscribeDigest:
+		prefs.Digest = DigestOff
+	default:
+		kinds := prefs.ImmediateKinds[:0]
+		for _, kind := range prefs.ImmediateKinds {
+			if kind != scope {
+				kinds = append(kinds, kind)
+			}
+		}
+		prefs.ImmediateKinds = kinds
+	}
+	return SaveEmailPreferences(userID, prefs)
+}
+
+// renderEmail собирает HTML- и текстовую версию письма из шаблонов web/templates/email
+func renderEmail(name string, data interface{}) (string, string, error) {
+	dir := filepath.Join("web", "templates", "email")
+
+	htmlTmpl, err := template.ParseFiles(filepath.Join(dir, name+".html"))
+	if err != nil {
+		return "", "", err
+	}
+	var htmlBody bytes.Buffer
+	if err := htmlTmpl.Execute(&htmlBody, data); err != nil {
+		return "", "", err
+	}
+
+	textTmpl, err := texttemplate.ParseFiles(filepath.Join(dir, name+".txt"))
+	if err != nil {
+		return "", "", err
+	}
+	var textBody bytes.Buffer
+	if err := textTmpl.Execute(&textBody, data); err != nil {
+		return "", "", err
+	}
+	return htmlBody.String(), textBody.String(), nil
+}
+
+// queueEmail ставит письмо в очередь на отправку
+func queueEmail(userID int, to, subject, htmlBody, textBody, unsubscribe string) error {
+	_, err := DB.Exec(`
+		INSERT INTO email_outbox (user_id, to_address, subject, body_html, body_text, unsubscribe_url)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, userID, to, subject, htmlBody, textBody, unsubscribe)
+	return err
+}
+
+// emailRecipient возвращает имя и адрес пользователя, если ему можно писать
+func emailRecipient(userID int) (string, string, bool, error) {
+	var username, email string
+	err := DB.QueryRow(`
+		SELECT username, email FROM users
+		WHERE id = $1 AND deleted_at IS NULL AND deletion_requested_at IS NULL
+	`, userID).Scan(&username, &email)
+	if err == sql.ErrNoRows {
+		return "", "", false, nil
+	}
+	return username, email, err == nil, err
+}
+
+// queueNotificationEmail отправляет письмо об уведомлении, если пользователь
+// включил такие письма и сейчас не на сайте
+func queueNotificationEmail(userID int, notification Notification) error {
+	if hub != nil && hub.hasSubscribers(userID) {
+		return nil
+	}
+	prefs, err := GetEmailPreferences(userID)
+	if err != nil || !prefs.Wants(notification.Kind) {
+		return err
+	}
+	username, email, ok, err := emailRecipient(userID)
+	if err != nil || !ok {
+		return err
+	}
+
+	link := notification.Link()
+	if link == "" {
+		link = "/notifications"
+	}
+	data := struct {
+		Username          string
+		Text              string
+		Link              string
+		SettingsURL       string
+		UnsubscribeURL    string
+		UnsubscribeAllURL string
+	}{
+		Username:          username,
+		Text:              notification.Text(),
+		Link:              siteURL(link),
+		SettingsURL:       siteURL("/settings/email"),
+		UnsubscribeURL:    unsubscribeURL(userID, notification.Kind),
+		UnsubscribeAllURL: unsubscribeURL(userID, UnsubscribeAll),
+	}
+	htmlBody, textBody, err := renderEmail("notification", data)
+	if err != nil {
+		return err
+	}
+	return queueEmail(userID, email, notification.Text(), htmlBody, textBody, data.UnsubscribeURL)
+}
+
+// processEmailOutbox отправляет письма из очереди. Неудачные попытки
+// повторяются с растущей задержкой
+func processEmailOutbox() error {
+	if mailer == nil {
+		return nil
+	}
+	for {
+		var id int64
+		var message EmailMessage
+		err := DB.QueryRow(`
+			UPDATE email_outbox
+			SET attempts = attempts + 1,
+			    next_attempt_at = NOW() + (attempts + 1) * (attempts + 1) * INTERVAL '1 minute'
+			WHERE id = (
+				SELECT id FROM email_outbox
+				WHERE sent_at IS NULL AND attempts < $1 AND next_attempt_at <= NOW()
+				ORDER BY id
+				FOR UPDATE SKIP LOCKED
+				LIMIT 1
+			)
+			RETURNING id, to_address, subject, body_html, body_text, unsubscribe_url
+		`, emailMaxAttempts).Scan(&id, &message.To, &message.Subject, &message.HTML, &message.Text, &message.UnsubscribeURL)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if sendErr := mailer.Send(message); sendErr != nil {
+			log.Printf("Ошибка при отправке письма %d: %v\n", id, sendErr)
+			_, err = DB.Exec(`UPDATE email_outbox SET last_error = $2 WHERE id = $1`, id, sendErr.Error())
+		} else {
+			_, err = DB.Exec(`UPDATE email_outbox SET sent_at = NOW(), last_error = NULL WHERE id = $1`, id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// cleanupEmailOutbox удаляет отправленные и окончательно не отправленные письма
+func cleanupEmailOutbox() error {
+	_, err := DB.Exec(`
+		DELETE FROM email_outbox
+		WHERE (sent_at IS NOT NULL OR attempts >= $1) AND created_at < NOW() - $2 * INTERVAL '1 second'
+	`, emailMaxAttempts, emailRetention.Seconds())
+	return err
+}
+
+// digestPost — пост друга в дайджесте
+type digestPost struct {
+	Author    string
+	Excerpt   string
+	CreatedAt string
+	Link      string
+}
+
+// sendDigests собирает дайджесты для пользователей, у которых подошёл срок
+func sendDigests() error {
+	rows, err := DB.Query(`
+		SELECT u.id, COALESCE(ep.digest, $1), ep.last_digest_at, COALESCE(ep.last_digest_at, u.registration_date)
+		FROM users u
+		LEFT JOIN email_preferences ep ON ep.user_id = u.id
+		WHERE u.deleted_at IS NULL AND u.deletion_requested_at IS NULL
+		  AND COALESCE(ep.digest, $1) != $2
+		  AND COALESCE(ep.last_digest_at, u.registration_date) <
+		      NOW() - CASE COALESCE(ep.digest, $1) WHEN $3 THEN INTERVAL '1 day' ELSE INTERVAL '7 days' END
+	`, DigestWeekly, DigestOff, DigestDaily)
+	if err != nil {
+		return err
+	}
+
+	type dueDigest struct {
+		userID   int
+		digest   string
+		lastSent sql.NullTime
+		since    time.Time
+	}
+	var due []dueDigest
+	for rows.Next() {
+		var d dueDigest
+		if err := rows.Scan(&d.userID, &d.digest, &d.lastSent, &d.since); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		claimed, err := claimDigest(d.userID, d.lastSent)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		if err := queueDigest(d.userID, d.digest, d.since); err != nil {
+			log.Printf("Ошибка при подготовке дайджеста для пользователя %d: %v\n", d.userID, err)
+		}
+	}
+	return nil
+}
+
+// claimDigest отмечает отправку дайджеста, только если с момента чтения lastSent
+// другой экземпляр сервера не успел отметить её раньше
+func claimDigest(userID int, lastSent sql.NullTime) (bool, error) {
+	// Строка без сохранённых настроек создаётся с настройками по умолчанию,
+	// чтобы после первого дайджеста пользователь получал те же письма, что и до него
+	defaults := defaultEmailPreferences()
+	result, err := DB.Exec(`
+		INSERT INTO email_preferences (user_id, immediate_kinds, digest, last_digest_at)
+		VALUES ($1, $3, $4, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET last_digest_at = NOW()
+		WHERE email_preferences.last_digest_at IS NOT DISTINCT FROM $2
+	`, userID, lastSent, pq.Array(defaults.ImmediateKinds), defaults.Digest)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := result.RowsAffected()
+	return claimed > 0, err
+}
+
+// queueDigest ставит в очередь дайджест постов друзей и подписок, опубликованных после since
+func queueDigest(userID int, digest string, since time.Time) error {
+	username, email, ok, err := emailRecipient(userID)
+	if err != nil || !ok {
+		return err
+	}
+
+	rows, err := DB.Query(`
+		SELECT p.id, p.user_id, u.username, p.content, p.created_at
+		FROM posts p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.user_id IN (`+feedAuthorsQuery+`)
+		  AND p.created_at > $2 AND p.hidden_at IS NULL AND p.held_at IS NULL
+		  AND `+visiblePostCondition("$1")+`
+		ORDER BY p.created_at DESC
+		LIMIT $3
+	`, userID, since, digestPostLimit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var posts []digestPost
+	for rows.Next() {
+		var postID, authorID int
+		var post digestPost
+		var createdAt time.Time
+		if err := rows.Scan(&postID, &authorID, &post.Author, &post.Excerpt, &createdAt); err != nil {
+			return err
+		}
+		if utf8.RuneCountInString(post.Excerpt) > digestExcerptLength {
+			post.Excerpt = string([]rune(post.Excerpt)[:digestExcerptLength]) + "…"
+		}
+		post.CreatedAt = createdAt.Format("02.01.2006 15:04")
+		post.Link = siteURL(fmt.Sprintf("/profile?id=%d#post-%d", authorID, postID))
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	// Пустые дайджесты не отправляем
+	if len(posts) == 0 {
+		return nil
+	}
+
+	period := "неделю"
+	if digest == DigestDaily {
+		period = "день"
+	}
+	data := struct {
+		Username          string
+		Period            string
+		Posts             []digestPost
+		FeedURL           string
+		SettingsURL       string
+		UnsubscribeURL    string
+		UnsubscribeAllURL string
+	}{
+		Username:          username,
+		Period:            period,
+		Posts:             posts,
+		FeedURL:           siteURL("/posts"),
+		SettingsURL:       siteURL("/settings/email"),
+		UnsubscribeURL:    unsubscribeURL(userID, UnsubscribeDigest),
+		UnsubscribeAllURL: unsubscribeURL(userID, UnsubscribeAll),
+	}
+	htmlBody, textBody, err := renderEmail("digest", data)
+	if err != nil {
+		return err
+	}
+	subject := "Что нового у друзей за " + period
+	return queueEmail(userID, email, subject, htmlBody, textBody, data.UnsubscribeURL)
+}
+
+// EmailSettingsHandler показывает и сохраняет почтовые настройки
+func EmailSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		prefs := EmailPreferences{ImmediateKinds: r.Form["kind"], Digest: r.FormValue("digest")}
+		if err := SaveEmailPreferences(userID, prefs); err != nil {
+			log.Println("Ошибка при сохранении почтовых настроек:", err)
+			http.Error(w, "Ошибка при сохранении настроек", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/settings/email?saved=1", http.StatusSeeOther)
+		return
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+
+	prefs, err := GetEmailPreferences(userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке почтовых настроек:", err)
+		http.Error(w, "Ошибка при загрузке настроек", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Header        HeaderData
+		Preferences   EmailPreferences
+		Kinds         []Option
+		DigestOptions []Option
+		Saved         bool
+	}{
+		Header:        header,
+		Preferences:   prefs,
+		Kinds:         emailNotificationKinds,
+		DigestOptions: digestOptions,
+		Saved:         r.URL.Query().Get("saved") != "",
+	}
+
+	renderTemplate(w, "email-settings.html", data)
+}
+
+// EmailUnsubscribeHandler отписывает от писем по подписанной ссылке без входа на сайт.
+// GET показывает подтверждение, POST отписывает — в том числе запросом
+// List-Unsubscribe-Post от почтового клиента (RFC 8058)
+func EmailUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("u"))
+	scope := r.URL.Query().Get("s")
+	signature := r.URL.Query().Get("sig")
+	if err != nil || scope == "" || !hmac.Equal([]byte(signature), []byte(unsubscribeSignature(userID, scope))) {
+		http.Error(w, "Ссылка для отписки недействительна", http.StatusBadRequest)
+		return
+	}
+
+	done := false
+	if r.Method == http.MethodPost {
+		if err := Unsubscribe(userID, scope); err != nil {
+			log.Println("Ошибка при отписке от писем:", err)
+			http.Error(w, "Ошибка при отписке", http.StatusInternalServerError)
+			return
+		}
+		done = true
+	}
+
+	description := "все письма"
+	if scope == UnsubscribeDigest {
+		description = "дайджест постов друзей"
+	} else if scope != UnsubscribeAll {
+		description = "письма о новых уведомлениях"
+		for _, option := range emailNotificationKinds {
+			if option.Value == scope {
+				description = "письма «" + option.Label + "»"
+			}
+		}
+	}
+
+	data := struct {
+		Description string
+		Done        bool
+	}{
+		Description: description,
+		Done:        done,
+	}
+
+	renderTemplate(w, "unsubscribe.html", data)
+}
@@ -0,0 +1,312 @@
+// internal/emails_test.go
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// useSigningKey подменяет ключ подписи ссылок отписки на время теста
+func useSigningKey(t *testing.T, key string) {
+	previous := emailSigningKey
+	emailSigningKey = []byte(key)
+	t.Cleanup(func() { emailSigningKey = previous })
+}
+
+// useMailer подменяет способ отправки писем на время теста
+func useMailer(t *testing.T, m Mailer) {
+	previous := mailer
+	mailer = m
+	t.Cleanup(func() { mailer = previous })
+}
+
+// unsubscribeTarget возвращает путь и параметры подписанной ссылки отписки
+func unsubscribeTarget(userID int, scope string) string {
+	link, _ := url.Parse(unsubscribeURL(userID, scope))
+	return link.RequestURI()
+}
+
+func TestUnsubscribeSignature(t *testing.T) {
+	useSigningKey(t, "first-key")
+	signature := unsubscribeSignature(1, UnsubscribeAll)
+	if signature != unsubscribeSignature(1, UnsubscribeAll) {
+		t.Fatal("подпись одной ссылки должна быть постоянной")
+	}
+	if signature == unsubscribeSignature(2, UnsubscribeAll) {
+		t.Error("подпись не зависит от пользователя")
+	}
+	if signature == unsubscribeSignature(1, UnsubscribeDigest) {
+		t.Error("подпись не зависит от области отписки")
+	}
+
+	useSigningKey(t, "second-key")
+	if signature == unsubscribeSignature(1, UnsubscribeAll) {
+		t.Error("подпись не зависит от ключа")
+	}
+}
+
+func TestEmailUnsubscribeHandlerRejectsInvalidLinks(t *testing.T) {
+	useSigningKey(t, "test-key")
+	valid, _ := url.Parse(unsubscribeURL(1, UnsubscribeAll))
+	query := valid.Query()
+
+	tampered := url.Values{"u": {"2"}, "s": {UnsubscribeAll}, "sig": {query.Get("sig")}}
+	otherScope := url.Values{"u": {"1"}, "s": {UnsubscribeDigest}, "sig": {query.Get("sig")}}
+	for _, target := range []string{
+		"/email/unsubscribe",
+		"/email/unsubscribe?u=1&s=all",
+		"/email/unsubscribe?u=x&s=all&sig=" + query.Get("sig"),
+		"/email/unsubscribe?" + tampered.Encode(),
+		"/email/unsubscribe?" + otherScope.Encode(),
+	} {
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			w := httptest.NewRecorder()
+			EmailUnsubscribeHandler(w, httptest.NewRequest(method, target, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s %s: код %d, ожидался 400", method, target, w.Code)
+			}
+		}
+	}
+}
+
+func TestEmailUnsubscribeHandlerConfirmsBeforeUnsubscribing(t *testing.T) {
+	useSigningKey(t, "test-key")
+	inRepoRoot(t)
+
+	// GET не меняет настройки, поэтому работает без БД
+	w := httptest.NewRecorder()
+	EmailUnsubscribeHandler(w, httptest.NewRequest(http.MethodGet, unsubscribeTarget(1, UnsubscribeDigest), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("код %d: %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, "дайджест постов друзей") || !strings.Contains(body, `<form method="post">`) {
+		t.Errorf("нет подтверждения отписки: %s", body)
+	}
+}
+
+func TestEmailUnsubscribeHandlerUnsubscribes(t *testing.T) {
+	openTestDB(t)
+	useSigningKey(t, "test-key")
+	inRepoRoot(t)
+	userID := createTestUser(t, "unsubscribe")
+
+	w := httptest.NewRecorder()
+	EmailUnsubscribeHandler(w, httptest.NewRequest(http.MethodPost, unsubscribeTarget(userID, UnsubscribeDigest), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("код %d: %s", w.Code, w.Body.String())
+	}
+	prefs, err := GetEmailPreferences(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefs.Digest != DigestOff || len(prefs.ImmediateKinds) == 0 {
+		t.Errorf("после отписки от дайджеста: %+v", prefs)
+	}
+
+	// Запрос почтового клиента по RFC 8058 отписывает от всего
+	w = httptest.NewRecorder()
+	body := strings.NewReader("List-Unsubscribe=One-Click")
+	r := httptest.NewRequest(http.MethodPost, unsubscribeTarget(userID, UnsubscribeAll), body)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	EmailUnsubscribeHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("код %d: %s", w.Code, w.Body.String())
+	}
+	if prefs, err = GetEmailPreferences(userID); err != nil {
+		t.Fatal(err)
+	}
+	if prefs.Digest != DigestOff || len(prefs.ImmediateKinds) != 0 {
+		t.Errorf("после отписки от всего: %+v", prefs)
+	}
+}
+
+// outboxState — состояние письма в очереди
+type outboxState struct {
+	attempts  int
+	lastError sql.NullString
+	sent      bool
+	// delay — сколько секунд осталось до следующей попытки
+	delay float64
+}
+
+func loadOutboxState(t *testing.T, id int64) outboxState {
+	t.Helper()
+	var state outboxState
+	err := DB.QueryRow(`
+		SELECT attempts, last_error, sent_at IS NOT NULL, EXTRACT(EPOCH FROM next_attempt_at - NOW())::FLOAT8
+		FROM email_outbox WHERE id = $1
+	`, id).Scan(&state.attempts, &state.lastError, &state.sent, &state.delay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// queueTestEmail ставит письмо пользователю в очередь и возвращает его адрес и ID письма
+func queueTestEmail(t *testing.T, userID int) (string, int64) {
+	t.Helper()
+	to := fmt.Sprintf("outbox%d@example.com", userID)
+	if err := queueEmail(userID, to, "Тема", "<p>Текст</p>", "Текст", "https://example.com/unsubscribe"); err != nil {
+		t.Fatal(err)
+	}
+	var id int64
+	if err := DB.QueryRow(`SELECT id FROM email_outbox WHERE user_id = $1`, userID).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return to, id
+}
+
+func TestProcessEmailOutboxRetriesWithBackoff(t *testing.T) {
+	openTestDB(t)
+	sink := startSMTPSink(t)
+	useMailer(t, sink.mailer())
+	to, id := queueTestEmail(t, createTestUser(t, "outbox"))
+
+	sink.setReject("mailbox unavailable")
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := processEmailOutbox(); err != nil {
+			t.Fatal(err)
+		}
+		state := loadOutboxState(t, id)
+		if state.attempts != attempt || state.sent || !strings.Contains(state.lastError.String, "mailbox unavailable") {
+			t.Fatalf("попытка %d: %+v", attempt, state)
+		}
+		// Задержка растёт квадратично: 1, 4, 9... минут
+		if want := float64(attempt * attempt * 60); state.delay < want-10 || state.delay > want+10 {
+			t.Errorf("попытка %d: следующая через %.0f с, ожидалось %.0f", attempt, state.delay, want)
+		}
+
+		// До конца задержки письмо не отправляется повторно
+		if err := processEmailOutbox(); err != nil {
+			t.Fatal(err)
+		}
+		if state := loadOutboxState(t, id); state.attempts != attempt {
+			t.Fatalf("попытка %d повторена раньше срока: %+v", attempt, state)
+		}
+		if _, err := DB.Exec(`UPDATE email_outbox SET next_attempt_at = NOW() WHERE id = $1`, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink.setReject("")
+	if err := processEmailOutbox(); err != nil {
+		t.Fatal(err)
+	}
+	if state := loadOutboxState(t, id); !state.sent || state.lastError.Valid || state.attempts != 3 {
+		t.Fatalf("после успешной отправки: %+v", state)
+	}
+	if message := sink.receive(t, to); !strings.Contains(string(message.Data), "List-Unsubscribe: <https://example.com/unsubscribe>") {
+		t.Errorf("письмо без List-Unsubscribe:\n%s", message.Data)
+	}
+}
+
+func TestProcessEmailOutboxGivesUpAfterMaxAttempts(t *testing.T) {
+	openTestDB(t)
+	sink := startSMTPSink(t)
+	useMailer(t, sink.mailer())
+	_, id := queueTestEmail(t, createTestUser(t, "outbox"))
+
+	if _, err := DB.Exec(`UPDATE email_outbox SET attempts = $2 WHERE id = $1`, id, emailMaxAttempts); err != nil {
+		t.Fatal(err)
+	}
+	if err := processEmailOutbox(); err != nil {
+		t.Fatal(err)
+	}
+	if state := loadOutboxState(t, id); state.sent || state.attempts != emailMaxAttempts {
+		t.Errorf("письмо после последней попытки: %+v", state)
+	}
+}
+
+func TestClaimDigestOnlyOnce(t *testing.T) {
+	openTestDB(t)
+	userID := createTestUser(t, "digest")
+
+	// Настроек ещё нет: первый экземпляр отмечает отправку, второй с тем же прочитанным значением — нет
+	for i, want := range []bool{true, false} {
+		claimed, err := claimDigest(userID, sql.NullTime{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claimed != want {
+			t.Errorf("без настроек, попытка %d: claimed = %v", i+1, claimed)
+		}
+	}
+
+	var lastSent sql.NullTime
+	if err := DB.QueryRow(`SELECT last_digest_at FROM email_preferences WHERE user_id = $1`, userID).Scan(&lastSent); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false} {
+		claimed, err := claimDigest(userID, lastSent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claimed != want {
+			t.Errorf("с прочитанной датой, попытка %d: claimed = %v", i+1, claimed)
+		}
+	}
+}
+
+func TestClaimDigestKeepsDefaultPreferences(t *testing.T) {
+	openTestDB(t)
+	userID := createTestUser(t, "digest")
+
+	if claimed, err := claimDigest(userID, sql.NullTime{}); err != nil || !claimed {
+		t.Fatalf("claimed = %v, %v", claimed, err)
+	}
+	prefs, err := GetEmailPreferences(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prefs.Wants(NotificationMention) || prefs.Digest != DigestWeekly {
+		t.Errorf("после первого дайджеста настройки изменились: %+v", prefs)
+	}
+}
+
+func TestSendDigestsQueuesDueDigestOnce(t *testing.T) {
+	openTestDB(t)
+	inRepoRoot(t)
+	reader := createTestUser(t, "reader")
+	author := createTestUser(t, "author")
+
+	statements := []string{
+		`INSERT INTO friendships (user_id, friend_id) VALUES ($1, $2)`,
+		`INSERT INTO email_preferences (user_id, digest, last_digest_at) VALUES ($1, 'daily', NOW() - INTERVAL '2 days')`,
+		`INSERT INTO posts (user_id, content, created_at, audience) VALUES ($2, 'Пост для дайджеста', NOW() - INTERVAL '1 hour', 'friends')`,
+	}
+	for _, statement := range statements {
+		if _, err := DB.Exec(statement, reader, author); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	for run := 1; run <= 2; run++ {
+		if err := sendDigests(); err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		var subject, text string
+		err := DB.QueryRow(`
+			SELECT COUNT(*) OVER (), subject, body_text FROM email_outbox WHERE user_id = $1
+		`, reader).Scan(&count, &subject, &text)
+		if err != nil {
+			t.Fatalf("запуск %d: %v", run, err)
+		}
+		if count != 1 || subject != "Что нового у друзей за день" || !strings.Contains(text, "Пост для дайджеста") {
+			t.Errorf("запуск %d: %d писем, %q\n%s", run, count, subject, text)
+		}
+	}
+
+	var recent bool
+	err := DB.QueryRow(`
+		SELECT last_digest_at > NOW() - INTERVAL '1 minute' FROM email_preferences WHERE user_id = $1
+	`, reader).Scan(&recent)
+	if err != nil || !recent {
+		t.Errorf("отправка дайджеста не отмечена: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+// internal/feed.go
+package internal
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// Режимы ленты
+const (
+	FeedLatest = "latest" // сначала новые посты
+	FeedTop    = "top"    // сначала интересные посты
+)
+
+const (
+	// feedPageSize — сколько постов показывается в ленте
+	feedPageSize = 10
+	// feedTopCandidates — из скольких последних постов выбираются интересные
+	feedTopCandidates = 200
+)
+
+var feedModeOptions = []Option{
+	{Value: FeedLatest, Label: "Новые"},
+	{Value: FeedTop, Label: "Интересные"},
+}
+
+// feedPostsQuery выбирает посты ленты пользователя $1: его собственные, включая
+// задержанные фильтром, и опубликованные посты друзей и подписок
+const feedPostsQuery = `
+	SELECT p.id, p.user_id, u.username, p.content, p.created_at,
+	       p.held_at IS NOT NULL AS held, p.edited_at IS NOT NULL AS edited
+	FROM posts p
+	JOIN users u ON p.user_id = u.id
+	WHERE p.id IN (` + timelinePostsQuery + `)
+	  AND p.hidden_at IS NULL AND (p.user_id = $1 OR p.held_at IS NULL)`
+
+// topFeedQuery ранжирует последние посты ленты, прошедшие условие condition.
+// Вес поста растёт с числом комментариев и реакций и с тем, как часто пользователь
+// сам реагирует на посты автора, и убывает со временем, как на новостных агрегаторах
+func topFeedQuery(condition string) string {
+	return `
+	WITH candidates AS (` + feedPostsQuery + `
+		  AND ` + condition + `
+		ORDER BY p.created_at DESC
+		LIMIT $2
+	),
+	engagement AS (
+		SELECT c.id,
+		       (SELECT COUNT(*) FROM comments cm WHERE cm.post_id = c.id AND cm.hidden_at IS NULL AND cm.held_at IS NULL) * 2
+		       + (SELECT COUNT(*) FROM post_reactions r WHERE r.post_id = c.id) AS score
+		FROM candidates c
+	),
+	affinity AS (
+		SELECT author_id, COUNT(*) AS score
+		FROM (
+			SELECT p.user_id AS author_id FROM comments cm JOIN posts p ON p.id = cm.post_id
+			WHERE cm.user_id = $1 AND cm.created_at > NOW() - INTERVAL '90 days'
+			UNION ALL
+			SELECT p.user_id FROM post_reactions r JOIN posts p ON p.id = r.post_id
+			WHERE r.user_id = $1 AND r.created_at > NOW() - INTERVAL '90 days'
+		) i
+		GROUP BY author_id
+	)
+	SELECT c.id, c.user_id, c.username, c.content, c.created_at, c.held, c.edited
+	FROM candidates c
+	JOIN engagement e ON e.id = c.id
+	LEFT JOIN affinity a ON a.author_id = c.user_id
+	ORDER BY (1 + LN(1 + e.score) + 0.5 * LN(1 + COALESCE(a.score, 0)))
+	         / POWER(EXTRACT(EPOCH FROM NOW() - c.created_at) / 3600 + 2, 1.5) DESC,
+	         c.created_at DESC
+	LIMIT $3`
+}
+
+// LoadFeed возвращает посты ленты пользователя в выбранном режиме
+func LoadFeed(userID int, mode string) ([]Post, error) {
+	if err := ensureTimeline(userID); err != nil {
+		return nil, err
+	}
+
+	visible := visiblePostCondition("$1")
+	query := feedPostsQuery + ` AND ` + visible + ` ORDER BY p.created_at DESC LIMIT $2`
+	args := []interface{}{userID, feedPageSize}
+	if mode == FeedTop {
+		query = topFeedQuery(visible)
+		args = []interface{}{userID, feedTopCandidates, feedPageSize}
+	}
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		var createdAt time.Time
+		if err := rows.Scan(&post.ID, &post.AuthorID, &post.Author, &post.Content, &createdAt, &post.Held, &post.Edited); err != nil {
+			return nil, err
+		}
+		post.CreatedAt = createdAt.Format("02.01.2006 15:04")
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// GetFeedMode возвращает сохранённый режим ленты пользователя
+func GetFeedMode(userID int) (string, error) {
+	var mode string
+	err := DB.QueryRow(`SELECT feed_mode FROM users WHERE id = $1`, userID).Scan(&mode)
+	return mode, err
+}
+
+// FeedModeHandler переключает режим ленты
+func FeedModeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	mode := r.FormValue("mode")
+	if !hasOption(feedModeOptions, mode) {
+		http.Error(w, "Некорректный режим ленты", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := DB.Exec(`UPDATE users SET feed_mode = $2 WHERE id = $1`, userID, mode); err != nil {
+		log.Println("Ошибка при сохранении режима ленты:", err)
+		http.Error(w, "Ошибка при сохранении настройки", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/posts", http.StatusSeeOther)
+}
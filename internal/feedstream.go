@@ -0,0 +1,192 @@
+// internal/feedstream.go
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RealtimeFeedPost — событие о новом посте друга
+const RealtimeFeedPost = "feed_post"
+
+// feedReplayLimit — сколько пропущенных постов досылается при переподключении
+const feedReplayLimit = 100
+
+// feedPostEvent — содержимое события о новом посте. ID поста служит
+// идентификатором события SSE, по нему клиент возобновляет поток
+type feedPostEvent struct {
+	ID        int    `json:"id"`
+	AuthorID  int    `json:"author_id"`
+	Author    string `json:"author"`
+	CreatedAt string `json:"created_at"`
+}
+
+// publishFeedPost сообщает о новом посте всем, в чьей ленте он появится с учётом аудитории поста
+func publishFeedPost(postID, authorID int) {
+	if hub == nil {
+		return
+	}
+
+	rows, err := DB.Query(`
+		SELECT r.id
+		FROM (`+feedReadersQuery+`) r(id)
+		JOIN posts p ON p.id = $2
+		WHERE `+visiblePostCondition("r.id")+`
+	`, authorID, postID)
+	if err != nil {
+		log.Println("Ошибка при получении подписчиков ленты:", err)
+		return
+	}
+	defer rows.Close()
+
+	event := feedPostEvent{
+		ID:        postID,
+		AuthorID:  authorID,
+		Author:    usernameByID(authorID),
+		CreatedAt: time.Now().Format("02.01.2006 15:04"),
+	}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			log.Println("Ошибка при чтении подписчика ленты:", err)
+			continue
+		}
+		if err := publishEphemeral(userID, RealtimeFeedPost, event); err != nil {
+			log.Println("Ошибка при отправке события ленты:", err)
+		}
+	}
+}
+
+// latestPostID возвращает ID последнего поста. Лента передаёт его клиенту,
+// чтобы поток событий начинался ровно с момента загрузки страницы
+func latestPostID() (int, error) {
+	var id int
+	err := DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM posts`).Scan(&id)
+	return id, err
+}
+
+// feedPostsSince возвращает посты друзей и подписок, опубликованные после lastPostID
+func feedPostsSince(userID, lastPostID int) ([]feedPostEvent, error) {
+	rows, err := DB.Query(`
+		SELECT p.id, p.user_id, u.username, p.created_at
+		FROM posts p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.user_id IN (`+feedAuthorsQuery+`)
+		  AND p.id > $2 AND p.hidden_at IS NULL AND p.held_at IS NULL
+		  AND `+visiblePostCondition("$1")+`
+		ORDER BY p.id
+		LIMIT $3
+	`, userID, lastPostID, feedReplayLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []feedPostEvent
+	for rows.Next() {
+		var event feedPostEvent
+		var createdAt time.Time
+		if err := rows.Scan(&event.ID, &event.AuthorID, &event.Author, &createdAt); err != nil {
+			return nil, err
+		}
+		event.CreatedAt = createdAt.Format("02.01.2006 15:04")
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// writeSSE отправляет одно событие в формате Server-Sent Events
+func writeSSE(w http.ResponseWriter, id int, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, eventType, payload)
+	return err
+}
+
+// FeedEventsHandler отдаёт поток новых постов друзей через Server-Sent Events.
+// Позиция в потоке задаётся заголовком Last-Event-ID (его выставляет браузер
+// при переподключении) или параметром last_event_id при первом подключении
+func FeedEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+	if hub == nil {
+		http.Error(w, ErrRealtimeDisabled.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	lastPostID, _ := strconv.Atoi(lastID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 5000\n\n")
+
+	// Подписываемся до чтения пропущенных постов, чтобы ничего не потерять между ними
+	sub := hub.subscribe(userID)
+	defer hub.unsubscribe(sub)
+	touchHeartbeat(userID)
+
+	if lastPostID > 0 {
+		missed, err := feedPostsSince(userID, lastPostID)
+		if err != nil {
+			log.Println("Ошибка при загрузке пропущенных постов:", err)
+		}
+		for _, event := range missed {
+			if err := writeSSE(w, event.ID, "post", event); err != nil {
+				return
+			}
+			lastPostID = event.ID
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(realtimePingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-sub.send:
+			if event.Type != RealtimeFeedPost {
+				continue
+			}
+			var post feedPostEvent
+			if err := json.Unmarshal(event.Data, &post); err != nil || post.ID <= lastPostID {
+				continue
+			}
+			if err := writeSSE(w, post.ID, "post", post); err != nil {
+				return
+			}
+			lastPostID = post.ID
+			flusher.Flush()
+		case <-ticker.C:
+			// Комментарий не доходит до обработчиков, но не даёт прокси закрыть соединение
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			touchHeartbeat(userID)
+		case <-sub.dropped:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
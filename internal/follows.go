@@ -0,0 +1,177 @@
+// internal/follows.go
+package internal
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+var (
+	ErrFollowSelf       = errors.New("нельзя подписаться на себя")
+	ErrFollowNotAllowed = errors.New("пользователь не принимает подписчиков")
+)
+
+// feedAuthorsQuery выбирает авторов, чьи посты попадают в ленту пользователя $1:
+// друзей в любом направлении дружбы и аккаунты, на которые он подписан
+const feedAuthorsQuery = `
+	SELECT friend_id FROM friendships WHERE user_id = $1
+	UNION
+	SELECT user_id FROM friendships WHERE friend_id = $1
+	UNION
+	SELECT followee_id FROM follows WHERE follower_id = $1`
+
+// feedReadersQuery — обратный к feedAuthorsQuery запрос: пользователи,
+// в чьей ленте появляются посты автора $1
+const feedReadersQuery = `
+	SELECT user_id FROM friendships WHERE friend_id = $1
+	UNION
+	SELECT friend_id FROM friendships WHERE user_id = $1
+	UNION
+	SELECT follower_id FROM follows WHERE followee_id = $1`
+
+// FollowStats — счётчики подписок для профиля
+type FollowStats struct {
+	Followers       int
+	Following       int
+	IsFollowing     bool // текущий пользователь подписан на владельца профиля
+	AcceptFollowers bool // владелец профиля принимает подписчиков
+}
+
+// GetFollowStats возвращает счётчики подписок пользователя с точки зрения viewerID
+func GetFollowStats(userID, viewerID int) (FollowStats, error) {
+	var stats FollowStats
+	err := DB.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM follows f JOIN users u ON u.id = f.follower_id
+			 WHERE f.followee_id = $1 AND u.deleted_at IS NULL),
+			(SELECT COUNT(*) FROM follows f JOIN users u ON u.id = f.followee_id
+			 WHERE f.follower_id = $1 AND u.deleted_at IS NULL),
+			EXISTS(SELECT 1 FROM follows WHERE follower_id = $2 AND followee_id = $1),
+			accept_followers
+		FROM users
+		WHERE id = $1
+	`, userID, viewerID).Scan(&stats.Followers, &stats.Following, &stats.IsFollowing, &stats.AcceptFollowers)
+	return stats, err
+}
+
+// Follow подписывает пользователя на аккаунт, если тот принимает подписчиков
+func Follow(followerID, followeeID int) error {
+	if followerID == followeeID {
+		return ErrFollowSelf
+	}
+
+	blocked, err := IsBlocked(followerID, followeeID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrUserBlocked
+	}
+
+	var accepts bool
+	err = DB.QueryRow(`
+		SELECT accept_followers FROM users WHERE id = $1 AND deleted_at IS NULL
+	`, followeeID).Scan(&accepts)
+	if err != nil {
+		return err
+	}
+	if !accepts {
+		return ErrFollowNotAllowed
+	}
+
+	result, err := DB.Exec(`
+		INSERT INTO follows (follower_id, followee_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, followerID, followeeID)
+	if err != nil {
+		return err
+	}
+
+	// Повторная подписка не создаёт нового уведомления
+	if added, _ := result.RowsAffected(); added > 0 {
+		if err := backfillTimeline(followerID, followeeID); err != nil {
+			log.Println("Ошибка при заполнении ленты:", err)
+		}
+		if err := Notify(followeeID, NotificationFollow, followerID, "user", followerID, "Новый подписчик"); err != nil {
+			log.Println("Ошибка при создании уведомления:", err)
+		}
+	}
+	return nil
+}
+
+// Unfollow отменяет подписку
+func Unfollow(followerID, followeeID int) error {
+	_, err := DB.Exec(`DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2`, followerID, followeeID)
+	if err != nil {
+		return err
+	}
+	return pruneTimeline(followerID, followeeID)
+}
+
+// SetAcceptFollowers разрешает или запрещает новые подписки на пользователя.
+// Уже оформленные подписки сохраняются
+func SetAcceptFollowers(userID int, accept bool) error {
+	_, err := DB.Exec(`UPDATE users SET accept_followers = $2 WHERE id = $1`, userID, accept)
+	return err
+}
+
+// FollowHandler оформляет или отменяет подписку на пользователя
+func FollowHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	followeeID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil || followeeID <= 0 {
+		http.Error(w, "Некорректный ID пользователя", http.StatusBadRequest)
+		return
+	}
+
+	if r.FormValue("action") == "unfollow" {
+		err = Unfollow(userID, followeeID)
+	} else {
+		err = Follow(userID, followeeID)
+	}
+	switch {
+	case err == ErrFollowSelf || err == ErrFollowNotAllowed || err == ErrUserBlocked:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		log.Println("Ошибка при изменении подписки:", err)
+		http.Error(w, "Не удалось изменить подписку", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/profile?id="+strconv.Itoa(followeeID), http.StatusSeeOther)
+}
+
+// FollowersSettingHandler сохраняет настройку приёма подписчиков
+func FollowersSettingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	if err := SetAcceptFollowers(userID, r.FormValue("accept_followers") != ""); err != nil {
+		log.Println("Ошибка при сохранении настройки подписчиков:", err)
+		http.Error(w, "Ошибка при сохранении настройки", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
@@ -1,23 +1,44 @@
 package internal
 
 import (
+	"database/sql"
 	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // User представляет пользователя в системе
 type User struct {
-	ID       int    // ID пользователя
-	Username string // Имя пользователя
+	ID       int       // ID пользователя
+	Username string    // Имя пользователя
+	Presence *Presence // Статус в сети, если пользователь его не скрыл
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE, чтобы строка искалась буквально
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// searchableUserCondition возвращает SQL-условие для поиска пользователей по имени:
+// пользователь из таблицы users с псевдонимом alias не удалён, не совпадает
+// с viewer и не скрыл себя из поиска для него
+func searchableUserCondition(alias, viewer string) string {
+	return alias + `.id != ` + viewer + ` AND ` + alias + `.deleted_at IS NULL
+		  AND ` + privacyCondition(alias+"."+privacySearch, alias+".id", viewer)
 }
 
 // FindUsersByName ищет пользователей по имени, исключая текущего пользователя
+// и тех, кто скрыл себя из поиска для него
 func FindUsersByName(name string, currentUserID int) ([]User, error) {
 	query := `
-		SELECT id, username
-		FROM users
-		WHERE username ILIKE $1 AND id != $2
+		SELECT u.id, u.username
+		FROM users u
+		WHERE u.username ILIKE $1 AND ` + searchableUserCondition("u", "$2") + `
 	`
-	rows, err := DB.Query(query, "%"+name+"%", currentUserID)
+	rows, err := DB.Query(query, "%"+escapeLike(name)+"%", currentUserID)
 	if err != nil {
 		return nil, err
 	}
@@ -35,11 +56,28 @@ func FindUsersByName(name string, currentUserID int) ([]User, error) {
 	return users, nil
 }
 
-// AddFriend добавляет дружескую связь
+var ErrFriendSelf = errors.New("нельзя добавить в друзья себя")
+
+// AddFriend добавляет дружескую связь. Дружба взаимна: посты друзей
+// попадают в ленты обоих, поэтому лента заполняется в обе стороны
 func AddFriend(userID, friendID int) error {
+	if userID == friendID {
+		return ErrFriendSelf
+	}
+	blocked, err := IsBlocked(userID, friendID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrUserBlocked
+	}
+	if err := checkFriendRequestAllowed(userID, friendID); err != nil {
+		return err
+	}
+
 	// Проверяем, существует ли уже дружба
 	var count int
-	err := DB.QueryRow(`
+	err = DB.QueryRow(`
 		SELECT COUNT(*)
 		FROM friendships
 		WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)
@@ -56,5 +94,252 @@ func AddFriend(userID, friendID int) error {
 		INSERT INTO friendships (user_id, friend_id)
 		VALUES ($1, $2)
 	`, userID, friendID)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if err := backfillTimeline(userID, friendID); err != nil {
+		log.Println("Ошибка при заполнении ленты:", err)
+	}
+	if err := backfillTimeline(friendID, userID); err != nil {
+		log.Println("Ошибка при заполнении ленты:", err)
+	}
+	if err := Notify(friendID, NotificationFriendAdded, userID, "user", userID, "Новый друг"); err != nil {
+		log.Println("Ошибка при создании уведомления:", err)
+	}
+	return nil
+}
+
+// AreFriends проверяет, есть ли дружба между пользователями в любом направлении
+func AreFriends(userID, otherID int) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1
+			FROM friendships
+			WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)
+		)
+	`, userID, otherID).Scan(&exists)
+	return exists, err
+}
+
+// ListFriends возвращает друзей пользователя (дружба в любом направлении), отсортированных по имени
+func ListFriends(userID int) ([]User, error) {
+	rows, err := DB.Query(`
+		SELECT u.id, u.username, u.last_seen_at, u.last_heartbeat_at, u.hide_presence
+		FROM users u
+		WHERE u.deleted_at IS NULL AND u.id IN (
+			SELECT friend_id FROM friendships WHERE user_id = $1
+			UNION
+			SELECT user_id FROM friendships WHERE friend_id = $1
+		)
+		ORDER BY u.username
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		var lastSeen, lastHeartbeat sql.NullTime
+		var hidePresence bool
+		if err := rows.Scan(&user.ID, &user.Username, &lastSeen, &lastHeartbeat, &hidePresence); err != nil {
+			return nil, err
+		}
+		user.Presence = newPresence(lastSeen, lastHeartbeat, hidePresence)
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Порядок сортировки списка друзей
+const (
+	FriendSortName = "name"
+	FriendSortDate = "date"
+)
+
+// Friend — друг пользователя со временем начала дружбы
+type Friend struct {
+	ID        int
+	Username  string
+	AvatarURL string
+	Since     time.Time
+	Presence  *Presence
+}
+
+// friendsQuery выбирает друзей пользователя $1 в любом направлении дружбы
+const friendsQuery = `
+	SELECT u.id, u.username, COALESCE(u.avatar_url, '/static/avatar.jpg'), f.since,
+	       u.last_seen_at, u.last_heartbeat_at, u.hide_presence
+	FROM (
+		SELECT id, MIN(created_at) AS since
+		FROM (
+			SELECT friend_id AS id, created_at FROM friendships WHERE user_id = $1
+			UNION ALL
+			SELECT user_id AS id, created_at FROM friendships WHERE friend_id = $1
+		) both_directions
+		GROUP BY id
+	) f
+	JOIN users u ON u.id = f.id
+	WHERE u.deleted_at IS NULL`
+
+// scanFriends читает результат запроса на основе friendsQuery
+func scanFriends(rows *sql.Rows) ([]Friend, error) {
+	defer rows.Close()
+
+	var friends []Friend
+	for rows.Next() {
+		var friend Friend
+		var lastSeen, lastHeartbeat sql.NullTime
+		var hidePresence bool
+		err := rows.Scan(&friend.ID, &friend.Username, &friend.AvatarURL, &friend.Since,
+			&lastSeen, &lastHeartbeat, &hidePresence)
+		if err != nil {
+			return nil, err
+		}
+		friend.Presence = newPresence(lastSeen, lastHeartbeat, hidePresence)
+		friends = append(friends, friend)
+	}
+	return friends, rows.Err()
+}
+
+// ListFriendsOf возвращает друзей пользователя с фильтром по имени.
+// sort задаёт порядок: по имени или сначала новые друзья
+func ListFriendsOf(userID int, name, sort string) ([]Friend, error) {
+	order := "u.username"
+	if sort == FriendSortDate {
+		order = "f.since DESC, u.username"
+	}
+	rows, err := DB.Query(friendsQuery+`
+		AND ($2 = '' OR u.username ILIKE '%' || $2 || '%')
+		ORDER BY `+order, userID, escapeLike(name))
+	if err != nil {
+		return nil, err
+	}
+	return scanFriends(rows)
+}
+
+// MutualFriends возвращает общих друзей двух пользователей, отсортированных по имени
+func MutualFriends(userID, otherID int) ([]Friend, error) {
+	rows, err := DB.Query(friendsQuery+`
+		AND u.id IN (
+			SELECT friend_id FROM friendships WHERE user_id = $2
+			UNION
+			SELECT user_id FROM friendships WHERE friend_id = $2
+		)
+		ORDER BY u.username`, userID, otherID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFriends(rows)
+}
+
+// CountFriends возвращает число друзей пользователя в любом направлении дружбы
+func CountFriends(userID int) (int, error) {
+	var count int
+	err := DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM users u
+		WHERE u.deleted_at IS NULL AND u.id IN (
+			SELECT friend_id FROM friendships WHERE user_id = $1
+			UNION
+			SELECT user_id FROM friendships WHERE friend_id = $1
+		)
+	`, userID).Scan(&count)
+	return count, err
+}
+
+// FriendsHandler показывает список друзей пользователя, по умолчанию — текущего
+func FriendsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	profileID := userID
+	if idParam := r.URL.Query().Get("id"); idParam != "" {
+		profileID, err = strconv.Atoi(idParam)
+		if err != nil || profileID <= 0 {
+			http.Error(w, "Некорректный ID пользователя", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var username string
+	err = DB.QueryRow(`SELECT username FROM users WHERE id = $1 AND deleted_at IS NULL`, profileID).Scan(&username)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке друзей", http.StatusInternalServerError)
+		return
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("q"))
+	sort := r.URL.Query().Get("sort")
+	if sort != FriendSortDate {
+		sort = FriendSortName
+	}
+
+	// Список друзей показывается, только если владелец разрешил это зрителю
+	visible, err := privacyAllows(privacyFriendList, profileID, userID)
+	if err != nil {
+		log.Println("Ошибка при проверке настроек приватности:", err)
+		http.Error(w, "Ошибка при загрузке друзей", http.StatusInternalServerError)
+		return
+	}
+
+	var friends []Friend
+	if visible {
+		if friends, err = ListFriendsOf(profileID, name, sort); err != nil {
+			log.Println("Ошибка при загрузке друзей:", err)
+			http.Error(w, "Ошибка при загрузке друзей", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var mutual []Friend
+	if visible && profileID != userID {
+		if mutual, err = MutualFriends(userID, profileID); err != nil {
+			log.Println("Ошибка при загрузке общих друзей:", err)
+			http.Error(w, "Ошибка при загрузке друзей", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data := struct {
+		Header        HeaderData
+		ProfileID     int
+		Username      string
+		IsCurrentUser bool
+		Hidden        bool
+		Query         string
+		Sort          string
+		Friends       []Friend
+		Mutual        []Friend
+	}{
+		Header:        header,
+		ProfileID:     profileID,
+		Username:      username,
+		IsCurrentUser: profileID == userID,
+		Hidden:        !visible,
+		Query:         name,
+		Sort:          sort,
+		Friends:       friends,
+		Mutual:        mutual,
+	}
+
+	renderTemplate(w, "friends.html", data)
 }
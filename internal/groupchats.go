@@ -0,0 +1,620 @@
+// internal/groupchats.go
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Роли участников группового чата
+const (
+	ChatRoleOwner  = "owner"
+	ChatRoleAdmin  = "admin"
+	ChatRoleMember = "member"
+)
+
+// Ограничения групповых чатов
+const (
+	maxGroupChatMembers = 50
+	maxGroupChatTitle   = 100
+)
+
+var (
+	ErrChatPermission    = errors.New("недостаточно прав для этого действия")
+	ErrChatTitle         = fmt.Errorf("название чата должно быть от 1 до %d символов", maxGroupChatTitle)
+	ErrChatFull          = fmt.Errorf("в чате может быть не больше %d участников", maxGroupChatMembers)
+	ErrChatNoMembers     = errors.New("выберите хотя бы одного друга")
+	ErrNotGroupChat      = errors.New("диалог не является групповым чатом")
+	ErrAlreadyChatMember = errors.New("пользователь уже состоит в чате")
+)
+
+// GroupMember представляет участника группового чата
+type GroupMember struct {
+	UserID   int
+	Username string
+	Role     string
+	JoinedAt time.Time
+}
+
+// GroupInfo содержит данные для управления групповым чатом
+type GroupInfo struct {
+	MyRole     string
+	Members    []GroupMember
+	Candidates []User
+}
+
+// CanManage сообщает, может ли текущий пользователь переименовывать чат и менять состав
+func (g GroupInfo) CanManage() bool {
+	return g.MyRole == ChatRoleOwner || g.MyRole == ChatRoleAdmin
+}
+
+// validateChatTitle нормализует и проверяет название чата
+func validateChatTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if title == "" || utf8.RuneCountInString(title) > maxGroupChatTitle {
+		return "", ErrChatTitle
+	}
+	return title, nil
+}
+
+// chatMemberRole возвращает роль пользователя в групповом чате или пустую строку, если он не участник
+func chatMemberRole(conversationID, userID int) (string, error) {
+	var role string
+	var isGroup bool
+	err := DB.QueryRow(`
+		SELECT cm.role, c.is_group
+		FROM conversation_members cm
+		JOIN conversations c ON c.id = cm.conversation_id
+		WHERE cm.conversation_id = $1 AND cm.user_id = $2
+	`, conversationID, userID).Scan(&role, &isGroup)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !isGroup {
+		return "", ErrNotGroupChat
+	}
+	return role, nil
+}
+
+// usernameByID возвращает имя пользователя для системных сообщений
+func usernameByID(userID int) string {
+	var username string
+	if err := DB.QueryRow(`SELECT username FROM users WHERE id = $1`, userID).Scan(&username); err != nil {
+		log.Println("Ошибка при получении имени пользователя:", err)
+	}
+	return username
+}
+
+// chatChange — изменение группового чата в одной транзакции. Системные сообщения
+// рассылаются участникам только после фиксации, чтобы при ошибке никто не увидел
+// сообщение об изменении, которое не было сохранено
+type chatChange struct {
+	tx       *sql.Tx
+	messages []Message
+}
+
+// beginChatChange начинает изменение чата. Строка чата блокируется до конца
+// транзакции, чтобы одновременные изменения состава не превысили лимит участников
+func beginChatChange(conversationID int) (*chatChange, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if conversationID > 0 {
+		var id int
+		err := tx.QueryRow(`SELECT id FROM conversations WHERE id = $1 FOR UPDATE`, conversationID).Scan(&id)
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			return nil, ErrNotConversationMember
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return &chatChange{tx: tx}, nil
+}
+
+// systemMessage добавляет в чат системное сообщение об изменении состава или настроек
+func (c *chatChange) systemMessage(conversationID int, format string, args ...interface{}) error {
+	message, err := storeMessage(c.tx, conversationID, 0, MessageKindSystem, fmt.Sprintf(format, args...))
+	if err != nil {
+		return err
+	}
+	c.messages = append(c.messages, message)
+	return nil
+}
+
+// commit фиксирует изменение и рассылает системные сообщения
+func (c *chatChange) commit() error {
+	if err := c.tx.Commit(); err != nil {
+		return err
+	}
+	for _, message := range c.messages {
+		publishMessage(message)
+	}
+	return nil
+}
+
+// rollback отменяет изменение, если оно не было зафиксировано
+func (c *chatChange) rollback() {
+	c.tx.Rollback()
+}
+
+// addChatMember добавляет участника так, чтобы старые сообщения не считались для него непрочитанными
+func (c *chatChange) addChatMember(conversationID, userID int, role string) error {
+	_, err := c.tx.Exec(`
+		INSERT INTO conversation_members (conversation_id, user_id, role, last_read_message_id)
+		VALUES ($1, $2, $3, COALESCE((SELECT MAX(id) FROM messages WHERE conversation_id = $1), 0))
+	`, conversationID, userID, role)
+	return err
+}
+
+// chatMemberCount возвращает количество участников чата с учётом изменений в транзакции
+func (c *chatChange) chatMemberCount(conversationID int) (int, error) {
+	var count int
+	err := c.tx.QueryRow(`SELECT COUNT(*) FROM conversation_members WHERE conversation_id = $1`, conversationID).Scan(&count)
+	return count, err
+}
+
+// CreateGroupChat создаёт групповой чат из друзей владельца
+func CreateGroupChat(ownerID int, title string, memberIDs []int) (int, error) {
+	title, err := validateChatTitle(title)
+	if err != nil {
+		return 0, err
+	}
+
+	unique := make(map[int]bool)
+	for _, id := range memberIDs {
+		if id != ownerID {
+			unique[id] = true
+		}
+	}
+	if len(unique) == 0 {
+		return 0, ErrChatNoMembers
+	}
+	if len(unique)+1 > maxGroupChatMembers {
+		return 0, ErrChatFull
+	}
+	for id := range unique {
+		friends, err := AreFriends(ownerID, id)
+		if err != nil {
+			return 0, err
+		}
+		if !friends {
+			return 0, ErrNotFriends
+		}
+	}
+
+	change, err := beginChatChange(0)
+	if err != nil {
+		return 0, err
+	}
+	defer change.rollback()
+
+	var conversationID int
+	err = change.tx.QueryRow(`
+		INSERT INTO conversations (is_group, title, created_by)
+		VALUES (TRUE, $1, $2)
+		RETURNING id
+	`, title, ownerID).Scan(&conversationID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := change.addChatMember(conversationID, ownerID, ChatRoleOwner); err != nil {
+		return 0, err
+	}
+	if err := change.systemMessage(conversationID, "%s создаёт чат «%s»", usernameByID(ownerID), title); err != nil {
+		return 0, err
+	}
+	for id := range unique {
+		if err := change.addChatMember(conversationID, id, ChatRoleMember); err != nil {
+			return 0, err
+		}
+		if err := change.systemMessage(conversationID, "%s добавляет в чат %s", usernameByID(ownerID), usernameByID(id)); err != nil {
+			return 0, err
+		}
+	}
+	return conversationID, change.commit()
+}
+
+// RenameGroupChat меняет название чата (владелец или администратор)
+func RenameGroupChat(conversationID, actorID int, title string) error {
+	role, err := chatMemberRole(conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	if role != ChatRoleOwner && role != ChatRoleAdmin {
+		return ErrChatPermission
+	}
+	title, err = validateChatTitle(title)
+	if err != nil {
+		return err
+	}
+
+	change, err := beginChatChange(conversationID)
+	if err != nil {
+		return err
+	}
+	defer change.rollback()
+
+	if _, err := change.tx.Exec(`UPDATE conversations SET title = $2 WHERE id = $1`, conversationID, title); err != nil {
+		return err
+	}
+	if err := change.systemMessage(conversationID, "%s переименовывает чат в «%s»", usernameByID(actorID), title); err != nil {
+		return err
+	}
+	return change.commit()
+}
+
+// AddGroupMember добавляет в чат друга владельца или администратора
+func AddGroupMember(conversationID, actorID, userID int) error {
+	change, err := beginChatChange(conversationID)
+	if err != nil {
+		return err
+	}
+	defer change.rollback()
+
+	role, err := chatMemberRole(conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	if role != ChatRoleOwner && role != ChatRoleAdmin {
+		return ErrChatPermission
+	}
+
+	friends, err := AreFriends(actorID, userID)
+	if err != nil {
+		return err
+	}
+	if !friends {
+		return ErrNotFriends
+	}
+
+	existing, err := chatMemberRole(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if existing != "" {
+		return ErrAlreadyChatMember
+	}
+
+	count, err := change.chatMemberCount(conversationID)
+	if err != nil {
+		return err
+	}
+	if count >= maxGroupChatMembers {
+		return ErrChatFull
+	}
+
+	if err := change.addChatMember(conversationID, userID, ChatRoleMember); err != nil {
+		return err
+	}
+	if err := change.systemMessage(conversationID, "%s добавляет в чат %s", usernameByID(actorID), usernameByID(userID)); err != nil {
+		return err
+	}
+	return change.commit()
+}
+
+// RemoveGroupMember исключает участника. Владелец может исключить любого,
+// администратор — только обычных участников
+func RemoveGroupMember(conversationID, actorID, userID int) error {
+	if actorID == userID {
+		return LeaveGroupChat(conversationID, actorID)
+	}
+
+	change, err := beginChatChange(conversationID)
+	if err != nil {
+		return err
+	}
+	defer change.rollback()
+
+	actorRole, err := chatMemberRole(conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	targetRole, err := chatMemberRole(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if targetRole == "" {
+		return ErrNotConversationMember
+	}
+
+	allowed := actorRole == ChatRoleOwner || (actorRole == ChatRoleAdmin && targetRole == ChatRoleMember)
+	if !allowed {
+		return ErrChatPermission
+	}
+
+	_, err = change.tx.Exec(`DELETE FROM conversation_members WHERE conversation_id = $1 AND user_id = $2`, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if err := change.systemMessage(conversationID, "%s исключает из чата %s", usernameByID(actorID), usernameByID(userID)); err != nil {
+		return err
+	}
+	return change.commit()
+}
+
+// SetGroupMemberRole назначает или снимает администратора (только владелец)
+func SetGroupMemberRole(conversationID, actorID, userID int, role string) error {
+	if role != ChatRoleAdmin && role != ChatRoleMember {
+		return ErrChatPermission
+	}
+
+	change, err := beginChatChange(conversationID)
+	if err != nil {
+		return err
+	}
+	defer change.rollback()
+
+	actorRole, err := chatMemberRole(conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	if actorRole != ChatRoleOwner || actorID == userID {
+		return ErrChatPermission
+	}
+
+	res, err := change.tx.Exec(`
+		UPDATE conversation_members SET role = $3
+		WHERE conversation_id = $1 AND user_id = $2 AND role != 'owner'
+	`, conversationID, userID, role)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotConversationMember
+	}
+
+	if role == ChatRoleAdmin {
+		err = change.systemMessage(conversationID, "%s назначает администратором %s", usernameByID(actorID), usernameByID(userID))
+	} else {
+		err = change.systemMessage(conversationID, "%s снимает права администратора с %s", usernameByID(actorID), usernameByID(userID))
+	}
+	if err != nil {
+		return err
+	}
+	return change.commit()
+}
+
+// LeaveGroupChat выводит пользователя из чата. Если уходит владелец, права
+// переходят к самому давнему администратору, а при их отсутствии — к самому
+// давнему участнику. Чат без участников удаляется
+func LeaveGroupChat(conversationID, userID int) error {
+	change, err := beginChatChange(conversationID)
+	if err != nil {
+		return err
+	}
+	defer change.rollback()
+
+	role, err := chatMemberRole(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if role == "" {
+		return ErrNotConversationMember
+	}
+
+	username := usernameByID(userID)
+	_, err = change.tx.Exec(`DELETE FROM conversation_members WHERE conversation_id = $1 AND user_id = $2`, conversationID, userID)
+	if err != nil {
+		return err
+	}
+
+	count, err := change.chatMemberCount(conversationID)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		if _, err := change.tx.Exec(`DELETE FROM conversations WHERE id = $1`, conversationID); err != nil {
+			return err
+		}
+		return change.commit()
+	}
+
+	if err := change.systemMessage(conversationID, "%s покидает чат", username); err != nil {
+		return err
+	}
+	if role == ChatRoleOwner {
+		var newOwnerID int
+		err = change.tx.QueryRow(`
+			UPDATE conversation_members SET role = 'owner'
+			WHERE conversation_id = $1 AND user_id = (
+				SELECT user_id FROM conversation_members
+				WHERE conversation_id = $1
+				ORDER BY role = 'admin' DESC, joined_at
+				LIMIT 1
+			)
+			RETURNING user_id
+		`, conversationID).Scan(&newOwnerID)
+		if err != nil {
+			return err
+		}
+		if err := change.systemMessage(conversationID, "%s теперь владелец чата", usernameByID(newOwnerID)); err != nil {
+			return err
+		}
+	}
+	return change.commit()
+}
+
+// LoadGroupInfo загружает участников чата и друзей, которых можно добавить
+func LoadGroupInfo(conversationID, userID int) (*GroupInfo, error) {
+	role, err := chatMemberRole(conversationID, userID)
+	if err == ErrNotGroupChat {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := &GroupInfo{MyRole: role}
+	rows, err := DB.Query(`
+		SELECT cm.user_id, u.username, cm.role, cm.joined_at
+		FROM conversation_members cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.conversation_id = $1
+		ORDER BY CASE cm.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, u.username
+	`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inChat := make(map[int]bool)
+	for rows.Next() {
+		var member GroupMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Role, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		inChat[member.UserID] = true
+		info.Members = append(info.Members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if info.CanManage() {
+		friends, err := ListFriends(userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, friend := range friends {
+			if !inChat[friend.ID] {
+				info.Candidates = append(info.Candidates, friend)
+			}
+		}
+	}
+	return info, nil
+}
+
+// NewGroupChatHandler показывает форму создания группового чата и создаёт его
+func NewGroupChatHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	var errorMsg string
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		var memberIDs []int
+		for _, value := range r.Form["member_id"] {
+			if id, err := strconv.Atoi(value); err == nil {
+				memberIDs = append(memberIDs, id)
+			}
+		}
+
+		conversationID, err := CreateGroupChat(userID, r.FormValue("title"), memberIDs)
+		switch err {
+		case nil:
+			http.Redirect(w, r, fmt.Sprintf("/messages/conversation?id=%d", conversationID), http.StatusSeeOther)
+			return
+		case ErrChatTitle, ErrChatFull, ErrChatNoMembers, ErrNotFriends:
+			errorMsg = err.Error()
+		default:
+			log.Println("Ошибка при создании группового чата:", err)
+			http.Error(w, "Ошибка при создании чата", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+
+	friends, err := ListFriends(userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке друзей:", err)
+		http.Error(w, "Ошибка при загрузке друзей", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Header   HeaderData
+		Friends  []User
+		ErrorMsg string
+	}{
+		Header:   header,
+		Friends:  friends,
+		ErrorMsg: errorMsg,
+	}
+
+	renderTemplate(w, "new-group-chat.html", data)
+}
+
+// ManageGroupChatHandler обрабатывает действия с групповым чатом:
+// переименование, добавление и исключение участников, смену роли и выход
+func ManageGroupChatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(r.FormValue("conversation_id"))
+	if err != nil || conversationID <= 0 {
+		http.Error(w, "Некорректный ID чата", http.StatusBadRequest)
+		return
+	}
+	targetID, _ := strconv.Atoi(r.FormValue("user_id"))
+
+	action := r.FormValue("action")
+	switch action {
+	case "rename":
+		err = RenameGroupChat(conversationID, userID, r.FormValue("title"))
+	case "add":
+		err = AddGroupMember(conversationID, userID, targetID)
+	case "remove":
+		err = RemoveGroupMember(conversationID, userID, targetID)
+	case "make_admin":
+		err = SetGroupMemberRole(conversationID, userID, targetID, ChatRoleAdmin)
+	case "remove_admin":
+		err = SetGroupMemberRole(conversationID, userID, targetID, ChatRoleMember)
+	case "leave":
+		err = LeaveGroupChat(conversationID, userID)
+	default:
+		http.Error(w, "Неизвестное действие", http.StatusBadRequest)
+		return
+	}
+
+	redirectURL := fmt.Sprintf("/messages/conversation?id=%d", conversationID)
+	switch err {
+	case nil:
+		if action == "leave" {
+			redirectURL = "/messages"
+		}
+	case ErrNotConversationMember, ErrNotGroupChat:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case ErrChatPermission, ErrChatTitle, ErrChatFull, ErrNotFriends, ErrAlreadyChatMember:
+		redirectURL += "&error=" + url.QueryEscape(err.Error())
+	default:
+		log.Println("Ошибка при изменении группового чата:", err)
+		http.Error(w, "Ошибка при изменении чата", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
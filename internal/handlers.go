@@ -6,7 +6,6 @@ import (
 	"html/template"
 	"io"
 	"log"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -18,18 +17,40 @@ import (
 )
 
 type Post struct {
-	Author    string
-	Content   string
-	CreatedAt string
+	ID          int
+	AuthorID    int
+	Author      string
+	Content     string
+	CreatedAt   string
+	Held        bool
+	Edited      bool
+	Audience    string
+	Mentions    map[string]int // упомянутые пользователи: имя → ID
+	Preview     *LinkPreview   // превью первой ссылки, если оно уже загружено
+	Attachments []Attachment   // изображения и видео по порядку
+	Comments    []Comment
+	Reactions   []ReactionCount
+}
+
+// AudienceLabel возвращает название аудитории поста
+func (p Post) AudienceLabel() string {
+	return audienceLabel(p.Audience)
 }
 
 type ProfileData struct {
+	Header           HeaderData
+	ID               int
 	Username         string
 	AvatarURL        string
 	RegistrationDate time.Time
 	PostCount        int
 	FriendCount      int
 	IsCurrentUser    bool
+	CanMessage       bool
+	HasBlocked       bool
+	FriendsHidden    bool
+	Follow           FollowStats
+	Presence         *Presence
 	NoPosts          bool
 	Posts            []Post
 }
@@ -65,6 +86,23 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Проверяем, не заблокирован ли аккаунт модератором
+		if errorMsg == "" {
+			until, suspended, err := GetSuspension(userID)
+			if err != nil {
+				log.Println("Ошибка при проверке блокировки:", err)
+				http.Error(w, "Ошибка при запросе к базе данных", http.StatusInternalServerError)
+				return
+			}
+			if suspended {
+				errorMsg = "Аккаунт заблокирован до " + until.Format("02.01.2006 15:04")
+			}
+		}
+
+		if errorMsg != "" {
+			Audit(r, 0, AuditLoginFailed, "user", userID, "email: "+email+"; "+errorMsg)
+		}
+
 		// Если ошибки нет, устанавливаем сессию
 		if errorMsg == "" {
 			err = SetUserIDInSession(w, r, userID)
@@ -74,6 +112,16 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			Audit(r, userID, AuditLoginSuccess, "user", userID, "")
+
+			// Если аккаунт ожидает удаления, показываем страницу, где его можно восстановить
+			var deletionPending bool
+			err = DB.QueryRow("SELECT deletion_requested_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&deletionPending)
+			if err == nil && deletionPending {
+				http.Redirect(w, r, "/account", http.StatusSeeOther)
+				return
+			}
+
 			// Перенаправление после успешного входа
 			http.Redirect(w, r, "/posts", http.StatusSeeOther)
 			return
@@ -140,7 +188,8 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Сохранение пользователя в БД
-		_, err = DB.Exec("INSERT INTO users (username, email, password_hash) VALUES ($1, $2, $3)", username, email, passwordHash)
+		var newUserID int
+		err = DB.QueryRow("INSERT INTO users (username, email, password_hash) VALUES ($1, $2, $3) RETURNING id", username, email, passwordHash).Scan(&newUserID)
 		if err != nil {
 			log.Printf("Ошибка при сохранении пользователя: %v\n", err)
 
@@ -166,6 +215,8 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		Audit(r, newUserID, AuditRegister, "user", newUserID, "")
+
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
@@ -188,124 +239,82 @@ func PostsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Получение информации о пользователе
-	var username, avatarURL sql.NullString
-	err = DB.QueryRow(`
-        SELECT username, COALESCE(avatar_url, '/static/avatar.jpg')
-        FROM users
-        WHERE id = $1
-    `, userID).Scan(&username, &avatarURL)
+	// Получение информации о пользователе для шапки
+	header, err := loadHeaderData(userID)
 	if err != nil {
 		log.Println("Ошибка при получении данных пользователя:", err)
 		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
 		return
 	}
 
-	// Устанавливаем значения для отображения
-	userNameValue := "Имя пользователя"
-	if username.Valid {
-		userNameValue = username.String
-	}
-	avatarURLValue := "/static/avatar.jpg"
-	if avatarURL.Valid {
-		avatarURLValue = avatarURL.String
-	}
-
-	// Проверяем наличие друзей
+	// Проверяем наличие друзей и подписок
 	var friendCount int
-	err = DB.QueryRow(`
-        SELECT COUNT(*)
-        FROM friendships
-        WHERE user_id = $1
-    `, userID).Scan(&friendCount)
+	err = DB.QueryRow(`SELECT COUNT(*) FROM (`+feedAuthorsQuery+`) authors`, userID).Scan(&friendCount)
 	if err != nil {
 		log.Println("Ошибка при подсчете друзей:", err)
 		http.Error(w, "Ошибка при загрузке данных друзей", http.StatusInternalServerError)
 		return
 	}
 
-	// Если нет друзей, отображаем сообщение и кнопку "Добавить друзей"
-	if friendCount == 0 {
-		tmplPath := filepath.Join("web", "templates", "posts.html")
-		tmpl, err := template.ParseFiles(tmplPath)
-		if err != nil {
-			log.Println("Ошибка при загрузке шаблона posts.html:", err)
-			http.Error(w, "Не удалось загрузить шаблон posts.html", http.StatusInternalServerError)
-			return
-		}
-
-		data := struct {
-			Username  string
-			AvatarURL string
-			Posts     []Post
-			NoFriends bool
-		}{
-			Username:  userNameValue,
-			AvatarURL: avatarURLValue,
-			Posts:     nil,
-			NoFriends: true,
-		}
-
-		tmpl.Execute(w, data)
-		return
+	mode, err := GetFeedMode(userID)
+	if err != nil {
+		log.Println("Ошибка при получении режима ленты:", err)
+		mode = FeedLatest
 	}
 
-	// Загружаем посты друзей
-	rows, err := DB.Query(`
-        SELECT u.username, p.content, p.created_at
-        FROM posts p
-        JOIN friendships f ON p.user_id = f.friend_id
-        JOIN users u ON p.user_id = u.id
-        WHERE f.user_id = $1
-        ORDER BY p.created_at DESC
-        LIMIT 10
-    `, userID)
+	// Загружаем собственные посты и посты друзей и подписок, скрытые модераторами посты не показываем
+	posts, err := LoadFeed(userID, mode)
 	if err != nil {
 		log.Println("Ошибка при запросе постов:", err)
 		http.Error(w, "Ошибка при загрузке постов", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	posts := []Post{}
-	for rows.Next() {
-		var post Post
-		if err := rows.Scan(&post.Author, &post.Content, &post.CreatedAt); err != nil {
-			log.Println("Ошибка при чтении данных поста:", err)
-			continue
-		}
-		posts = append(posts, post)
+	attachComments(posts)
+	attachMentions(posts)
+	attachLinkPreviews(posts)
+	attachMedia(posts)
+	attachReactions(posts, userID)
+
+	// Поток новых постов начинается с последнего поста на момент загрузки страницы
+	lastPostID, err := latestPostID()
+	if err != nil {
+		log.Println("Ошибка при получении последнего поста:", err)
 	}
 
-	noPosts := len(posts) == 0
-
-	// Рендеринг шаблона
-	tmplPath := filepath.Join("web", "templates", "posts.html")
-	tmpl, err := template.ParseFiles(tmplPath)
+	trending, err := TrendingTags()
 	if err != nil {
-		log.Println("Ошибка при загрузке шаблона posts.html:", err)
-		http.Error(w, "Не удалось загрузить шаблон posts.html", http.StatusInternalServerError)
-		return
+		log.Println("Ошибка при загрузке популярных тегов:", err)
 	}
 
+	// Рендеринг шаблона
 	data := struct {
-		Username  string
-		AvatarURL string
-		Posts     []Post
-		NoFriends bool
-		NoPosts   bool
+		Header     HeaderData
+		Posts      []Post
+		NoFriends  bool
+		NoPosts    bool
+		LastPostID int
+		FeedMode   string
+		FeedModes  []Option
+		Trending   []TrendingTag
 	}{
-		Username:  userNameValue,
-		AvatarURL: avatarURLValue,
-		Posts:     posts,
-		NoFriends: false,
-		NoPosts:   noPosts,
+		Header:     header,
+		Posts:      posts,
+		NoFriends:  friendCount == 0,
+		NoPosts:    len(posts) == 0,
+		LastPostID: lastPostID,
+		FeedMode:   mode,
+		FeedModes:  feedModeOptions,
+		Trending:   trending,
 	}
 
-	tmpl.Execute(w, data)
+	renderTemplate(w, "posts.html", data)
 }
 
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if userID, err := GetUserIDFromSession(r); err == nil {
+		Audit(r, userID, AuditLogout, "user", userID, "")
+	}
+
 	session, _ := store.Get(r, "session-name")
 	delete(session.Values, "userID") // Удаляем ID пользователя из сессии
 	session.Save(r, w)
@@ -326,37 +335,77 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// По умолчанию показываем собственный профиль, параметр id открывает чужой
+	profileID := userID
+	if idParam := r.URL.Query().Get("id"); idParam != "" {
+		profileID, err = strconv.Atoi(idParam)
+		if err != nil || profileID <= 0 {
+			http.Error(w, "Некорректный ID пользователя", http.StatusBadRequest)
+			return
+		}
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке профиля", http.StatusInternalServerError)
+		return
+	}
+
 	// Получаем данные пользователя из базы
-	var profileData ProfileData
+	profileData := ProfileData{Header: header, ID: profileID}
+	var lastSeen, lastHeartbeat sql.NullTime
+	var hidePresence bool
 	err = DB.QueryRow(`
-		SELECT username, COALESCE(avatar_url, '/static/avatar.jpg'), registration_date
+		SELECT username, COALESCE(avatar_url, '/static/avatar.jpg'), registration_date,
+		       last_seen_at, last_heartbeat_at, hide_presence
 		FROM users
 		WHERE id = $1
-	`, userID).Scan(&profileData.Username, &profileData.AvatarURL, &profileData.RegistrationDate)
+	`, profileID).Scan(&profileData.Username, &profileData.AvatarURL, &profileData.RegistrationDate,
+		&lastSeen, &lastHeartbeat, &hidePresence)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
 		log.Println("Ошибка при получении данных пользователя:", err)
 		http.Error(w, "Ошибка при загрузке профиля", http.StatusInternalServerError)
 		return
 	}
 
+	if profileID != userID {
+		profileData.Presence = newPresence(lastSeen, lastHeartbeat, hidePresence)
+	}
+
 	// Получаем количество постов и друзей
-	err = DB.QueryRow(`SELECT COUNT(*) FROM posts WHERE user_id = $1`, userID).Scan(&profileData.PostCount)
+	err = DB.QueryRow(`
+		SELECT COUNT(*) FROM posts p
+		WHERE p.user_id = $1 AND p.hidden_at IS NULL AND p.held_at IS NULL AND `+visiblePostCondition("$2")+`
+	`, profileID, userID).Scan(&profileData.PostCount)
 	if err != nil {
 		log.Println("Ошибка при получении количества постов:", err)
 	}
 
-	err = DB.QueryRow(`SELECT COUNT(*) FROM friendships WHERE user_id = $1`, userID).Scan(&profileData.FriendCount)
+	friendsVisible, err := privacyAllows(privacyFriendList, profileID, userID)
 	if err != nil {
-		log.Println("Ошибка при получении количества друзей:", err)
+		log.Println("Ошибка при проверке настроек приватности:", err)
+	}
+	profileData.FriendsHidden = !friendsVisible
+	if friendsVisible {
+		profileData.FriendCount, err = CountFriends(profileID)
+		if err != nil {
+			log.Println("Ошибка при получении количества друзей:", err)
+		}
 	}
 
-	// Загружаем посты пользователя
+	// Загружаем посты пользователя, доступные зрителю. Задержанные фильтром посты видит только автор
 	rows, err := DB.Query(`
-		SELECT content, created_at
-		FROM posts
-		WHERE user_id = $1
-		ORDER BY created_at DESC
-	`, userID)
+		SELECT p.id, p.content, p.created_at, p.held_at IS NOT NULL, p.edited_at IS NOT NULL, p.audience
+		FROM posts p
+		WHERE p.user_id = $1 AND p.hidden_at IS NULL AND (p.held_at IS NULL OR p.user_id = $2)
+		  AND `+visiblePostCondition("$2")+`
+		ORDER BY p.created_at DESC
+	`, profileID, userID)
 	if err != nil {
 		log.Println("Ошибка при запросе постов:", err)
 		http.Error(w, "Ошибка при загрузке постов", http.StatusInternalServerError)
@@ -368,32 +417,72 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var post Post
 		var createdAt time.Time
-		if err := rows.Scan(&post.Content, &createdAt); err != nil {
+		if err := rows.Scan(&post.ID, &post.Content, &createdAt, &post.Held, &post.Edited, &post.Audience); err != nil {
 			log.Println("Ошибка при чтении поста:", err)
 			continue
 		}
+		post.AuthorID = profileID
+		post.Author = profileData.Username
 		post.CreatedAt = createdAt.Format("02.01.2006 15:04")
 		posts = append(posts, post)
 	}
+	attachComments(posts)
+	attachMentions(posts)
+	attachLinkPreviews(posts)
+	attachMedia(posts)
+	attachReactions(posts, userID)
 
 	// Если нет постов, помечаем
 	profileData.Posts = posts
 	profileData.NoPosts = len(posts) == 0
-	profileData.IsCurrentUser = true
-
-	// Рендерим профиль пользователя
-	tmplPath := filepath.Join("web", "templates", "profile.html")
-	tmpl, err := template.ParseFiles(tmplPath)
+	profileData.IsCurrentUser = profileID == userID
+	if !profileData.IsCurrentUser {
+		profileData.CanMessage, err = AreFriends(userID, profileID)
+		if err != nil {
+			log.Println("Ошибка при проверке дружбы:", err)
+		}
+		profileData.HasBlocked, err = HasBlocked(userID, profileID)
+		if err != nil {
+			log.Println("Ошибка при проверке чёрного списка:", err)
+		}
+	}
+	profileData.Follow, err = GetFollowStats(profileID, userID)
 	if err != nil {
-		log.Println("Ошибка при загрузке шаблона profile.html:", err)
-		http.Error(w, "Не удалось загрузить шаблон", http.StatusInternalServerError)
-		return
+		log.Println("Ошибка при получении подписок:", err)
 	}
 
-	err = tmpl.Execute(w, profileData)
+	// Рендерим профиль пользователя
+	renderTemplate(w, "profile.html", profileData)
+}
+
+// createPostData — данные формы создания поста
+type createPostData struct {
+	ErrorMsg      string
+	Text          string
+	Audience      string
+	Audiences     []Option
+	Lists         []FriendList
+	SelectedLists map[int]bool
+}
+
+// renderCreatePost показывает форму создания поста со списками друзей автора
+func renderCreatePost(w http.ResponseWriter, userID int, data createPostData) {
+	lists, err := ListFriendLists(userID)
 	if err != nil {
-		log.Println("Ошибка при рендеринге шаблона:", err)
+		log.Printf("Ошибка при загрузке списков друзей: %v\n", err)
+	}
+	data.Audiences = audienceOptions
+	data.Lists = lists
+	if data.Audience == "" {
+		data.Audience = AudiencePublic
+		settings, err := GetPrivacySettings(userID)
+		if err != nil {
+			log.Printf("Ошибка при загрузке настроек приватности: %v\n", err)
+		} else {
+			data.Audience = settings.DefaultAudience
+		}
 	}
+	renderTemplate(w, "create-post.html", data)
 }
 
 func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
@@ -407,36 +496,132 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Получаем данные из формы
+		if err := parsePostForm(w, r); err == ErrUploadTooLarge {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			log.Printf("Ошибка при разборе формы: %v\n", err)
+			http.Error(w, "Ошибка при загрузке файла", http.StatusBadRequest)
+			return
+		}
 		content := r.FormValue("content")
-		file, header, err := r.FormFile("image")
-		var imagePath string
+		audience := r.FormValue("audience")
+		if audience == "" {
+			audience = AudiencePublic
+		}
+		if !isValidAudience(audience) {
+			http.Error(w, ErrInvalidAudience.Error(), http.StatusBadRequest)
+			return
+		}
+		var listIDs []int
+		selectedLists := make(map[int]bool)
+		for _, value := range r.Form["list_id"] {
+			if id, err := strconv.Atoi(value); err == nil {
+				listIDs = append(listIDs, id)
+				selectedLists[id] = true
+			}
+		}
+		formData := createPostData{Text: content, Audience: audience, SelectedLists: selectedLists}
+		if audience == AudienceLists && len(listIDs) == 0 {
+			formData.ErrorMsg = ErrNoAudienceLists.Error()
+			renderCreatePost(w, userID, formData)
+			return
+		}
 
-		if err == nil && header != nil {
-			defer file.Close()
+		uploads, err := formAttachments(r)
+		if err != nil {
+			formData.ErrorMsg = err.Error()
+			renderCreatePost(w, userID, formData)
+			return
+		}
 
-			// Сохраняем файл
-			imagePath, err = SaveUploadedFile(file, header)
+		// Проверяем текст по правилам фильтра контента и эвристикам защиты от спама
+		decision := CheckContent(content, len(uploads) > 0)
+		if decision.Action != PolicyReject {
+			spamDecision, err := CheckPostActivity(userID, content)
 			if err != nil {
-				log.Printf("Ошибка при сохранении изображения: %v\n", err)
-				http.Error(w, "Ошибка при загрузке файла", http.StatusInternalServerError)
+				log.Printf("Ошибка при проверке активности пользователя: %v\n", err)
+				http.Error(w, "Ошибка при создании поста", http.StatusInternalServerError)
 				return
 			}
-		} else if err != http.ErrMissingFile {
-			log.Printf("Ошибка при загрузке файла: %v\n", err)
+			decision = stricterDecision(decision, spamDecision)
+		}
+		if decision.Action == PolicyReject {
+			formData.ErrorMsg = decision.Reason
+			renderCreatePost(w, userID, formData)
+			return
+		}
+
+		// Сохраняем файлы. Если пост не удастся сохранить, файлы удаляются
+		attachments, err := saveAttachments(uploads)
+		if err != nil {
+			log.Printf("Ошибка при сохранении вложений: %v\n", err)
 			http.Error(w, "Ошибка при загрузке файла", http.StatusInternalServerError)
 			return
 		}
+		committed := false
+		defer func() {
+			if !committed {
+				removeAttachmentFiles(attachments)
+			}
+		}()
 
-		// Сохраняем пост в базе данных
-		_, err = DB.Exec(`
-			INSERT INTO posts (user_id, content, image_url, created_at)
-			VALUES ($1, $2, $3, NOW())
-		`, userID, content, imagePath)
+		// Сохраняем пост в базе данных. Задержанный фильтром пост не виден до проверки модератором
+		held := decision.Action == PolicyHold
+		tx, err := DB.Begin()
 		if err != nil {
 			log.Printf("Ошибка при сохранении поста: %v\n", err)
 			http.Error(w, "Ошибка при создании поста", http.StatusInternalServerError)
 			return
 		}
+		defer tx.Rollback()
+
+		var postID int
+		err = tx.QueryRow(`
+			INSERT INTO posts (user_id, content, created_at, held_at, content_hash, audience)
+			VALUES ($1, $2, NOW(), CASE WHEN $3 THEN NOW() END, NULLIF($4, ''), $5)
+			RETURNING id
+		`, userID, decision.Content, held, contentHash(content), audience).Scan(&postID)
+		if err == nil && audience == AudienceLists {
+			err = setPostAudienceLists(tx, postID, userID, listIDs)
+		}
+		if err == nil {
+			err = saveHashtags(tx, postID, decision.Content)
+		}
+		if err == nil {
+			_, err = savePostMentions(tx, postID, decision.Content)
+		}
+		if err == nil {
+			err = setPostLink(tx, postID, decision.Content)
+		}
+		if err == nil {
+			err = insertPostAttachments(tx, postID, attachments)
+		}
+		if err == ErrNoAudienceLists {
+			formData.ErrorMsg = err.Error()
+			renderCreatePost(w, userID, formData)
+			return
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Ошибка при сохранении поста: %v\n", err)
+			http.Error(w, "Ошибка при создании поста", http.StatusInternalServerError)
+			return
+		}
+		committed = true
+		if err := fanOutPost(postID, userID); err != nil {
+			log.Printf("Ошибка при раскладке поста по лентам: %v\n", err)
+		}
+		if held {
+			holdForReview(ReportTargetPost, postID, userID, decision)
+		} else {
+			publishFeedPost(postID, userID)
+			if err := notifyMentions(ReportTargetPost, postID, nil); err != nil {
+				log.Printf("Ошибка при уведомлении об упоминаниях: %v\n", err)
+			}
+		}
 
 		// Перенаправляем на страницу с постами
 		http.Redirect(w, r, "/posts", http.StatusSeeOther)
@@ -444,31 +629,33 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Рендеринг страницы создания поста
-	tmplPath := filepath.Join("web", "templates", "create-post.html")
-	tmpl, err := template.ParseFiles(tmplPath)
+	userID, err := GetUserIDFromSession(r)
 	if err != nil {
-		log.Printf("Ошибка при загрузке шаблона create-post.html: %v\n", err)
-		http.Error(w, "Не удалось загрузить шаблон", http.StatusInternalServerError)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
-	tmpl.Execute(w, nil)
+	renderCreatePost(w, userID, createPostData{})
 }
 
-func SaveUploadedFile(file multipart.File, header *multipart.FileHeader) (string, error) {
-	uploadDir := "./uploads"
-	err := os.MkdirAll(uploadDir, os.ModePerm)
+// uploadDir — директория для файлов, загруженных пользователями
+const uploadDir = "./uploads"
+
+// saveUpload сохраняет файл name в поддиректорию subdir директории uploadDir и возвращает путь к нему
+func saveUpload(subdir, name string, src io.Reader) (string, error) {
+	dir := filepath.Join(uploadDir, subdir)
+	err := os.MkdirAll(dir, os.ModePerm)
 	if err != nil {
 		return "", fmt.Errorf("не удалось создать директорию для загрузки: %v", err)
 	}
 
-	filePath := filepath.Join(uploadDir, header.Filename)
+	filePath := filepath.Join(dir, name)
 	out, err := os.Create(filePath)
 	if err != nil {
 		return "", fmt.Errorf("не удалось создать файл: %v", err)
 	}
 	defer out.Close()
 
-	_, err = io.Copy(out, file)
+	_, err = io.Copy(out, src)
 	if err != nil {
 		return "", fmt.Errorf("ошибка при сохранении файла: %v", err)
 	}
@@ -476,6 +663,11 @@ func SaveUploadedFile(file multipart.File, header *multipart.FileHeader) (string
 	return filePath, nil
 }
 
+// uploadURL возвращает адрес, по которому сайт отдаёт сохранённый файл
+func uploadURL(path string) string {
+	return "/" + filepath.ToSlash(filepath.Clean(path))
+}
+
 func FindFriendsHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := GetUserIDFromSession(r) // Получение текущего пользователя
 	if err != nil {
@@ -496,18 +688,31 @@ func FindFriendsHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		data := struct {
-			Results []User
-		}{
-			Results: results,
+		header, err := loadHeaderData(userID)
+		if err != nil {
+			log.Println("Ошибка при получении данных пользователя:", err)
+			http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+			return
 		}
 
-		tmplPath := filepath.Join("web", "templates", "find-friends.html")
-		tmpl, err := template.ParseFiles(tmplPath)
+		suggestions, err := FriendSuggestions(userID, 10)
 		if err != nil {
-			log.Fatalf("Error parsing template: %v", err)
+			log.Println("Ошибка при загрузке рекомендаций друзей:", err)
 		}
-		tmpl.Execute(w, data)
+
+		data := struct {
+			Header      HeaderData
+			Name        string
+			Results     []User
+			Suggestions []Suggestion
+		}{
+			Header:      header,
+			Name:        name,
+			Results:     results,
+			Suggestions: suggestions,
+		}
+
+		renderTemplate(w, "find-friends.html", data)
 
 	case http.MethodPost:
 		friendID, err := strconv.Atoi(r.FormValue("friend_id"))
@@ -516,13 +721,34 @@ func FindFriendsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Новые аккаунты ограничены в количестве заявок в друзья
+		err = CheckFriendRequestRate(userID)
+		if err == ErrRateLimited {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			log.Println("Ошибка при проверке лимита заявок в друзья:", err)
+			http.Error(w, "Failed to add friend", http.StatusInternalServerError)
+			return
+		}
+
 		err = AddFriend(userID, friendID)
+		if err == ErrFriendSelf {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err == ErrUserBlocked || err == ErrFriendRequestsClosed || err == ErrFriendRequestsLimited {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to add friend: %v", err), http.StatusInternalServerError)
 			return
 		}
+		Audit(r, userID, AuditFriendAdd, "user", friendID, "")
 
-		http.Redirect(w, r, "/find-friends", http.StatusSeeOther)
+		redirectBack(w, r, "/find-friends")
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -0,0 +1,247 @@
+// internal/hashtags.go
+package internal
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// maxHashtagLength ограничивает длину тега в символах, более длинные не считаются тегами
+	maxHashtagLength = 50
+	// maxPostHashtags — сколько тегов одного поста сохраняется
+	maxPostHashtags = 10
+	// tagPageSize — сколько постов показывается на странице тега
+	tagPageSize = 50
+	// trendingWindow — за какой период учитываются посты при подсчёте популярных тегов
+	trendingWindow = 48 * time.Hour
+	// trendingHalfLife — за какое время вклад поста в популярность тега уменьшается вдвое
+	trendingHalfLife = 6 * time.Hour
+	// trendingTagsLimit — сколько популярных тегов хранится и показывается
+	trendingTagsLimit = 10
+)
+
+// TrendingTag — популярный тег и число его постов за последнее время
+type TrendingTag struct {
+	Name  string
+	Posts int
+}
+
+// normalizeHashtag приводит тег к виду, в котором он хранится в базе,
+// и возвращает пустую строку, если тег слишком длинный или состоит из одних цифр
+func normalizeHashtag(tag string) string {
+	if utf8.RuneCountInString(tag) > maxHashtagLength {
+		return ""
+	}
+	if strings.IndexFunc(tag, unicode.IsLetter) < 0 {
+		return ""
+	}
+	return strings.ToLower(tag)
+}
+
+// extractHashtags возвращает уникальные теги текста в порядке появления
+func extractHashtags(content string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, match := range textTokenPattern.FindAllStringSubmatch(content, -1) {
+		tag := normalizeHashtag(match[1])
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+		if len(tags) == maxPostHashtags {
+			break
+		}
+	}
+	return tags
+}
+
+// tagURL возвращает адрес страницы тега
+func tagURL(tag string) string {
+	return "/tag?name=" + url.QueryEscape(tag)
+}
+
+// saveHashtags сохраняет теги поста в той же транзакции, что и сам пост
+func saveHashtags(tx *sql.Tx, postID int, content string) error {
+	tags := extractHashtags(content)
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+		INSERT INTO tags (name)
+		SELECT unnest($1::text[])
+		ON CONFLICT (name) DO NOTHING
+	`, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO post_tags (post_id, tag_id, created_at)
+		SELECT p.id, t.id, p.created_at
+		FROM posts p, tags t
+		WHERE p.id = $1 AND t.name = ANY($2)
+		ON CONFLICT DO NOTHING
+	`, postID, pq.Array(tags))
+	return err
+}
+
+// ListTagPosts возвращает последние посты с тегом, доступные пользователю.
+// Посты пользователей из чёрного списка, в том числе тех, кто внёс в него viewerID, не показываются
+func ListTagPosts(tag string, viewerID int) ([]Post, error) {
+	rows, err := DB.Query(`
+		SELECT p.id, p.user_id, u.username, p.content, p.created_at, p.edited_at IS NOT NULL
+		FROM tags t
+		JOIN post_tags pt ON pt.tag_id = t.id
+		JOIN posts p ON p.id = pt.post_id
+		JOIN users u ON u.id = p.user_id AND u.deleted_at IS NULL
+		WHERE t.name = $1 AND p.hidden_at IS NULL AND p.held_at IS NULL
+		  AND `+visiblePostCondition("$2")+`
+		  AND NOT EXISTS (
+			SELECT 1 FROM user_blocks b
+			WHERE (b.blocker_id = $2 AND b.blocked_id = p.user_id)
+			   OR (b.blocker_id = p.user_id AND b.blocked_id = $2)
+		  )
+		ORDER BY pt.created_at DESC
+		LIMIT $3
+	`, tag, viewerID, tagPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		var createdAt time.Time
+		if err := rows.Scan(&post.ID, &post.AuthorID, &post.Author, &post.Content, &createdAt, &post.Edited); err != nil {
+			return nil, err
+		}
+		post.CreatedAt = createdAt.Format("02.01.2006 15:04")
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// refreshTrendingTags пересчитывает популярные теги. Учитываются только
+// публичные опубликованные посты за trendingWindow, вклад поста убывает вдвое
+// каждые trendingHalfLife. От одного автора в тег засчитывается только его
+// самый свежий пост, чтобы один пользователь не мог вывести тег в популярные
+func refreshTrendingTags() error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM trending_tags`); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO trending_tags (tag_id, score, posts)
+		SELECT tag_id, SUM(weight), SUM(posts)
+		FROM (
+			SELECT pt.tag_id, p.user_id,
+			       MAX(POWER(0.5, EXTRACT(EPOCH FROM NOW() - p.created_at) / $2)) AS weight,
+			       COUNT(*) AS posts
+			FROM post_tags pt
+			JOIN posts p ON p.id = pt.post_id
+			JOIN users u ON u.id = p.user_id AND u.deleted_at IS NULL
+			WHERE pt.created_at > NOW() - $1 * INTERVAL '1 second'
+			  AND p.audience = 'public' AND p.hidden_at IS NULL AND p.held_at IS NULL
+			GROUP BY pt.tag_id, p.user_id
+		) per_author
+		GROUP BY tag_id
+		ORDER BY SUM(weight) DESC
+		LIMIT $3
+	`, trendingWindow.Seconds(), trendingHalfLife.Seconds(), trendingTagsLimit)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TrendingTags возвращает популярные теги, посчитанные фоновой задачей
+func TrendingTags() ([]TrendingTag, error) {
+	rows, err := DB.Query(`
+		SELECT t.name, tr.posts
+		FROM trending_tags tr
+		JOIN tags t ON t.id = tr.tag_id
+		ORDER BY tr.score DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []TrendingTag
+	for rows.Next() {
+		var tag TrendingTag
+		if err := rows.Scan(&tag.Name, &tag.Posts); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// TagHandler показывает посты с тегом
+func TagHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	tag := normalizeHashtag(strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("name")), "#"))
+	if tag == "" {
+		http.Error(w, "Некорректный тег", http.StatusBadRequest)
+		return
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+
+	posts, err := ListTagPosts(tag, userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке постов с тегом:", err)
+		http.Error(w, "Ошибка при загрузке постов", http.StatusInternalServerError)
+		return
+	}
+	attachComments(posts)
+	attachMentions(posts)
+	attachLinkPreviews(posts)
+	attachMedia(posts)
+	attachReactions(posts, userID)
+
+	trending, err := TrendingTags()
+	if err != nil {
+		log.Println("Ошибка при загрузке популярных тегов:", err)
+	}
+
+	data := struct {
+		Header   HeaderData
+		Tag      string
+		Posts    []Post
+		Trending []TrendingTag
+	}{
+		Header:   header,
+		Tag:      tag,
+		Posts:    posts,
+		Trending: trending,
+	}
+
+	renderTemplate(w, "tag.html", data)
+}
@@ -0,0 +1,52 @@
+// internal/jobs.go
+package internal
+
+import (
+	"log"
+	"time"
+)
+
+// periodicJob описывает фоновую задачу, которая выполняется с заданным интервалом
+type periodicJob struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+// periodicJobs содержит все фоновые задачи сервера
+var periodicJobs = []periodicJob{
+	{Name: "выгрузка персональных данных", Interval: 30 * time.Second, Run: processDataExports},
+	{Name: "удаление устаревших выгрузок", Interval: time.Hour, Run: cleanupDataExports},
+	{Name: "удаление аккаунтов", Interval: time.Hour, Run: purgeDeletedAccounts},
+	{Name: "удаление устаревших событий", Interval: time.Hour, Run: cleanupRealtimeEvents},
+	{Name: "удаление старых уведомлений", Interval: time.Hour, Run: cleanupNotifications},
+	{Name: "отправка писем", Interval: 30 * time.Second, Run: processEmailOutbox},
+	{Name: "дайджесты постов друзей", Interval: time.Hour, Run: sendDigests},
+	{Name: "удаление отправленных писем", Interval: time.Hour, Run: cleanupEmailOutbox},
+	{Name: "доставка push-уведомлений", Interval: 10 * time.Second, Run: processPushDeliveries},
+	{Name: "удаление недоставленных push-уведомлений", Interval: time.Hour, Run: cleanupPushDeliveries},
+	{Name: "пересчёт рекомендаций друзей", Interval: 10 * time.Minute, Run: refreshStaleSuggestions},
+	{Name: "очистка лент", Interval: time.Hour, Run: trimTimelines},
+	{Name: "пересчёт популярных тегов", Interval: 5 * time.Minute, Run: refreshTrendingTags},
+	{Name: "загрузка превью ссылок", Interval: 10 * time.Second, Run: processLinkPreviews},
+	{Name: "удаление неиспользуемых превью ссылок", Interval: time.Hour, Run: cleanupLinkPreviews},
+}
+
+// StartBackgroundJobs запускает все фоновые задачи в отдельных горутинах
+func StartBackgroundJobs() {
+	for _, job := range periodicJobs {
+		go runPeriodic(job)
+	}
+}
+
+func runPeriodic(job periodicJob) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := job.Run(); err != nil {
+			log.Printf("Ошибка фоновой задачи «%s»: %v\n", job.Name, err)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,531 @@
+// internal/linkpreview.go
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// Превью ссылок. При публикации поста его первая ссылка ставится в очередь
+// link_previews, фоновая задача загружает страницу, разбирает метатеги
+// Open Graph и Twitter Cards и сохраняет картинку превью в uploads/previews.
+// Результат кешируется по адресу и используется всеми постами с этой ссылкой
+
+const (
+	// linkPreviewTTL — через сколько превью загружается заново, если ссылку снова публикуют
+	linkPreviewTTL = 7 * 24 * time.Hour
+	// linkPreviewRetention — сколько хранится превью, на которое не ссылается ни один пост
+	linkPreviewRetention = 30 * 24 * time.Hour
+	// linkPreviewMaxAttempts — сколько раз пытаться загрузить страницу
+	linkPreviewMaxAttempts = 3
+	// linkPreviewLease — через сколько повторяется попытка, если предыдущая не завершилась
+	linkPreviewLease = 10 * time.Minute
+
+	// linkPreviewTimeout ограничивает загрузку страницы или картинки целиком
+	linkPreviewTimeout = 8 * time.Second
+	// linkPreviewMaxPage — сколько байт страницы читается в поисках метатегов
+	linkPreviewMaxPage = 512 << 10
+	// linkPreviewMaxImage — максимальный размер картинки превью
+	linkPreviewMaxImage = 2 << 20
+	// linkPreviewMaxRedirects — сколько перенаправлений допускается
+	linkPreviewMaxRedirects = 3
+
+	maxPreviewTitle       = 200
+	maxPreviewDescription = 300
+	maxPreviewSiteName    = 100
+
+	// linkPreviewDir — поддиректория uploadDir для картинок превью
+	linkPreviewDir = "previews"
+)
+
+// Статусы превью в очереди
+const (
+	linkPreviewPending = "pending"
+	linkPreviewReady   = "ready"
+	linkPreviewFailed  = "failed"
+)
+
+var (
+	errLinkPreviewForbidden = errors.New("адрес находится в частной сети")
+	errLinkPreviewNotHTML   = errors.New("ссылка ведёт не на HTML-страницу")
+	errLinkPreviewEmpty     = errors.New("на странице нет метаданных для превью")
+	errLinkPreviewTooLarge  = errors.New("картинка превью слишком большая")
+)
+
+// previewImageTypes — допустимые типы картинок превью и расширения файлов для них
+var previewImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// LinkPreview — карточка ссылки из поста
+type LinkPreview struct {
+	URL         string
+	Title       string
+	Description string
+	SiteName    string
+	ImagePath   string // путь к картинке в uploadDir или пустая строка
+	ImageURL    string // адрес картинки для страницы
+}
+
+// postLinkURL возвращает адрес первой ссылки в тексте поста или пустую строку
+func postLinkURL(content string) string {
+	_, _, href := findURL(content)
+	return href
+}
+
+// setPostLink запоминает первую ссылку поста и ставит её превью в очередь,
+// если превью ещё нет или оно устарело
+func setPostLink(tx *sql.Tx, postID int, content string) error {
+	link := postLinkURL(content)
+	if _, err := tx.Exec(`UPDATE posts SET link_url = NULLIF($2, '') WHERE id = $1`, postID, link); err != nil {
+		return err
+	}
+	if link == "" {
+		return nil
+	}
+	_, err := tx.Exec(`
+		INSERT INTO link_previews (url) VALUES ($1)
+		ON CONFLICT (url) DO UPDATE
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), requested_at = NOW()
+		WHERE link_previews.status <> 'pending' AND link_previews.fetched_at < NOW() - $2 * INTERVAL '1 second'
+	`, link, linkPreviewTTL.Seconds())
+	return err
+}
+
+// isPublicIP проверяет, что адрес не относится к локальным, частным и служебным сетям
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// reservedNetworks — служебные сети, которые не покрываются методами net.IP
+var reservedNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // «эта» сеть
+		"100.64.0.0/10",  // CGNAT
+		"192.0.0.0/24",   // служебные адреса IETF
+		"198.18.0.0/15",  // тестирование производительности
+		"240.0.0.0/4",    // зарезервировано, включая широковещательный адрес
+		"64:ff9b::/96",   // NAT64 — может вести во внутреннюю IPv4-сеть
+		"64:ff9b:1::/48", // локальный NAT64
+		"2002::/16",      // 6to4 — тоже
+		"2001::/32",      // Teredo — тоже
+		"fec0::/10",      // устаревшие site-local
+		"100::/64",       // discard
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// newUnfurlClient создаёт HTTP-клиент для загрузки превью. Адрес проверяется
+// при установке соединения, уже после разрешения DNS, поэтому подмена
+// записи DNS или перенаправление на внутренний адрес не помогают обойти запрет.
+// allowPrivate снимает запрет на частные сети и нестандартные порты — для
+// тестов с локальным сервером и разработки
+func newUnfurlClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 3 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+			host, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) || (port != "80" && port != "443") {
+				return errLinkPreviewForbidden
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    3 * time.Second,
+		ResponseHeaderTimeout:  5 * time.Second,
+		MaxResponseHeaderBytes: 64 << 10,
+		DisableKeepAlives:      true,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   linkPreviewTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// via начинается с исходного запроса, поэтому перенаправлений в нём на одно меньше
+			if len(via) > linkPreviewMaxRedirects {
+				return errors.New("слишком много перенаправлений")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("недопустимая схема адреса")
+			}
+			return nil
+		},
+	}
+}
+
+// previewGet выполняет GET-запрос превью к адресу http(s)
+func previewGet(client *http.Client, rawURL, accept string) (*http.Response, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.User != nil {
+		return nil, fmt.Errorf("недопустимый адрес %q", rawURL)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "SocialNetworkBot/1.0 (link preview)")
+	req.Header.Set("Accept", accept)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("сервер ответил %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// unfurl загружает страницу и извлекает из неё превью. Картинка превью
+// загружается и сохраняется отдельно; если это не удалось, превью остаётся без картинки
+func unfurl(client *http.Client, pageURL string) (LinkPreview, error) {
+	resp, err := previewGet(client, pageURL, "text/html,application/xhtml+xml")
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return LinkPreview{}, errLinkPreviewNotHTML
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, linkPreviewMaxPage))
+	if err != nil {
+		return LinkPreview{}, err
+	}
+
+	// Относительные адреса картинок отсчитываются от адреса после перенаправлений
+	preview, imageURL := parsePreviewMeta(string(page), resp.Request.URL)
+	preview.URL = pageURL
+	if preview.Title == "" && preview.Description == "" {
+		return LinkPreview{}, errLinkPreviewEmpty
+	}
+
+	if imageURL != "" {
+		path, err := fetchPreviewImage(client, imageURL)
+		if err != nil {
+			log.Printf("Не удалось загрузить картинку превью %s: %v\n", imageURL, err)
+		} else {
+			preview.ImagePath = path
+		}
+	}
+	return preview, nil
+}
+
+var (
+	headEndPattern   = regexp.MustCompile(`(?i)</head\s*>|<body[\s>]`)
+	metaTagPattern   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	titleTagPattern  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title\s*>`)
+	attributePattern = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// parsePreviewMeta разбирает метатеги Open Graph и Twitter Cards, а при их
+// отсутствии — заголовок и описание страницы. Возвращает превью без картинки
+// и абсолютный адрес картинки
+func parsePreviewMeta(page string, base *url.URL) (LinkPreview, string) {
+	if loc := headEndPattern.FindStringIndex(page); loc != nil {
+		page = page[:loc[0]]
+	}
+
+	meta := make(map[string]string)
+	for _, tag := range metaTagPattern.FindAllString(page, -1) {
+		attrs := make(map[string]string)
+		for _, m := range attributePattern.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = m[2] + m[3] + m[4]
+		}
+		key := strings.ToLower(attrs["property"])
+		if key == "" {
+			key = strings.ToLower(attrs["name"])
+		}
+		// Первое значение считается основным, как у Open Graph
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = strings.TrimSpace(html.UnescapeString(attrs["content"]))
+		}
+	}
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if meta[key] != "" {
+				return meta[key]
+			}
+		}
+		return ""
+	}
+
+	preview := LinkPreview{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		SiteName:    first("og:site_name", "application-name"),
+	}
+	if preview.Title == "" {
+		if m := titleTagPattern.FindStringSubmatch(page); m != nil {
+			preview.Title = strings.Join(strings.Fields(html.UnescapeString(m[1])), " ")
+		}
+	}
+	if preview.SiteName == "" && base != nil {
+		preview.SiteName = strings.TrimPrefix(base.Hostname(), "www.")
+	}
+	preview.Title = truncateRunes(preview.Title, maxPreviewTitle)
+	preview.Description = truncateRunes(preview.Description, maxPreviewDescription)
+	preview.SiteName = truncateRunes(preview.SiteName, maxPreviewSiteName)
+
+	var imageURL string
+	if image := first("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src"); image != "" && base != nil {
+		if ref, err := url.Parse(image); err == nil {
+			resolved := base.ResolveReference(ref)
+			if resolved.Scheme == "http" || resolved.Scheme == "https" {
+				imageURL = resolved.String()
+			}
+		}
+	}
+	return preview, imageURL
+}
+
+// truncateRunes обрезает строку до limit символов, добавляя многоточие
+func truncateRunes(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:limit-1])) + "…"
+}
+
+// fetchPreviewImage загружает картинку превью и сохраняет её в uploads/previews
+func fetchPreviewImage(client *http.Client, imageURL string) (string, error) {
+	resp, err := previewGet(client, imageURL, "image/*")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	ext, ok := previewImageTypes[mediaType]
+	if !ok {
+		return "", fmt.Errorf("недопустимый тип картинки %q", mediaType)
+	}
+	if resp.ContentLength > linkPreviewMaxImage {
+		return "", errLinkPreviewTooLarge
+	}
+
+	// Читаем на байт больше лимита, чтобы отличить слишком большую картинку
+	data, err := io.ReadAll(io.LimitReader(resp.Body, linkPreviewMaxImage+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > linkPreviewMaxImage {
+		return "", errLinkPreviewTooLarge
+	}
+	// Тип проверяется и по содержимому, а не только по заголовку сервера
+	if sniffed := http.DetectContentType(data); sniffed != mediaType {
+		return "", fmt.Errorf("содержимое картинки не соответствует типу %q", mediaType)
+	}
+
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err != nil {
+		return "", err
+	}
+	return saveUpload(linkPreviewDir, hex.EncodeToString(name)+ext, bytes.NewReader(data))
+}
+
+// processLinkPreviews загружает превью из очереди
+func processLinkPreviews() error {
+	client := newUnfurlClient(AppConfig.LinkPreviewAllowPrivate)
+	for {
+		var pageURL string
+		var attempts int
+		err := DB.QueryRow(`
+			UPDATE link_previews
+			SET attempts = attempts + 1, next_attempt_at = NOW() + $1 * INTERVAL '1 second'
+			WHERE url = (
+				SELECT url FROM link_previews
+				WHERE status = 'pending' AND next_attempt_at <= NOW()
+				ORDER BY requested_at
+				FOR UPDATE SKIP LOCKED
+				LIMIT 1
+			)
+			RETURNING url, attempts
+		`, linkPreviewLease.Seconds()).Scan(&pageURL, &attempts)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		preview, fetchErr := unfurl(client, pageURL)
+		if fetchErr != nil {
+			status := linkPreviewPending
+			if attempts >= linkPreviewMaxAttempts || errors.Is(fetchErr, errLinkPreviewForbidden) ||
+				errors.Is(fetchErr, errLinkPreviewNotHTML) || errors.Is(fetchErr, errLinkPreviewEmpty) {
+				status = linkPreviewFailed
+			}
+			log.Printf("Не удалось получить превью %s: %v\n", pageURL, fetchErr)
+			_, err = DB.Exec(`
+				UPDATE link_previews SET status = $2, fetched_at = CASE WHEN $2 = 'failed' THEN NOW() END WHERE url = $1
+			`, pageURL, status)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var oldImage sql.NullString
+		err = DB.QueryRow(`
+			UPDATE link_previews lp
+			SET status = 'ready', title = $2, description = $3, site_name = $4,
+			    image_path = NULLIF($5, ''), fetched_at = NOW()
+			FROM (SELECT image_path FROM link_previews WHERE url = $1) old
+			WHERE lp.url = $1
+			RETURNING old.image_path
+		`, pageURL, preview.Title, preview.Description, preview.SiteName, preview.ImagePath).Scan(&oldImage)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		removePreviewImage(oldImage.String)
+	}
+}
+
+// removePreviewImage удаляет файл картинки превью
+func removePreviewImage(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Println("Ошибка при удалении картинки превью:", err)
+	}
+}
+
+// cleanupLinkPreviews удаляет превью, на которые давно не ссылается ни один пост
+func cleanupLinkPreviews() error {
+	rows, err := DB.Query(`
+		DELETE FROM link_previews lp
+		WHERE lp.requested_at < NOW() - $1 * INTERVAL '1 second'
+		  AND NOT EXISTS (SELECT 1 FROM posts p WHERE p.link_url = lp.url)
+		RETURNING COALESCE(image_path, '')
+	`, linkPreviewRetention.Seconds())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return err
+		}
+		removePreviewImage(path)
+	}
+	return rows.Err()
+}
+
+// attachLinkPreviews подгружает готовые превью ссылок к постам
+func attachLinkPreviews(posts []Post) {
+	if len(posts) == 0 {
+		return
+	}
+	postIDs := make([]int, 0, len(posts))
+	for _, post := range posts {
+		postIDs = append(postIDs, post.ID)
+	}
+
+	rows, err := DB.Query(`
+		SELECT p.id, lp.url, COALESCE(lp.title, ''), COALESCE(lp.description, ''),
+		       COALESCE(lp.site_name, ''), COALESCE(lp.image_path, '')
+		FROM posts p
+		JOIN link_previews lp ON lp.url = p.link_url AND lp.status = 'ready'
+		WHERE p.id = ANY($1)
+	`, pq.Array(postIDs))
+	if err != nil {
+		log.Println("Ошибка при загрузке превью ссылок:", err)
+		return
+	}
+	defer rows.Close()
+
+	previews := make(map[int]*LinkPreview)
+	for rows.Next() {
+		var postID int
+		var preview LinkPreview
+		if err := rows.Scan(&postID, &preview.URL, &preview.Title, &preview.Description, &preview.SiteName, &preview.ImagePath); err != nil {
+			log.Println("Ошибка при чтении превью ссылки:", err)
+			return
+		}
+		if preview.ImagePath != "" {
+			preview.ImageURL = uploadURL(preview.ImagePath)
+		}
+		previews[postID] = &preview
+	}
+	for i := range posts {
+		posts[i].Preview = previews[posts[i].ID]
+	}
+}
+
+// LinkPreviewImageHandler отдаёт сохранённые картинки превью ссылок.
+// Отдаются только файлы из uploads/previews, списки файлов директории не показываются
+func LinkPreviewImageHandler(w http.ResponseWriter, r *http.Request) {
+	path := uploadedFilePath(r.URL.Path)
+	if path == "" || filepath.Dir(path) != filepath.Join(filepath.Clean(uploadDir), linkPreviewDir) {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,195 @@
+// internal/linkpreview_test.go
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testPNG — начало PNG-файла, по которому http.DetectContentType определяет тип
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// inTempDir переходит на время теста во временную директорию, куда сохраняются загрузки
+func inTempDir(t *testing.T) {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+}
+
+// startPreviewServer запускает сайт со страницами и картинками для превью
+func startPreviewServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	page := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, body)
+		}
+	}
+	mux.HandleFunc("/og", page(`<html><head>
+		<title>Заголовок страницы</title>
+		<meta property="og:title" content="Заголовок &amp; OG">
+		<meta property="og:description" content='Описание OG'>
+		<meta name="description" content="Описание страницы">
+		<meta property="og:site_name" content="Пример">
+		</head><body><meta property="og:image" content="/late.png"></body></html>`))
+	mux.HandleFunc("/twitter", page(`<head>
+		<meta name="twitter:title" content="Заголовок Twitter">
+		<meta name="twitter:description" content="Описание Twitter">
+		</head>`))
+	mux.HandleFunc("/plain", page(`<head><title>
+		Только   заголовок
+		</title></head>`))
+	mux.HandleFunc("/empty", page(`<head></head><body>Текст</body>`))
+	mux.HandleFunc("/articles/new", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/articles/2024/post", http.StatusFound)
+	})
+	mux.HandleFunc("/articles/2024/post", page(`<head>
+		<meta property="og:title" content="Статья">
+		<meta property="og:image" content="../images/cover.png">
+		</head>`))
+	mux.HandleFunc("/articles/images/cover.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(testPNG)
+	})
+	// /redirect/N перенаправляет N раз, прежде чем отдать страницу
+	mux.HandleFunc("/redirect/", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/"))
+		if n > 0 {
+			http.Redirect(w, r, "/redirect/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		page(`<head><title>После перенаправлений</title></head>`)(w, r)
+	})
+	mux.HandleFunc("/large.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(linkPreviewMaxImage+1))
+		w.Write(testPNG)
+	})
+	mux.HandleFunc("/large-chunked.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.(http.Flusher).Flush()
+		w.Write(testPNG)
+		w.Write(bytes.Repeat([]byte{0}, linkPreviewMaxImage))
+	})
+	mux.HandleFunc("/fake.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "<html><body>не картинка</body></html>")
+	})
+	mux.HandleFunc("/page.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(testPNG)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUnfurlParsesMetadata(t *testing.T) {
+	server := startPreviewServer(t)
+	client := newUnfurlClient(true)
+
+	tests := []struct {
+		path string
+		want LinkPreview
+	}{
+		// Картинка после <body> не учитывается, название сайта берётся из og:site_name
+		{"/og", LinkPreview{Title: "Заголовок & OG", Description: "Описание OG", SiteName: "Пример"}},
+		{"/twitter", LinkPreview{Title: "Заголовок Twitter", Description: "Описание Twitter", SiteName: "127.0.0.1"}},
+		{"/plain", LinkPreview{Title: "Только заголовок", SiteName: "127.0.0.1"}},
+	}
+	for _, tt := range tests {
+		tt.want.URL = server.URL + tt.path
+		got, err := unfurl(client, tt.want.URL)
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: %+v, ожидалось %+v", tt.path, got, tt.want)
+		}
+	}
+
+	if _, err := unfurl(client, server.URL+"/empty"); !errors.Is(err, errLinkPreviewEmpty) {
+		t.Errorf("страница без метаданных: %v", err)
+	}
+	if _, err := unfurl(client, server.URL+"/large.png"); !errors.Is(err, errLinkPreviewNotHTML) {
+		t.Errorf("картинка вместо страницы: %v", err)
+	}
+}
+
+func TestUnfurlResolvesImageAfterRedirect(t *testing.T) {
+	inTempDir(t)
+	server := startPreviewServer(t)
+
+	preview, err := unfurl(newUnfurlClient(true), server.URL+"/articles/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.URL != server.URL+"/articles/new" || preview.Title != "Статья" {
+		t.Errorf("превью: %+v", preview)
+	}
+	// ../images/cover.png отсчитывается от /articles/2024/post, а не от /articles/new
+	data, err := os.ReadFile(preview.ImagePath)
+	if err != nil {
+		t.Fatalf("картинка превью не сохранена: %v", err)
+	}
+	if !bytes.Equal(data, testPNG) || !strings.HasSuffix(preview.ImagePath, ".png") {
+		t.Errorf("сохранена картинка %s: %q", preview.ImagePath, data)
+	}
+}
+
+func TestUnfurlLimitsRedirects(t *testing.T) {
+	server := startPreviewServer(t)
+	client := newUnfurlClient(true)
+
+	if _, err := unfurl(client, server.URL+"/redirect/"+strconv.Itoa(linkPreviewMaxRedirects)); err != nil {
+		t.Errorf("%d перенаправления: %v", linkPreviewMaxRedirects, err)
+	}
+	_, err := unfurl(client, server.URL+"/redirect/"+strconv.Itoa(linkPreviewMaxRedirects+1))
+	if err == nil || !strings.Contains(err.Error(), "слишком много перенаправлений") {
+		t.Errorf("%d перенаправлений: %v", linkPreviewMaxRedirects+1, err)
+	}
+}
+
+func TestFetchPreviewImageRejectsInvalidImages(t *testing.T) {
+	inTempDir(t)
+	server := startPreviewServer(t)
+	client := newUnfurlClient(true)
+
+	for _, path := range []string{"/large.png", "/large-chunked.png"} {
+		if _, err := fetchPreviewImage(client, server.URL+path); !errors.Is(err, errLinkPreviewTooLarge) {
+			t.Errorf("%s: %v, ожидалась ошибка размера", path, err)
+		}
+	}
+	for _, path := range []string{"/fake.png", "/page.png"} {
+		if _, err := fetchPreviewImage(client, server.URL+path); err == nil {
+			t.Errorf("%s: картинка принята", path)
+		}
+	}
+	if entries, _ := os.ReadDir(uploadDir + "/" + linkPreviewDir); len(entries) != 0 {
+		t.Errorf("отклонённые картинки сохранены: %d файлов", len(entries))
+	}
+}
+
+func TestUnfurlClientRejectsPrivateAddresses(t *testing.T) {
+	server := startPreviewServer(t)
+
+	_, err := unfurl(newUnfurlClient(false), server.URL+"/og")
+	if !errors.Is(err, errLinkPreviewForbidden) {
+		t.Errorf("запрос к %s: %v", server.URL, err)
+	}
+}
@@ -0,0 +1,211 @@
+// internal/mailer.go
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// EmailMessage — письмо с HTML- и текстовой версией
+type EmailMessage struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// UnsubscribeURL добавляется в заголовки List-Unsubscribe для отписки в один клик
+	UnsubscribeURL string
+}
+
+// Mailer отправляет письма
+type Mailer interface {
+	Send(message EmailMessage) error
+}
+
+var mailer Mailer
+
+// InitMailer выбирает способ отправки писем: SMTP, если он настроен,
+// иначе письма только записываются в лог
+func InitMailer() {
+	initEmailSigningKey()
+	if AppConfig.SMTPHost == "" {
+		log.Println("SMTP не настроен, письма будут только записываться в лог")
+		mailer = logMailer{}
+		return
+	}
+
+	from := &mail.Address{Name: "Социальная сеть", Address: "noreply@" + AppConfig.SMTPHost}
+	if AppConfig.SMTPFrom != "" {
+		var err error
+		if from, err = mail.ParseAddress(AppConfig.SMTPFrom); err != nil {
+			log.Fatal("Некорректный адрес отправителя SMTPFrom:", err)
+		}
+	}
+	mailer = &smtpMailer{
+		host:     AppConfig.SMTPHost,
+		port:     AppConfig.SMTPPort,
+		username: AppConfig.SMTPUsername,
+		password: AppConfig.SMTPPassword,
+		from:     from,
+	}
+}
+
+// logMailer записывает письма в лог вместо отправки
+type logMailer struct{}
+
+func (logMailer) Send(message EmailMessage) error {
+	log.Printf("Письмо для %s: %s\n%s\n", message.To, message.Subject, message.Text)
+	return nil
+}
+
+// smtpMailer отправляет письма через SMTP-сервер. Порт 465 означает TLS
+// с самого начала соединения, на остальных портах используется STARTTLS,
+// если сервер его поддерживает. Без логина подходит для локальных SMTP-ловушек
+type smtpMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     *mail.Address
+}
+
+func (m *smtpMailer) Send(message EmailMessage) error {
+	port := m.port
+	if port == "" {
+		port = "25"
+	}
+	addr := net.JoinHostPort(m.host, port)
+	tlsConfig := &tls.Config{ServerName: m.host}
+
+	var conn net.Conn
+	var err error
+	if port == "465" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 30*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != "465" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(m.from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return err
+	}
+
+	body, err := buildMIMEMessage(m.from, message)
+	if err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildMIMEMessage собирает письмо multipart/alternative с текстовой и HTML-частью
+func buildMIMEMessage(from *mail.Address, message EmailMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + message.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", message.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + newMessageID(from.Address),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + body.Boundary(),
+	}
+	if message.UnsubscribeURL != "" {
+		headers = append(headers,
+			"List-Unsubscribe: <"+message.UnsubscribeURL+">",
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click")
+	}
+	var out bytes.Buffer
+	out.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.HTML},
+	}
+	for _, part := range parts {
+		if part.content == "" {
+			continue
+		}
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := io.WriteString(qp, part.content); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+
+	out.Write(buf.Bytes())
+	return out.Bytes(), nil
+}
+
+// newMessageID создаёт уникальный Message-ID в домене отправителя
+func newMessageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	random := make([]byte, 16)
+	rand.Read(random)
+	return "<" + hex.EncodeToString(random) + "@" + domain + ">"
+}
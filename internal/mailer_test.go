@@ -0,0 +1,211 @@
+// internal/mailer_test.go
+package internal
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpSink — локальный SMTP-сервер для тестов: принимает письма и складывает их в канал.
+// Пока задан reject, получатели отклоняются с этим текстом ошибки
+type smtpSink struct {
+	listener net.Listener
+	messages chan sinkMessage
+
+	mu     sync.Mutex
+	reject string
+}
+
+// sinkMessage — письмо, принятое smtpSink
+type sinkMessage struct {
+	From string
+	To   string
+	Data []byte
+}
+
+func startSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &smtpSink{listener: listener, messages: make(chan sinkMessage, 16)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (s *smtpSink) setReject(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reject = reason
+}
+
+func (s *smtpSink) rejecting() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reject
+}
+
+// mailer возвращает smtpMailer, отправляющий письма в этот сервер
+func (s *smtpSink) mailer() *smtpMailer {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return &smtpMailer{host: host, port: port, from: &mail.Address{Name: "Социальная сеть", Address: "noreply@example.com"}}
+}
+
+// receive ждёт письмо для адреса to, пропуская письма другим получателям
+func (s *smtpSink) receive(t *testing.T, to string) sinkMessage {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case message := <-s.messages:
+			if message.To == to {
+				return message
+			}
+		case <-timeout:
+			t.Fatalf("письмо для %s не пришло", to)
+		}
+	}
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+
+	var message sinkMessage
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			text.PrintfLine("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message = sinkMessage{From: smtpPath(line)}
+			text.PrintfLine("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			if reason := s.rejecting(); reason != "" {
+				text.PrintfLine("550 %s", reason)
+				continue
+			}
+			message.To = smtpPath(line)
+			text.PrintfLine("250 OK")
+		case command == "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			if message.Data, err = text.ReadDotBytes(); err != nil {
+				return
+			}
+			s.messages <- message
+			text.PrintfLine("250 OK")
+		case command == "RSET", command == "NOOP":
+			text.PrintfLine("250 OK")
+		case command == "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// smtpPath извлекает адрес из команды MAIL FROM:<...> или RCPT TO:<...>
+func smtpPath(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+func TestSMTPMailerSendsMessage(t *testing.T) {
+	sink := startSMTPSink(t)
+	message := EmailMessage{
+		To:             "user@example.com",
+		Subject:        "Новый комментарий к вашему посту",
+		Text:           "Иван прокомментировал ваш пост",
+		HTML:           "<p>Иван прокомментировал ваш пост</p>",
+		UnsubscribeURL: "https://example.com/email/unsubscribe?u=1&s=all&sig=x",
+	}
+	if err := sink.mailer().Send(message); err != nil {
+		t.Fatal(err)
+	}
+
+	received := sink.receive(t, message.To)
+	if received.From != "noreply@example.com" {
+		t.Errorf("MAIL FROM = %q", received.From)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(received.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != message.Subject {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	if got := parsed.Header.Get("List-Unsubscribe"); got != "<"+message.UnsubscribeURL+">" {
+		t.Errorf("List-Unsubscribe = %q", got)
+	}
+	if got := parsed.Header.Get("List-Unsubscribe-Post"); got != "List-Unsubscribe=One-Click" {
+		t.Errorf("List-Unsubscribe-Post = %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v", mediaType, err)
+	}
+	parts := map[string]string{}
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[part.Header.Get("Content-Type")] = string(content)
+	}
+	if got := parts["text/plain; charset=utf-8"]; got != message.Text {
+		t.Errorf("текстовая часть = %q", got)
+	}
+	if got := parts["text/html; charset=utf-8"]; got != message.HTML {
+		t.Errorf("HTML-часть = %q", got)
+	}
+}
+
+func TestSMTPMailerReportsRejectedRecipient(t *testing.T) {
+	sink := startSMTPSink(t)
+	sink.setReject("mailbox unavailable")
+
+	err := sink.mailer().Send(EmailMessage{To: "user@example.com", Subject: "Тема", Text: "Текст"})
+	if err == nil || !strings.Contains(err.Error(), "mailbox unavailable") {
+		t.Fatalf("err = %v", err)
+	}
+}
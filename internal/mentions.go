@@ -0,0 +1,297 @@
+// internal/mentions.go
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// maxMentions — сколько упоминаний одного поста или комментария сохраняется
+	maxMentions = 10
+	// mentionSuggestionsLimit — сколько пользователей предлагает автодополнение
+	mentionSuggestionsLimit = 8
+)
+
+// trimMention отбрасывает точки и дефисы в конце упоминания: «@anna.» — это упоминание anna
+func trimMention(name string) string {
+	return strings.TrimRight(name, ".-")
+}
+
+// extractMentions возвращает уникальные имена упомянутых пользователей в порядке появления
+func extractMentions(content string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range textTokenPattern.FindAllStringSubmatch(content, -1) {
+		name := trimMention(match[2])
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) == maxMentions {
+			break
+		}
+	}
+	return names
+}
+
+// savePostMentions приводит упоминания поста в соответствие с его текстом
+// в той же транзакции, что и сам пост, и возвращает ID впервые упомянутых пользователей
+func savePostMentions(tx *sql.Tx, postID int, content string) ([]int, error) {
+	names := extractMentions(content)
+	_, err := tx.Exec(`
+		DELETE FROM post_mentions
+		WHERE post_id = $1 AND user_id NOT IN (SELECT id FROM users WHERE username = ANY($2))
+	`, postID, pq.Array(names))
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		INSERT INTO post_mentions (post_id, user_id)
+		SELECT $1, id FROM users WHERE username = ANY($2) AND deleted_at IS NULL
+		ON CONFLICT DO NOTHING
+		RETURNING user_id
+	`, postID, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	added := []int{}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		added = append(added, userID)
+	}
+	return added, rows.Err()
+}
+
+// saveCommentMentions сохраняет упоминания существующих пользователей в комментарии
+func saveCommentMentions(commentID int, content string) error {
+	names := extractMentions(content)
+	if len(names) == 0 {
+		return nil
+	}
+	_, err := DB.Exec(`
+		INSERT INTO comment_mentions (comment_id, user_id)
+		SELECT $1, id FROM users WHERE username = ANY($2) AND deleted_at IS NULL
+		ON CONFLICT DO NOTHING
+	`, commentID, pq.Array(names))
+	return err
+}
+
+// notifyMentions уведомляет упомянутых в опубликованном посте или комментарии
+// пользователей. Уведомление получают только те, кому виден пост, и только если
+// автор и упомянутый не внесли друг друга в чёрный список. Если userIDs не nil,
+// уведомляются только перечисленные пользователи
+func notifyMentions(targetType string, targetID int, userIDs []int) error {
+	var query string
+	switch targetType {
+	case ReportTargetPost:
+		query = `
+			SELECT m.user_id, p.user_id, p.id
+			FROM post_mentions m
+			JOIN posts p ON p.id = m.post_id
+			WHERE m.post_id = $1`
+	case ReportTargetComment:
+		query = `
+			SELECT m.user_id, c.user_id, p.id
+			FROM comment_mentions m
+			JOIN comments c ON c.id = m.comment_id
+			JOIN posts p ON p.id = c.post_id
+			WHERE m.comment_id = $1`
+	default:
+		return nil
+	}
+
+	args := []interface{}{targetID}
+	if userIDs != nil {
+		if len(userIDs) == 0 {
+			return nil
+		}
+		query += ` AND m.user_id = ANY($2)`
+		args = append(args, pq.Array(userIDs))
+	}
+
+	rows, err := DB.Query(query+`
+		  AND p.hidden_at IS NULL AND p.held_at IS NULL
+		  AND `+visiblePostCondition("m.user_id")+`
+		  AND NOT EXISTS (
+			SELECT 1 FROM user_blocks b
+			WHERE (b.blocker_id = m.user_id AND b.blocked_id = p.user_id)
+			   OR (b.blocker_id = p.user_id AND b.blocked_id = m.user_id)
+		  )
+	`, args...)
+	if err != nil {
+		return err
+	}
+
+	type mention struct{ userID, authorID, postID int }
+	var mentions []mention
+	for rows.Next() {
+		var m mention
+		if err := rows.Scan(&m.userID, &m.authorID, &m.postID); err != nil {
+			rows.Close()
+			return err
+		}
+		mentions = append(mentions, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range mentions {
+		if err := Notify(m.userID, NotificationMention, m.authorID, "post", m.postID, "Вас упомянули"); err != nil {
+			log.Println("Ошибка при создании уведомления:", err)
+		}
+	}
+	return nil
+}
+
+// attachMentions подгружает упомянутых пользователей к постам и их комментариям,
+// чтобы упоминания отображались ссылками на профили
+func attachMentions(posts []Post) {
+	var postIDs, commentIDs []int
+	for _, post := range posts {
+		postIDs = append(postIDs, post.ID)
+		for _, comment := range post.Comments {
+			commentIDs = append(commentIDs, comment.ID)
+		}
+	}
+	if len(postIDs) == 0 {
+		return
+	}
+
+	postMentions, err := loadMentions(`
+		SELECT m.post_id, u.id, u.username
+		FROM post_mentions m
+		JOIN users u ON u.id = m.user_id AND u.deleted_at IS NULL
+		WHERE m.post_id = ANY($1)
+	`, postIDs)
+	if err != nil {
+		log.Println("Ошибка при загрузке упоминаний:", err)
+		return
+	}
+	commentMentions, err := loadMentions(`
+		SELECT m.comment_id, u.id, u.username
+		FROM comment_mentions m
+		JOIN users u ON u.id = m.user_id AND u.deleted_at IS NULL
+		WHERE m.comment_id = ANY($1)
+	`, commentIDs)
+	if err != nil {
+		log.Println("Ошибка при загрузке упоминаний:", err)
+		return
+	}
+
+	for i := range posts {
+		posts[i].Mentions = postMentions[posts[i].ID]
+		for j := range posts[i].Comments {
+			posts[i].Comments[j].Mentions = commentMentions[posts[i].Comments[j].ID]
+		}
+	}
+}
+
+// loadMentions выполняет запрос, возвращающий (ID объекта, ID пользователя, имя),
+// и группирует упоминания по объектам
+func loadMentions(query string, ids []int) (map[int]map[string]int, error) {
+	mentions := make(map[int]map[string]int)
+	if len(ids) == 0 {
+		return mentions, nil
+	}
+
+	rows, err := DB.Query(query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var objectID, userID int
+		var username string
+		if err := rows.Scan(&objectID, &userID, &username); err != nil {
+			return nil, err
+		}
+		if mentions[objectID] == nil {
+			mentions[objectID] = make(map[string]int)
+		}
+		mentions[objectID][username] = userID
+	}
+	return mentions, rows.Err()
+}
+
+// MentionSuggestion — вариант автодополнения упоминания
+type MentionSuggestion struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Friend   bool   `json:"friend"`
+}
+
+// MentionSuggestions подбирает пользователей для упоминания по началу имени.
+// Поиск учитывает настройки приватности, пользователи из чёрного списка
+// не предлагаются. Сначала идут друзья, затем остальные по алфавиту
+func MentionSuggestions(query string, userID int) ([]MentionSuggestion, error) {
+	rows, err := DB.Query(`
+		SELECT u.id, u.username, f.id IS NOT NULL AS friend
+		FROM users u
+		LEFT JOIN (
+			SELECT friend_id AS id FROM friendships WHERE user_id = $2
+			UNION
+			SELECT user_id FROM friendships WHERE friend_id = $2
+		) f ON f.id = u.id
+		WHERE LOWER(u.username) LIKE LOWER($1) AND `+searchableUserCondition("u", "$2")+`
+		  AND NOT EXISTS (
+			SELECT 1 FROM user_blocks b
+			WHERE (b.blocker_id = $2 AND b.blocked_id = u.id)
+			   OR (b.blocker_id = u.id AND b.blocked_id = $2)
+		  )
+		ORDER BY friend DESC, LOWER(u.username)
+		LIMIT $3
+	`, escapeLike(query)+"%", userID, mentionSuggestionsLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []MentionSuggestion{}
+	for rows.Next() {
+		var suggestion MentionSuggestion
+		if err := rows.Scan(&suggestion.ID, &suggestion.Username, &suggestion.Friend); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions, rows.Err()
+}
+
+// MentionAutocompleteHandler возвращает в JSON пользователей для автодополнения упоминания
+func MentionAutocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	query := strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("q")), "@")
+	suggestions := []MentionSuggestion{}
+	if query != "" {
+		suggestions, err = MentionSuggestions(query, userID)
+		if err != nil {
+			log.Println("Ошибка при подборе пользователей для упоминания:", err)
+			http.Error(w, "Ошибка при поиске пользователей", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions)
+}
@@ -0,0 +1,590 @@
+// internal/messages.go
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Типы сообщений
+const (
+	MessageKindText   = "text"
+	MessageKindSystem = "system"
+)
+
+// messagesPageSize — количество сообщений, загружаемых за один раз
+const messagesPageSize = 30
+
+// maxMessageLength ограничивает длину одного сообщения
+const maxMessageLength = 4000
+
+var (
+	ErrNotFriends            = errors.New("переписка доступна только друзьям")
+	ErrNotConversationMember = errors.New("вы не участник этого диалога")
+	ErrEmptyMessage          = errors.New("сообщение не может быть пустым")
+	ErrMessageTooLong        = fmt.Errorf("сообщение должно быть не длиннее %d символов", maxMessageLength)
+)
+
+// ConversationSummary описывает диалог в списке входящих
+type ConversationSummary struct {
+	ID            int
+	Title         string
+	IsGroup       bool
+	LastMessage   string
+	LastMessageAt time.Time
+	UnreadCount   int
+}
+
+// Message представляет сообщение в диалоге
+type Message struct {
+	ID             int64
+	ConversationID int
+	SenderID       int
+	SenderName     string
+	Kind           string
+	Body           string
+	CreatedAt      time.Time
+}
+
+// directKey возвращает ключ диалога двух пользователей, не зависящий от порядка
+func directKey(userID, otherID int) string {
+	if userID > otherID {
+		userID, otherID = otherID, userID
+	}
+	return fmt.Sprintf("%d:%d", userID, otherID)
+}
+
+// GetOrCreateDirectConversation возвращает диалог двух друзей, создавая его при необходимости
+func GetOrCreateDirectConversation(userID, otherID int) (int, error) {
+	friends, err := AreFriends(userID, otherID)
+	if err != nil {
+		return 0, err
+	}
+	if !friends || userID == otherID {
+		return 0, ErrNotFriends
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var conversationID int
+	err = tx.QueryRow(`
+		INSERT INTO conversations (direct_key, created_by)
+		VALUES ($1, $2)
+		ON CONFLICT (direct_key) DO UPDATE SET direct_key = EXCLUDED.direct_key
+		RETURNING id
+	`, directKey(userID, otherID), userID).Scan(&conversationID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO conversation_members (conversation_id, user_id)
+		VALUES ($1, $2), ($1, $3)
+		ON CONFLICT DO NOTHING
+	`, conversationID, userID, otherID)
+	if err != nil {
+		return 0, err
+	}
+	return conversationID, tx.Commit()
+}
+
+// IsConversationMember проверяет, состоит ли пользователь в диалоге
+func IsConversationMember(conversationID, userID int) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM conversation_members WHERE conversation_id = $1 AND user_id = $2)
+	`, conversationID, userID).Scan(&exists)
+	return exists, err
+}
+
+// conversationMemberIDs возвращает ID всех участников диалога
+func conversationMemberIDs(conversationID int) ([]int, error) {
+	rows, err := DB.Query(`SELECT user_id FROM conversation_members WHERE conversation_id = $1`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// conversationPartner возвращает собеседника в личном диалоге или 0 для групповых чатов
+func conversationPartner(conversationID, userID int) (int, error) {
+	var partnerID int
+	err := DB.QueryRow(`
+		SELECT COALESCE(
+			(SELECT m.user_id FROM conversation_members m
+			 WHERE m.conversation_id = c.id AND m.user_id != $2 LIMIT 1), 0)
+		FROM conversations c
+		WHERE c.id = $1 AND NOT c.is_group
+	`, conversationID, userID).Scan(&partnerID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return partnerID, err
+}
+
+// ListConversations возвращает диалоги пользователя, отсортированные по последней активности
+func ListConversations(userID int) ([]ConversationSummary, error) {
+	rows, err := DB.Query(`
+		SELECT c.id, c.is_group,
+		       CASE WHEN c.is_group THEN c.title
+		            ELSE COALESCE((SELECT u.username FROM conversation_members om JOIN users u ON u.id = om.user_id
+		                           WHERE om.conversation_id = c.id AND om.user_id != $1 LIMIT 1), '')
+		       END,
+		       COALESCE(last.body, ''), c.last_message_at,
+		       (SELECT COUNT(*) FROM messages um
+		        WHERE um.conversation_id = c.id AND um.id > m.last_read_message_id
+		          AND um.sender_id IS DISTINCT FROM $1
+		          AND NOT EXISTS (SELECT 1 FROM message_deletions d WHERE d.message_id = um.id AND d.user_id = $1))
+		FROM conversation_members m
+		JOIN conversations c ON c.id = m.conversation_id
+		LEFT JOIN LATERAL (
+			SELECT lm.body FROM messages lm
+			WHERE lm.conversation_id = c.id
+			  AND NOT EXISTS (SELECT 1 FROM message_deletions d WHERE d.message_id = lm.id AND d.user_id = $1)
+			ORDER BY lm.id DESC
+			LIMIT 1
+		) last ON TRUE
+		WHERE m.user_id = $1
+		ORDER BY c.last_message_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []ConversationSummary
+	for rows.Next() {
+		var c ConversationSummary
+		if err := rows.Scan(&c.ID, &c.IsGroup, &c.Title, &c.LastMessage, &c.LastMessageAt, &c.UnreadCount); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
+
+// LoadMessages возвращает страницу сообщений диалога в хронологическом порядке.
+// beforeID > 0 загружает сообщения старше указанного. Второе значение сообщает,
+// есть ли ещё более старые сообщения. Запрос сам проверяет участие пользователя
+// в диалоге: участник видит только сообщения, отправленные после его вступления
+func LoadMessages(conversationID, userID int, beforeID int64) ([]Message, bool, error) {
+	if beforeID <= 0 {
+		beforeID = 1<<63 - 1
+	}
+
+	rows, err := DB.Query(`
+		SELECT m.id, m.conversation_id, COALESCE(m.sender_id, 0), COALESCE(u.username, ''), m.kind, m.body, m.created_at
+		FROM messages m
+		JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = $2
+		LEFT JOIN users u ON u.id = m.sender_id
+		WHERE m.conversation_id = $1 AND m.id < $3 AND m.created_at >= cm.joined_at
+		  AND NOT EXISTS (SELECT 1 FROM message_deletions d WHERE d.message_id = m.id AND d.user_id = $2)
+		ORDER BY m.id DESC
+		LIMIT $4
+	`, conversationID, userID, beforeID, messagesPageSize+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.SenderName, &m.Kind, &m.Body, &m.CreatedAt); err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > messagesPageSize
+	if hasMore {
+		messages = messages[:messagesPageSize]
+	}
+	// Разворачиваем, чтобы старые сообщения были сверху
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, hasMore, nil
+}
+
+// SendMessage отправляет сообщение в диалог от имени участника
+func SendMessage(conversationID, senderID int, body string) (Message, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return Message{}, ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(body) > maxMessageLength {
+		return Message{}, ErrMessageTooLong
+	}
+
+	member, err := IsConversationMember(conversationID, senderID)
+	if err != nil {
+		return Message{}, err
+	}
+	if !member {
+		return Message{}, ErrNotConversationMember
+	}
+
+	// В личном диалоге писать можно только пока пользователи остаются друзьями
+	partnerID, err := conversationPartner(conversationID, senderID)
+	if err != nil {
+		return Message{}, err
+	}
+	if partnerID > 0 {
+		friends, err := AreFriends(senderID, partnerID)
+		if err != nil {
+			return Message{}, err
+		}
+		if !friends {
+			return Message{}, ErrNotFriends
+		}
+	}
+
+	return insertMessage(conversationID, senderID, MessageKindText, body)
+}
+
+// insertMessage сохраняет сообщение и обновляет время активности диалога
+func insertMessage(conversationID, senderID int, kind, body string) (Message, error) {
+	message, err := storeMessage(DB, conversationID, senderID, kind, body)
+	if err != nil {
+		return message, err
+	}
+
+	// Отправитель уже прочитал своё сообщение
+	if senderID > 0 {
+		if err := MarkConversationRead(conversationID, senderID, message.ID); err != nil {
+			log.Println("Ошибка при обновлении прочитанных сообщений:", err)
+		}
+		message.SenderName = usernameByID(senderID)
+	}
+	publishMessage(message)
+	return message, nil
+}
+
+// queryRower выполняет запрос, возвращающий одну строку: *sql.DB или *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// storeMessage сохраняет сообщение и время последнего сообщения диалога без рассылки участникам
+func storeMessage(q queryRower, conversationID, senderID int, kind, body string) (Message, error) {
+	message := Message{ConversationID: conversationID, SenderID: senderID, Kind: kind, Body: body}
+	err := q.QueryRow(`
+		WITH inserted AS (
+			INSERT INTO messages (conversation_id, sender_id, kind, body)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at
+		), touched AS (
+			UPDATE conversations SET last_message_at = NOW() WHERE id = $1
+		)
+		SELECT id, created_at FROM inserted
+	`, conversationID, nullableID(senderID), kind, body).Scan(&message.ID, &message.CreatedAt)
+	return message, err
+}
+
+// MarkConversationRead отмечает сообщения диалога прочитанными вплоть до lastMessageID
+func MarkConversationRead(conversationID, userID int, lastMessageID int64) error {
+	_, err := DB.Exec(`
+		UPDATE conversation_members
+		SET last_read_message_id = GREATEST(last_read_message_id, $3)
+		WHERE conversation_id = $1 AND user_id = $2
+	`, conversationID, userID, lastMessageID)
+	return err
+}
+
+// DeleteMessageForUser скрывает сообщение только для указанного пользователя
+func DeleteMessageForUser(messageID int64, userID int) (int, error) {
+	var conversationID int
+	err := DB.QueryRow(`
+		SELECT m.conversation_id
+		FROM messages m
+		JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = $2
+		WHERE m.id = $1
+	`, messageID, userID).Scan(&conversationID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotConversationMember
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = DB.Exec(`
+		INSERT INTO message_deletions (message_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, messageID, userID)
+	return conversationID, err
+}
+
+// UnreadMessagesCount возвращает общее количество непрочитанных сообщений пользователя
+func UnreadMessagesCount(userID int) (int, error) {
+	var count int
+	err := DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM conversation_members cm
+		JOIN messages m ON m.conversation_id = cm.conversation_id AND m.id > cm.last_read_message_id
+		WHERE cm.user_id = $1 AND m.sender_id IS DISTINCT FROM $1
+		  AND NOT EXISTS (SELECT 1 FROM message_deletions d WHERE d.message_id = m.id AND d.user_id = $1)
+	`, userID).Scan(&count)
+	return count, err
+}
+
+// MessagesHandler показывает список диалогов пользователя
+func MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+
+	conversations, err := ListConversations(userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке диалогов:", err)
+		http.Error(w, "Ошибка при загрузке сообщений", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Header        HeaderData
+		Conversations []ConversationSummary
+	}{
+		Header:        header,
+		Conversations: conversations,
+	}
+
+	renderTemplate(w, "messages.html", data)
+}
+
+// NewDirectMessageHandler открывает личный диалог с другом
+func NewDirectMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	otherID, err := strconv.Atoi(r.URL.Query().Get("user"))
+	if err != nil || otherID <= 0 {
+		http.Error(w, "Некорректный ID пользователя", http.StatusBadRequest)
+		return
+	}
+
+	conversationID, err := GetOrCreateDirectConversation(userID, otherID)
+	if err == ErrNotFriends {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при создании диалога:", err)
+		http.Error(w, "Ошибка при открытии диалога", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/messages/conversation?id=%d", conversationID), http.StatusSeeOther)
+}
+
+// ConversationHandler показывает сообщения диалога с постраничной загрузкой
+func ConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || conversationID <= 0 {
+		http.Error(w, "Некорректный ID диалога", http.StatusBadRequest)
+		return
+	}
+
+	member, err := IsConversationMember(conversationID, userID)
+	if err != nil {
+		log.Println("Ошибка при проверке участника диалога:", err)
+		http.Error(w, "Ошибка при загрузке диалога", http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		http.Error(w, ErrNotConversationMember.Error(), http.StatusForbidden)
+		return
+	}
+
+	beforeID, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+	messages, hasMore, err := LoadMessages(conversationID, userID, beforeID)
+	if err != nil {
+		log.Println("Ошибка при загрузке сообщений:", err)
+		http.Error(w, "Ошибка при загрузке сообщений", http.StatusInternalServerError)
+		return
+	}
+
+	// Открытие последней страницы отмечает диалог прочитанным
+	if beforeID == 0 && len(messages) > 0 {
+		if err := MarkConversationRead(conversationID, userID, messages[len(messages)-1].ID); err != nil {
+			log.Println("Ошибка при обновлении прочитанных сообщений:", err)
+		}
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+
+	var title string
+	err = DB.QueryRow(`
+		SELECT CASE WHEN c.is_group THEN c.title
+		            ELSE COALESCE((SELECT u.username FROM conversation_members om JOIN users u ON u.id = om.user_id
+		                           WHERE om.conversation_id = c.id AND om.user_id != $2 LIMIT 1), '')
+		       END
+		FROM conversations c
+		WHERE c.id = $1
+	`, conversationID, userID).Scan(&title)
+	if err != nil {
+		log.Println("Ошибка при получении названия диалога:", err)
+	}
+
+	var oldestID int64
+	if len(messages) > 0 {
+		oldestID = messages[0].ID
+	}
+
+	group, err := LoadGroupInfo(conversationID, userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке участников чата:", err)
+		http.Error(w, "Ошибка при загрузке диалога", http.StatusInternalServerError)
+		return
+	}
+
+	// В личном диалоге показываем, в сети ли собеседник
+	var presence *Presence
+	if partnerID, err := conversationPartner(conversationID, userID); err != nil {
+		log.Println("Ошибка при получении собеседника:", err)
+	} else if partnerID > 0 {
+		if presence, err = GetPresence(partnerID); err != nil {
+			log.Println("Ошибка при получении статуса собеседника:", err)
+		}
+	}
+
+	data := struct {
+		Header         HeaderData
+		ConversationID int
+		Title          string
+		Messages       []Message
+		HasMore        bool
+		OldestID       int64
+		Group          *GroupInfo
+		Presence       *Presence
+		ErrorMsg       string
+	}{
+		Header:         header,
+		ConversationID: conversationID,
+		Title:          title,
+		Messages:       messages,
+		HasMore:        hasMore,
+		OldestID:       oldestID,
+		Group:          group,
+		Presence:       presence,
+		ErrorMsg:       r.URL.Query().Get("error"),
+	}
+
+	renderTemplate(w, "conversation.html", data)
+}
+
+// SendMessageHandler отправляет сообщение в диалог
+func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(r.FormValue("conversation_id"))
+	if err != nil || conversationID <= 0 {
+		http.Error(w, "Некорректный ID диалога", http.StatusBadRequest)
+		return
+	}
+
+	redirectURL := fmt.Sprintf("/messages/conversation?id=%d", conversationID)
+	_, err = SendMessage(conversationID, userID, r.FormValue("body"))
+	switch {
+	case err == ErrNotConversationMember:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err == ErrNotFriends, err == ErrEmptyMessage, err == ErrMessageTooLong:
+		redirectURL += "&error=" + url.QueryEscape(err.Error())
+	case err != nil:
+		log.Println("Ошибка при отправке сообщения:", err)
+		http.Error(w, "Ошибка при отправке сообщения", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+// DeleteMessageHandler удаляет сообщение у текущего пользователя
+func DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := strconv.ParseInt(r.FormValue("message_id"), 10, 64)
+	if err != nil || messageID <= 0 {
+		http.Error(w, "Некорректный ID сообщения", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := DeleteMessageForUser(messageID, userID); err == ErrNotConversationMember {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Println("Ошибка при удалении сообщения:", err)
+		http.Error(w, "Ошибка при удалении сообщения", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, "/messages")
+}
@@ -0,0 +1,654 @@
+// internal/moderation.go
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Роли пользователей
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Типы объектов, на которые можно пожаловаться
+const (
+	ReportTargetPost    = "post"
+	ReportTargetComment = "comment"
+	ReportTargetProfile = "profile"
+)
+
+// Статусы жалоб в очереди модерации
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusClaimed   = "claimed"
+	ReportStatusResolved  = "resolved"
+	ReportStatusDismissed = "dismissed"
+)
+
+// Действия модератора при решении жалобы
+const (
+	ModerationActionNone        = "none"
+	ModerationActionHide        = "hide"
+	ModerationActionSuspend     = "suspend"
+	ModerationActionHideSuspend = "hide_suspend"
+)
+
+var (
+	ErrReportTargetNotFound = errors.New("объект жалобы не найден")
+	ErrReportDuplicate      = errors.New("вы уже пожаловались на этот объект")
+	ErrReportInvalidReason  = errors.New("неизвестная причина жалобы")
+	ErrReportNotAvailable   = errors.New("жалоба уже обработана или взята в работу другим модератором")
+	ErrSuspendForbidden     = errors.New("нельзя заблокировать себя или пользователя с такой же или более высокой ролью")
+)
+
+// roleRanks задаёт старшинство ролей: модератор не может блокировать равных и старших
+var roleRanks = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// ReportReason описывает категорию жалобы
+type ReportReason struct {
+	Code  string
+	Label string
+}
+
+// reportReasons содержит доступные категории жалоб в порядке отображения
+var reportReasons = []ReportReason{
+	{"spam", "Спам"},
+	{"harassment", "Оскорбления или травля"},
+	{"hate", "Язык вражды"},
+	{"violence", "Насилие или угрозы"},
+	{"nudity", "Непристойный контент"},
+	{"impersonation", "Выдаёт себя за другого"},
+	{"other", "Другое"},
+}
+
+var reportStatusLabels = map[string]string{
+	ReportStatusOpen:      "Новая",
+	ReportStatusClaimed:   "В работе",
+	ReportStatusResolved:  "Решена",
+	ReportStatusDismissed: "Отклонена",
+}
+
+// Report представляет жалобу в очереди модерации
+type Report struct {
+	ID             int
+	ReporterName   string
+	TargetType     string
+	TargetID       int
+	TargetPreview  string
+	TargetAuthor   string
+	TargetAuthorID int
+	Reason         string
+	Details        string
+	Status         string
+	ModeratorID    int
+	ModeratorName  string
+	Resolution     string
+	CreatedAt      time.Time
+}
+
+// ReportTarget содержит данные для формы жалобы (шаблон report-form)
+type ReportTarget struct {
+	Type    string
+	ID      int
+	Reasons []ReportReason
+}
+
+func newReportTarget(targetType string, targetID int) ReportTarget {
+	return ReportTarget{Type: targetType, ID: targetID, Reasons: reportReasons}
+}
+
+// systemReportReasons содержит причины жалоб, создаваемых автоматически.
+// Они не показываются пользователям в форме жалобы
+var systemReportReasons = []ReportReason{
+	{"policy", "Сработал фильтр контента"},
+	{"velocity", "Подозрительная активность"},
+	{"spam_heuristics", "Признаки спама"},
+}
+
+// validReportReason проверяет причину жалобы. Пользователи выбирают только
+// из причин формы, системные причины доступны лишь автоматическим жалобам
+func validReportReason(code string, system bool) bool {
+	reasons := [][]ReportReason{reportReasons}
+	if system {
+		reasons = append(reasons, systemReportReasons)
+	}
+	for _, list := range reasons {
+		for _, reason := range list {
+			if reason.Code == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func reportReasonLabel(code string) string {
+	for _, reasons := range [][]ReportReason{reportReasons, systemReportReasons} {
+		for _, reason := range reasons {
+			if reason.Code == code {
+				return reason.Label
+			}
+		}
+	}
+	return code
+}
+
+func reportStatusLabel(status string) string {
+	if label, ok := reportStatusLabels[status]; ok {
+		return label
+	}
+	return status
+}
+
+// nullableID превращает нулевой ID в NULL для запросов к БД
+func nullableID(id int) interface{} {
+	if id <= 0 {
+		return nil
+	}
+	return id
+}
+
+// GetUserRole возвращает роль пользователя
+func GetUserRole(userID int) (string, error) {
+	var role string
+	err := DB.QueryRow(`SELECT role FROM users WHERE id = $1`, userID).Scan(&role)
+	return role, err
+}
+
+// IsModerator проверяет, может ли пользователь работать с очередью модерации
+func IsModerator(userID int) bool {
+	role, err := GetUserRole(userID)
+	if err != nil {
+		log.Println("Ошибка при получении роли пользователя:", err)
+		return false
+	}
+	return role == RoleModerator || role == RoleAdmin
+}
+
+// GetSuspension возвращает дату окончания блокировки, если пользователь заблокирован
+func GetSuspension(userID int) (time.Time, bool, error) {
+	var until sql.NullTime
+	err := DB.QueryRow(`
+		SELECT suspended_until
+		FROM users
+		WHERE id = $1 AND suspended_until > NOW()
+	`, userID).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return until.Time, true, nil
+}
+
+// reportTargetAuthor возвращает ID автора объекта жалобы
+func reportTargetAuthor(targetType string, targetID int) (int, error) {
+	var query string
+	switch targetType {
+	case ReportTargetPost:
+		query = `SELECT user_id FROM posts WHERE id = $1`
+	case ReportTargetComment:
+		query = `SELECT user_id FROM comments WHERE id = $1`
+	case ReportTargetProfile:
+		query = `SELECT id FROM users WHERE id = $1`
+	default:
+		return 0, ErrReportTargetNotFound
+	}
+
+	var authorID int
+	err := DB.QueryRow(query, targetID).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		return 0, ErrReportTargetNotFound
+	}
+	return authorID, err
+}
+
+// CreateReport сохраняет жалобу пользователя. reporterID = 0 означает системную жалобу
+func CreateReport(reporterID int, targetType string, targetID int, reason, details string) error {
+	if !validReportReason(reason, reporterID <= 0) {
+		return ErrReportInvalidReason
+	}
+	if _, err := reportTargetAuthor(targetType, targetID); err != nil {
+		return err
+	}
+
+	if reporterID > 0 {
+		// Не даём одному пользователю отправлять повторные жалобы на один объект
+		var count int
+		err := DB.QueryRow(`
+			SELECT COUNT(*)
+			FROM reports
+			WHERE reporter_id = $1 AND target_type = $2 AND target_id = $3 AND status IN ('open', 'claimed')
+		`, reporterID, targetType, targetID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrReportDuplicate
+		}
+	}
+
+	_, err := DB.Exec(`
+		INSERT INTO reports (reporter_id, target_type, target_id, reason, details)
+		VALUES ($1, $2, $3, $4, $5)
+	`, nullableID(reporterID), targetType, targetID, reason, details)
+	return err
+}
+
+// ListReports возвращает жалобы с указанным статусом вместе с превью объекта
+func ListReports(status string) ([]Report, error) {
+	rows, err := DB.Query(`
+		SELECT r.id, COALESCE(ru.username, ''), r.target_type, r.target_id, r.reason, r.details,
+		       r.status, COALESCE(r.moderator_id, 0), COALESCE(mu.username, ''), r.resolution, r.created_at,
+		       COALESCE(p.content, c.content, tu.username, ''),
+		       COALESCE(au.id, 0), COALESCE(au.username, '')
+		FROM reports r
+		LEFT JOIN users ru ON ru.id = r.reporter_id
+		LEFT JOIN users mu ON mu.id = r.moderator_id
+		LEFT JOIN posts p ON r.target_type = 'post' AND p.id = r.target_id
+		LEFT JOIN comments c ON r.target_type = 'comment' AND c.id = r.target_id
+		LEFT JOIN users tu ON r.target_type = 'profile' AND tu.id = r.target_id
+		LEFT JOIN users au ON au.id = COALESCE(p.user_id, c.user_id, tu.id)
+		WHERE r.status = $1
+		ORDER BY r.created_at
+		LIMIT 100
+	`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []Report
+	for rows.Next() {
+		var report Report
+		if err := rows.Scan(&report.ID, &report.ReporterName, &report.TargetType, &report.TargetID,
+			&report.Reason, &report.Details, &report.Status, &report.ModeratorID, &report.ModeratorName,
+			&report.Resolution, &report.CreatedAt, &report.TargetPreview,
+			&report.TargetAuthorID, &report.TargetAuthor); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// ClaimReport берёт жалобу в работу
+func ClaimReport(reportID, moderatorID int) error {
+	res, err := DB.Exec(`
+		UPDATE reports
+		SET status = 'claimed', moderator_id = $2, updated_at = NOW()
+		WHERE id = $1 AND status = 'open'
+	`, reportID, moderatorID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrReportNotAvailable
+	}
+	return nil
+}
+
+// closeReport переводит жалобу в финальный статус. Второе значение — ID автора жалобы,
+// которого нужно уведомить после фиксации транзакции
+func closeReport(tx *sql.Tx, reportID, moderatorID int, status, resolution string) (Report, int, error) {
+	var report Report
+	var reporterID sql.NullInt64
+	err := tx.QueryRow(`
+		UPDATE reports
+		SET status = $3, moderator_id = $2, resolution = $4, updated_at = NOW()
+		WHERE id = $1 AND status IN ('open', 'claimed') AND (moderator_id IS NULL OR moderator_id = $2)
+		RETURNING target_type, target_id, reporter_id
+	`, reportID, moderatorID, status, resolution).Scan(&report.TargetType, &report.TargetID, &reporterID)
+	if err == sql.ErrNoRows {
+		return report, 0, ErrReportNotAvailable
+	}
+	if err != nil {
+		return report, 0, err
+	}
+	report.ID = reportID
+	report.Status = status
+	return report, int(reporterID.Int64), nil
+}
+
+// notifyReporter сообщает автору жалобы о её рассмотрении
+func notifyReporter(reporterID, reportID int, status string) {
+	if reporterID <= 0 {
+		return
+	}
+	message := "Ваша жалоба рассмотрена, меры приняты. Спасибо!"
+	if status == ReportStatusDismissed {
+		message = "Ваша жалоба рассмотрена, нарушений не обнаружено."
+	}
+	if err := Notify(reporterID, NotificationReportResolved, 0, "report", reportID, message); err != nil {
+		log.Println("Ошибка при отправке уведомления о жалобе:", err)
+	}
+}
+
+// ResolveReport закрывает жалобу и применяет выбранное действие к объекту и его автору.
+// Жалоба и действие сохраняются в одной транзакции: если действие не удалось,
+// жалоба остаётся нерешённой
+func ResolveReport(reportID, moderatorID int, action string, suspendDays int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	report, reporterID, err := closeReport(tx, reportID, moderatorID, ReportStatusResolved, action)
+	if err != nil {
+		return err
+	}
+
+	releasedAuthorID := 0
+	if action == ModerationActionHide || action == ModerationActionHideSuspend {
+		if err := hideContent(tx, report.TargetType, report.TargetID); err != nil {
+			return err
+		}
+	} else if releasedAuthorID, err = releaseHeldContent(tx, report.TargetType, report.TargetID); err != nil {
+		return err
+	}
+	if action == ModerationActionSuspend || action == ModerationActionHideSuspend {
+		authorID, err := reportTargetAuthor(report.TargetType, report.TargetID)
+		if err != nil {
+			return err
+		}
+		if err := SuspendUser(tx, moderatorID, authorID, suspendDays); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	notifyReporter(reporterID, reportID, ReportStatusResolved)
+	return publishReleasedContent(report.TargetType, report.TargetID, releasedAuthorID)
+}
+
+// DismissReport отклоняет жалобу без применения мер
+func DismissReport(reportID, moderatorID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	report, reporterID, err := closeReport(tx, reportID, moderatorID, ReportStatusDismissed, ModerationActionNone)
+	if err != nil {
+		return err
+	}
+	releasedAuthorID, err := releaseHeldContent(tx, report.TargetType, report.TargetID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	notifyReporter(reporterID, reportID, ReportStatusDismissed)
+	return publishReleasedContent(report.TargetType, report.TargetID, releasedAuthorID)
+}
+
+// releaseHeldContent снимает задержку с контента, если по нему не осталось
+// нерассмотренных жалоб, и возвращает ID автора. 0 означает, что контент не был задержан
+func releaseHeldContent(tx *sql.Tx, targetType string, targetID int) (int, error) {
+	var table string
+	switch targetType {
+	case ReportTargetPost:
+		table = "posts"
+	case ReportTargetComment:
+		table = "comments"
+	default:
+		return 0, nil
+	}
+
+	var authorID int
+	err := tx.QueryRow(`
+		UPDATE `+table+`
+		SET held_at = NULL
+		WHERE id = $1 AND held_at IS NOT NULL AND NOT EXISTS (
+			SELECT 1 FROM reports
+			WHERE target_type = $2 AND target_id = $1 AND status IN ('open', 'claimed')
+		)
+		RETURNING user_id
+	`, targetID, targetType).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return authorID, err
+}
+
+// publishReleasedContent уведомляет упомянутых пользователей и раскладывает
+// пост по лентам после того, как с него снята задержка
+func publishReleasedContent(targetType string, targetID, authorID int) error {
+	if authorID == 0 {
+		return nil
+	}
+	if err := notifyMentions(targetType, targetID, nil); err != nil {
+		log.Println("Ошибка при уведомлении об упоминаниях:", err)
+	}
+
+	// Опубликованный пост попадает в ленты читателей
+	if targetType == ReportTargetPost {
+		return fanOutPost(targetID, authorID)
+	}
+	return nil
+}
+
+// hideContent скрывает пост или комментарий из всех лент
+func hideContent(tx *sql.Tx, targetType string, targetID int) error {
+	var err error
+	switch targetType {
+	case ReportTargetPost:
+		_, err = tx.Exec(`UPDATE posts SET hidden_at = NOW() WHERE id = $1`, targetID)
+	case ReportTargetComment:
+		_, err = tx.Exec(`UPDATE comments SET hidden_at = NOW() WHERE id = $1`, targetID)
+	}
+	return err
+}
+
+// maxSuspendDays — самый долгий срок блокировки. Больший срок сокращается до него
+const maxSuspendDays = 3650
+
+// SuspendUser блокирует пользователя на указанное количество дней. Модератор не может
+// заблокировать себя и пользователя с такой же или более высокой ролью
+func SuspendUser(tx *sql.Tx, moderatorID, userID, days int) error {
+	if userID == moderatorID {
+		return ErrSuspendForbidden
+	}
+	var moderatorRole, userRole string
+	err := tx.QueryRow(`
+		SELECT m.role, u.role FROM users m, users u WHERE m.id = $1 AND u.id = $2
+	`, moderatorID, userID).Scan(&moderatorRole, &userRole)
+	if err != nil {
+		return err
+	}
+	if roleRanks[userRole] >= roleRanks[moderatorRole] {
+		return ErrSuspendForbidden
+	}
+
+	if days <= 0 {
+		days = 7
+	}
+	if days > maxSuspendDays {
+		days = maxSuspendDays
+	}
+	_, err = tx.Exec(`
+		UPDATE users
+		SET suspended_until = NOW() + make_interval(days => $2)
+		WHERE id = $1
+	`, userID, days)
+	return err
+}
+
+// ReportHandler принимает жалобу на пост, комментарий или профиль
+func ReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	targetID, err := strconv.Atoi(r.FormValue("target_id"))
+	if err != nil || targetID <= 0 {
+		http.Error(w, "Некорректный объект жалобы", http.StatusBadRequest)
+		return
+	}
+
+	targetType := r.FormValue("target_type")
+	err = CreateReport(userID, targetType, targetID, r.FormValue("reason"), r.FormValue("details"))
+	switch {
+	case errors.Is(err, ErrReportTargetNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrReportDuplicate), errors.Is(err, ErrReportInvalidReason):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Println("Ошибка при сохранении жалобы:", err)
+		http.Error(w, "Ошибка при отправке жалобы", http.StatusInternalServerError)
+		return
+	}
+	Audit(r, userID, AuditReportCreate, targetType, targetID, "reason: "+r.FormValue("reason"))
+
+	redirectBack(w, r, "/posts")
+}
+
+// ModerationHandler показывает очередь модерации
+func ModerationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+	if !header.IsModerator {
+		http.Error(w, "Доступ запрещён", http.StatusForbidden)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if _, ok := reportStatusLabels[status]; !ok {
+		status = ReportStatusOpen
+	}
+
+	reports, err := ListReports(status)
+	if err != nil {
+		log.Println("Ошибка при загрузке жалоб:", err)
+		http.Error(w, "Ошибка при загрузке очереди модерации", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Header   HeaderData
+		Status   string
+		Statuses []string
+		Reports  []Report
+	}{
+		Header:   header,
+		Status:   status,
+		Statuses: []string{ReportStatusOpen, ReportStatusClaimed, ReportStatusResolved, ReportStatusDismissed},
+		Reports:  reports,
+	}
+
+	renderTemplate(w, "moderation.html", data)
+}
+
+// ModerationActionHandler обрабатывает действия модератора: взять, решить, отклонить
+func ModerationActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+	if !IsModerator(userID) {
+		http.Error(w, "Доступ запрещён", http.StatusForbidden)
+		return
+	}
+
+	reportID, err := strconv.Atoi(r.FormValue("report_id"))
+	if err != nil || reportID <= 0 {
+		http.Error(w, "Некорректный ID жалобы", http.StatusBadRequest)
+		return
+	}
+
+	var auditAction, auditDetails string
+	switch r.FormValue("action") {
+	case "claim":
+		auditAction = AuditReportClaim
+		err = ClaimReport(reportID, userID)
+	case "resolve":
+		action := r.FormValue("moderation_action")
+		switch action {
+		case ModerationActionNone, ModerationActionHide, ModerationActionSuspend, ModerationActionHideSuspend:
+		default:
+			http.Error(w, "Неизвестное действие модератора", http.StatusBadRequest)
+			return
+		}
+		var suspendDays int
+		auditAction = AuditReportResolve
+		auditDetails = "action: " + action
+		if action == ModerationActionSuspend || action == ModerationActionHideSuspend {
+			if value := r.FormValue("suspend_days"); value != "" {
+				suspendDays, err = strconv.Atoi(value)
+				if err != nil || suspendDays < 1 || suspendDays > maxSuspendDays {
+					http.Error(w, fmt.Sprintf("Срок блокировки должен быть от 1 до %d дней", maxSuspendDays), http.StatusBadRequest)
+					return
+				}
+			}
+			auditDetails += fmt.Sprintf(", suspend_days: %d", suspendDays)
+		}
+		err = ResolveReport(reportID, userID, action, suspendDays)
+	case "dismiss":
+		auditAction = AuditReportDismiss
+		err = DismissReport(reportID, userID)
+	default:
+		http.Error(w, "Неизвестное действие", http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(err, ErrReportNotAvailable) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, ErrSuspendForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при обработке жалобы:", err)
+		http.Error(w, "Ошибка при обработке жалобы", http.StatusInternalServerError)
+		return
+	}
+	Audit(r, userID, auditAction, "report", reportID, auditDetails)
+
+	redirectBack(w, r, "/moderation")
+}
@@ -0,0 +1,333 @@
+// internal/notifications.go
+package internal
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Типы уведомлений
+const (
+	NotificationReportResolved = "report_resolved"
+	NotificationContentHeld    = "content_held"
+	NotificationFriendAdded    = "friend_added"
+	NotificationComment        = "comment"
+	NotificationReaction       = "reaction"
+	NotificationFollow         = "follow"
+	NotificationMention        = "mention"
+)
+
+const (
+	// notificationsPageSize — сколько уведомлений (после группировки) показывается на странице
+	notificationsPageSize = 50
+	// notificationReadRetention — сколько хранятся прочитанные уведомления
+	notificationReadRetention = 30 * 24 * time.Hour
+	// notificationRetention — сколько хранятся любые уведомления
+	notificationRetention = 90 * 24 * time.Hour
+)
+
+// notificationFormats задаёт текст уведомлений от пользователей. Вместо %s
+// подставляется список участников, например «Анна и ещё 3»
+var notificationFormats = map[string]string{
+	NotificationFriendAdded: "%s добавляет вас в друзья",
+	NotificationComment:     "Комментарии к вашему посту: %s",
+	NotificationReaction:    "Реакции на ваш пост: %s",
+	NotificationFollow:      "%s подписывается на вас",
+	NotificationMention:     "%s упоминает вас",
+}
+
+// groupedNotifications — типы уведомлений, которые объединяются по объекту
+var groupedNotifications = map[string]bool{
+	NotificationComment:  true,
+	NotificationReaction: true,
+}
+
+// Notification представляет уведомление пользователя. Однотипные уведомления
+// об одном объекте объединяются в одно с несколькими участниками
+type Notification struct {
+	ID            int
+	Kind          string
+	Actors        []string
+	ActorCount    int
+	Message       string
+	ObjectType    string
+	ObjectID      int
+	ObjectOwnerID int
+	IsRead        bool
+	CreatedAt     time.Time
+}
+
+// Text возвращает текст уведомления с учётом группировки
+func (n Notification) Text() string {
+	format, ok := notificationFormats[n.Kind]
+	if !ok || len(n.Actors) == 0 {
+		return n.Message
+	}
+
+	var actors string
+	switch {
+	case len(n.Actors) == 1:
+		actors = n.Actors[0]
+	case n.ActorCount == 2:
+		actors = n.Actors[0] + " и " + n.Actors[1]
+	default:
+		actors = fmt.Sprintf("%s и ещё %d", n.Actors[0], n.ActorCount-1)
+	}
+	return fmt.Sprintf(format, actors)
+}
+
+// Link возвращает адрес объекта уведомления или пустую строку
+func (n Notification) Link() string {
+	switch {
+	case n.ObjectType == "post" && n.ObjectOwnerID > 0:
+		return fmt.Sprintf("/profile?id=%d#post-%d", n.ObjectOwnerID, n.ObjectID)
+	case n.ObjectType == "user":
+		return fmt.Sprintf("/profile?id=%d", n.ObjectID)
+	}
+	return ""
+}
+
+// notificationGroupKey возвращает ключ, по которому объединяются уведомления
+func notificationGroupKey(kind, objectType string, objectID int) string {
+	if !groupedNotifications[kind] {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s:%d", kind, objectType, objectID)
+}
+
+// Notify сохраняет уведомление для пользователя. actorID = 0 означает системное уведомление
+func Notify(userID int, kind string, actorID int, objectType string, objectID int, message string) error {
+	// О своих действиях пользователя не уведомляем
+	if actorID > 0 && actorID == userID {
+		return nil
+	}
+
+	var id int
+	err := DB.QueryRow(`
+		INSERT INTO notifications (user_id, kind, actor_id, object_type, object_id, message, group_key)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+		RETURNING id
+	`, userID, kind, nullableID(actorID), objectType, objectID, message, notificationGroupKey(kind, objectType, objectID)).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	notification := Notification{ID: id, Kind: kind, Message: message, ActorCount: 1, ObjectType: objectType, ObjectID: objectID}
+	if actorID > 0 {
+		notification.Actors = []string{usernameByID(actorID)}
+	}
+	if objectType == "post" {
+		DB.QueryRow(`SELECT user_id FROM posts WHERE id = $1`, objectID).Scan(&notification.ObjectOwnerID)
+	}
+	unread, err := UnreadNotificationsCount(userID)
+	if err != nil {
+		log.Println("Ошибка при подсчёте непрочитанных уведомлений:", err)
+	}
+	event := map[string]interface{}{"id": id, "kind": kind, "message": notification.Text(), "unread": unread}
+	if err := PublishToUser(userID, RealtimeNotification, event); err != nil {
+		log.Println("Ошибка при отправке уведомления в реальном времени:", err)
+	}
+	if err := queueNotificationEmail(userID, notification); err != nil {
+		log.Println("Ошибка при подготовке письма об уведомлении:", err)
+	}
+	push := pushMessage{Title: "Социальная сеть", Body: notification.Text(), URL: notification.Link()}
+	if push.URL == "" {
+		push.URL = "/notifications"
+	}
+	if key := notificationGroupKey(kind, objectType, objectID); key != "" {
+		push.Tag = key
+	}
+	if err := queuePush(userID, push); err != nil {
+		log.Println("Ошибка при подготовке push-уведомления:", err)
+	}
+	return nil
+}
+
+// retractNotification удаляет ещё не прочитанное уведомление, если его причина
+// исчезла, например пользователь убрал реакцию
+func retractNotification(userID int, kind string, actorID int, objectType string, objectID int) error {
+	_, err := DB.Exec(`
+		DELETE FROM notifications
+		WHERE user_id = $1 AND kind = $2 AND actor_id = $3 AND object_type = $4 AND object_id = $5 AND read_at IS NULL
+	`, userID, kind, actorID, objectType, objectID)
+	return err
+}
+
+// UnreadNotificationsCount возвращает число непрочитанных уведомлений с учётом группировки
+func UnreadNotificationsCount(userID int) (int, error) {
+	var count int
+	err := DB.QueryRow(`
+		SELECT COUNT(DISTINCT COALESCE(group_key, id::text))
+		FROM notifications
+		WHERE user_id = $1 AND read_at IS NULL
+	`, userID).Scan(&count)
+	return count, err
+}
+
+// ListNotifications возвращает последние уведомления пользователя.
+// Однотипные уведомления об одном объекте объединяются, прочитанные
+// и непрочитанные группируются отдельно
+func ListNotifications(userID int) ([]Notification, error) {
+	rows, err := DB.Query(`
+		SELECT n.id, n.kind, n.actor_id IS NOT NULL, COALESCE(u.username, ''), n.message,
+		       n.object_type, n.object_id, COALESCE(p.user_id, 0), COALESCE(n.group_key, ''),
+		       n.read_at IS NOT NULL, n.created_at
+		FROM notifications n
+		LEFT JOIN users u ON u.id = n.actor_id
+		LEFT JOIN posts p ON n.object_type = 'post' AND p.id = n.object_id
+		WHERE n.user_id = $1
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT $2
+	`, userID, notificationsPageSize*4)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	groups := make(map[string]int)
+	seenActors := make(map[string]map[string]bool)
+	for rows.Next() {
+		var n Notification
+		var hasActor bool
+		var actorName, groupKey string
+		err := rows.Scan(&n.ID, &n.Kind, &hasActor, &actorName, &n.Message,
+			&n.ObjectType, &n.ObjectID, &n.ObjectOwnerID, &groupKey, &n.IsRead, &n.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if groupKey != "" {
+			groupKey += ":" + strconv.FormatBool(n.IsRead)
+			if i, ok := groups[groupKey]; ok {
+				if hasActor && !seenActors[groupKey][actorName] {
+					seenActors[groupKey][actorName] = true
+					notifications[i].Actors = append(notifications[i].Actors, actorName)
+					notifications[i].ActorCount++
+				}
+				continue
+			}
+			if len(notifications) >= notificationsPageSize {
+				continue
+			}
+			groups[groupKey] = len(notifications)
+			seenActors[groupKey] = map[string]bool{actorName: true}
+		} else if len(notifications) >= notificationsPageSize {
+			continue
+		}
+
+		if hasActor {
+			n.Actors = []string{actorName}
+			n.ActorCount = 1
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkNotificationRead отмечает прочитанным уведомление вместе со всей его группой
+func MarkNotificationRead(userID, notificationID int) error {
+	_, err := DB.Exec(`
+		UPDATE notifications
+		SET read_at = NOW()
+		WHERE user_id = $1 AND read_at IS NULL AND (
+			id = $2 OR group_key = (SELECT group_key FROM notifications WHERE id = $2 AND user_id = $1)
+		)
+	`, userID, notificationID)
+	return err
+}
+
+// MarkAllNotificationsRead отмечает прочитанными все уведомления пользователя
+func MarkAllNotificationsRead(userID int) error {
+	_, err := DB.Exec(`UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL`, userID)
+	return err
+}
+
+// cleanupNotifications удаляет старые уведомления
+func cleanupNotifications() error {
+	_, err := DB.Exec(`
+		DELETE FROM notifications
+		WHERE (read_at IS NOT NULL AND created_at < NOW() - $1 * INTERVAL '1 second')
+		   OR created_at < NOW() - $2 * INTERVAL '1 second'
+	`, notificationReadRetention.Seconds(), notificationRetention.Seconds())
+	return err
+}
+
+// NotificationsHandler показывает уведомления текущего пользователя
+func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+
+	notifications, err := ListNotifications(userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке уведомлений:", err)
+		http.Error(w, "Ошибка при загрузке уведомлений", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Header        HeaderData
+		Notifications []Notification
+	}{
+		Header:        header,
+		Notifications: notifications,
+	}
+
+	renderTemplate(w, "notifications.html", data)
+}
+
+// NotificationReadHandler отмечает прочитанным одно уведомление или все сразу
+func NotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	if r.FormValue("all") != "" {
+		err = MarkAllNotificationsRead(userID)
+	} else {
+		notificationID, convErr := strconv.Atoi(r.FormValue("id"))
+		if convErr != nil || notificationID <= 0 {
+			http.Error(w, "Некорректный ID уведомления", http.StatusBadRequest)
+			return
+		}
+		err = MarkNotificationRead(userID, notificationID)
+	}
+	if err != nil {
+		log.Println("Ошибка при отметке уведомлений:", err)
+		http.Error(w, "Ошибка при обновлении уведомлений", http.StatusInternalServerError)
+		return
+	}
+
+	// Обновляем счётчик во всех открытых вкладках пользователя
+	if unread, err := UnreadNotificationsCount(userID); err == nil {
+		publishEphemeral(userID, RealtimeUnread, map[string]int{"notifications": unread})
+	}
+
+	// Переход по ссылке из уведомления отмечает его прочитанным
+	next := r.FormValue("next")
+	if isLocalPath(next) {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+	redirectBack(w, r, "/notifications")
+}
@@ -0,0 +1,244 @@
+// internal/postedit.go
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var ErrPostEditForbidden = errors.New("редактировать пост может только его автор")
+
+// editPostData — данные формы редактирования поста
+type editPostData struct {
+	ErrorMsg    string
+	ID          int
+	Text        string
+	Attachments []Attachment
+	Removed     map[int]bool
+}
+
+// AttachmentChanges — изменения вложений при редактировании поста
+type AttachmentChanges struct {
+	AltTexts map[int]string // новые описания по ID вложения
+	Removed  map[int]bool   // ID удаляемых вложений
+}
+
+// formAttachmentChanges читает из формы описания вложений (поля alt_<ID>)
+// и отмеченные для удаления вложения (поля remove)
+func formAttachmentChanges(r *http.Request) AttachmentChanges {
+	changes := AttachmentChanges{AltTexts: make(map[int]string), Removed: make(map[int]bool)}
+	for key, values := range r.PostForm {
+		if !strings.HasPrefix(key, "alt_") || len(values) == 0 {
+			continue
+		}
+		if id, err := strconv.Atoi(strings.TrimPrefix(key, "alt_")); err == nil {
+			changes.AltTexts[id] = values[0]
+		}
+	}
+	for _, value := range r.PostForm["remove"] {
+		if id, err := strconv.Atoi(value); err == nil {
+			changes.Removed[id] = true
+		}
+	}
+	return changes
+}
+
+// loadEditablePost возвращает исходный текст поста, если его может редактировать пользователь.
+// Скрытые модератором посты не редактируются
+func loadEditablePost(postID, userID int) (string, error) {
+	var authorID int
+	var content string
+	var hidden bool
+	err := DB.QueryRow(`
+		SELECT user_id, content, hidden_at IS NOT NULL FROM posts WHERE id = $1
+	`, postID).Scan(&authorID, &content, &hidden)
+	if err == sql.ErrNoRows || (err == nil && hidden) {
+		return "", ErrPostNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if authorID != userID {
+		return "", ErrPostEditForbidden
+	}
+	return content, nil
+}
+
+// EditPost заменяет текст поста, пересобирает его теги, упоминания и превью ссылки,
+// обновляет описания вложений и удаляет отмеченные вложения. Новый текст
+// проверяется фильтром контента и эвристиками защиты от спама так же, как при публикации.
+// Если проверка задерживает текст, пост снимается с публикации до проверки модератором
+func EditPost(postID, userID int, content string, changes AttachmentChanges) (PolicyDecision, error) {
+	if _, err := loadEditablePost(postID, userID); err != nil {
+		return PolicyDecision{}, err
+	}
+
+	var wasHeld bool
+	err := DB.QueryRow(`SELECT held_at IS NOT NULL FROM posts WHERE id = $1`, postID).Scan(&wasHeld)
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	attachments, err := ListPostAttachments(postID)
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	var removed []Attachment
+	for _, attachment := range attachments {
+		if changes.Removed[attachment.ID] {
+			removed = append(removed, attachment)
+		}
+	}
+
+	decision := CheckContent(content, len(attachments) > len(removed))
+	if decision.Action != PolicyReject {
+		spamDecision, err := CheckEditedPostActivity(userID, content)
+		if err != nil {
+			return PolicyDecision{}, err
+		}
+		decision = stricterDecision(decision, spamDecision)
+	}
+	if decision.Action == PolicyReject {
+		return decision, nil
+	}
+	held := decision.Action == PolicyHold
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return decision, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE posts
+		SET content = $2, content_hash = NULLIF($3, ''), edited_at = NOW(),
+		    held_at = CASE WHEN $4 THEN COALESCE(held_at, NOW()) ELSE held_at END
+		WHERE id = $1
+	`, postID, decision.Content, contentHash(content), held)
+	if err != nil {
+		return decision, err
+	}
+	if _, err := tx.Exec(`DELETE FROM post_tags WHERE post_id = $1`, postID); err != nil {
+		return decision, err
+	}
+	if err := saveHashtags(tx, postID, decision.Content); err != nil {
+		return decision, err
+	}
+	added, err := savePostMentions(tx, postID, decision.Content)
+	if err != nil {
+		return decision, err
+	}
+	if err := setPostLink(tx, postID, decision.Content); err != nil {
+		return decision, err
+	}
+	for _, attachment := range attachments {
+		altText, altChanged := changes.AltTexts[attachment.ID]
+		switch {
+		case changes.Removed[attachment.ID]:
+			_, err = tx.Exec(`DELETE FROM post_attachments WHERE id = $1`, attachment.ID)
+		case altChanged && normalizeAltText(altText) != attachment.AltText:
+			_, err = tx.Exec(`UPDATE post_attachments SET alt_text = $2 WHERE id = $1`, attachment.ID, normalizeAltText(altText))
+		}
+		if err != nil {
+			return decision, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return decision, err
+	}
+	removeAttachmentFiles(removed)
+
+	switch {
+	case held && !wasHeld:
+		holdForReview(ReportTargetPost, postID, userID, decision)
+	case !held && !wasHeld:
+		// Уведомляем только пользователей, упомянутых при редактировании впервые
+		if err := notifyMentions(ReportTargetPost, postID, added); err != nil {
+			log.Println("Ошибка при уведомлении об упоминаниях:", err)
+		}
+	}
+	return decision, nil
+}
+
+// EditPostHandler показывает форму редактирования поста и сохраняет изменения
+func EditPostHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		if r.Method == http.MethodPost {
+			http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		} else {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		}
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil || postID <= 0 {
+		http.Error(w, "Некорректный ID поста", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		content := r.FormValue("content")
+		changes := formAttachmentChanges(r)
+		decision, err := EditPost(postID, userID, content, changes)
+		switch {
+		case err == ErrPostNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err == ErrPostEditForbidden:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			log.Println("Ошибка при редактировании поста:", err)
+			http.Error(w, "Ошибка при сохранении поста", http.StatusInternalServerError)
+			return
+		}
+		if decision.Action == PolicyReject {
+			// Показываем форму с введёнными описаниями и отметками, чтобы их не пришлось вводить заново
+			attachments, err := ListPostAttachments(postID)
+			if err != nil {
+				log.Println("Ошибка при загрузке вложений:", err)
+			}
+			for i, attachment := range attachments {
+				if altText, ok := changes.AltTexts[attachment.ID]; ok {
+					attachments[i].AltText = altText
+				}
+			}
+			renderTemplate(w, "edit-post.html", editPostData{
+				ErrorMsg:    decision.Reason,
+				ID:          postID,
+				Text:        content,
+				Attachments: attachments,
+				Removed:     changes.Removed,
+			})
+			return
+		}
+		http.Redirect(w, r, "/profile#post-"+strconv.Itoa(postID), http.StatusSeeOther)
+		return
+	}
+
+	content, err := loadEditablePost(postID, userID)
+	switch {
+	case err == ErrPostNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == ErrPostEditForbidden:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		log.Println("Ошибка при загрузке поста:", err)
+		http.Error(w, "Ошибка при загрузке поста", http.StatusInternalServerError)
+		return
+	}
+	attachments, err := ListPostAttachments(postID)
+	if err != nil {
+		log.Println("Ошибка при загрузке вложений:", err)
+		http.Error(w, "Ошибка при загрузке поста", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, "edit-post.html", editPostData{ID: postID, Text: content, Attachments: attachments})
+}
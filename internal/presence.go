@@ -0,0 +1,134 @@
+// internal/presence.go
+package internal
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// Состояния присутствия
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+const (
+	// presenceActiveWindow — сколько пользователь считается в сети после последнего действия
+	presenceActiveWindow = 5 * time.Minute
+	// presenceConnectionWindow — сколько открытая вкладка считается подключённой после
+	// последнего сигнала. Должно быть больше realtimePingInterval
+	presenceConnectionWindow = 90 * time.Second
+	// presenceActivityThrottle и presenceHeartbeatThrottle ограничивают частоту записи в БД
+	presenceActivityThrottle  = time.Minute
+	presenceHeartbeatThrottle = 30 * time.Second
+)
+
+// Presence — видимый другим статус пользователя
+type Presence struct {
+	State    string
+	LastSeen time.Time
+}
+
+// Label возвращает статус для отображения
+func (p Presence) Label() string {
+	switch {
+	case p.State == PresenceOnline:
+		return "В сети"
+	case p.State == PresenceAway:
+		return "Нет на месте"
+	case !p.LastSeen.IsZero():
+		return "Последний визит: " + p.LastSeen.Format("02.01.2006 15:04")
+	}
+	return "Не в сети"
+}
+
+// newPresence вычисляет статус по времени последнего действия и последнего сигнала
+// открытого подключения. Возвращает nil, если пользователь скрыл свой статус
+func newPresence(lastSeen, lastHeartbeat sql.NullTime, hidden bool) *Presence {
+	if hidden {
+		return nil
+	}
+	presence := &Presence{State: PresenceOffline, LastSeen: lastSeen.Time}
+	switch {
+	case lastSeen.Valid && time.Since(lastSeen.Time) < presenceActiveWindow:
+		presence.State = PresenceOnline
+	case lastHeartbeat.Valid && time.Since(lastHeartbeat.Time) < presenceConnectionWindow:
+		presence.State = PresenceAway
+	}
+	return presence
+}
+
+// GetPresence возвращает статус пользователя или nil, если он его скрыл
+func GetPresence(userID int) (*Presence, error) {
+	var lastSeen, lastHeartbeat sql.NullTime
+	var hidden bool
+	err := DB.QueryRow(`
+		SELECT last_seen_at, last_heartbeat_at, hide_presence FROM users WHERE id = $1
+	`, userID).Scan(&lastSeen, &lastHeartbeat, &hidden)
+	if err != nil {
+		return nil, err
+	}
+	return newPresence(lastSeen, lastHeartbeat, hidden), nil
+}
+
+// presenceWrites запоминает время последней записи, чтобы не обновлять
+// строку пользователя на каждый запрос
+var presenceWrites = struct {
+	sync.Mutex
+	activity  map[int]time.Time
+	heartbeat map[int]time.Time
+}{
+	activity:  make(map[int]time.Time),
+	heartbeat: make(map[int]time.Time),
+}
+
+// presenceDue проверяет, пора ли снова записать отметку, и резервирует запись
+func presenceDue(writes map[int]time.Time, userID int, throttle time.Duration) bool {
+	presenceWrites.Lock()
+	defer presenceWrites.Unlock()
+	if time.Since(writes[userID]) < throttle {
+		return false
+	}
+	writes[userID] = time.Now()
+	return true
+}
+
+// prunePresenceWrites забывает отметки, для которых ограничение частоты уже прошло:
+// без них запись всё равно выполнится, а карты не растут с числом пользователей
+func prunePresenceWrites() {
+	presenceWrites.Lock()
+	defer presenceWrites.Unlock()
+	for userID, written := range presenceWrites.activity {
+		if time.Since(written) >= presenceActivityThrottle {
+			delete(presenceWrites.activity, userID)
+		}
+	}
+	for userID, written := range presenceWrites.heartbeat {
+		if time.Since(written) >= presenceHeartbeatThrottle {
+			delete(presenceWrites.heartbeat, userID)
+		}
+	}
+}
+
+// TouchActivity отмечает действие пользователя: запрос к сайту или активность во вкладке
+func TouchActivity(userID int) {
+	if !presenceDue(presenceWrites.activity, userID, presenceActivityThrottle) {
+		return
+	}
+	if _, err := DB.Exec(`UPDATE users SET last_seen_at = NOW() WHERE id = $1`, userID); err != nil {
+		log.Println("Ошибка при обновлении времени активности:", err)
+	}
+}
+
+// touchHeartbeat отмечает, что у пользователя открыто подключение WebSocket или SSE
+func touchHeartbeat(userID int) {
+	if !presenceDue(presenceWrites.heartbeat, userID, presenceHeartbeatThrottle) {
+		return
+	}
+	if _, err := DB.Exec(`UPDATE users SET last_heartbeat_at = NOW() WHERE id = $1`, userID); err != nil {
+		log.Println("Ошибка при обновлении подключения:", err)
+	}
+}
@@ -0,0 +1,212 @@
+// internal/privacy.go
+package internal
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Круг пользователей, которым разрешено действие
+const (
+	PrivacyEveryone         = "everyone"
+	PrivacyFriendsOfFriends = "friends_of_friends"
+	PrivacyFriends          = "friends"
+	PrivacyNobody           = "nobody"
+)
+
+// Настройки приватности, хранящиеся в колонках таблицы users
+const (
+	privacySearch         = "search_visibility"
+	privacyFriendList     = "friends_visibility"
+	privacyFriendRequests = "friend_requests"
+)
+
+var (
+	ErrInvalidPrivacy        = errors.New("некорректная настройка приватности")
+	ErrFriendRequestsClosed  = errors.New("пользователь не принимает заявки в друзья")
+	ErrFriendRequestsLimited = errors.New("пользователь принимает заявки только от друзей своих друзей")
+)
+
+// Варианты для каждой настройки на странице приватности
+var (
+	searchVisibilityOptions = []Option{
+		{Value: PrivacyEveryone, Label: "Все"},
+		{Value: PrivacyFriendsOfFriends, Label: "Друзья друзей"},
+		{Value: PrivacyFriends, Label: "Только друзья"},
+		{Value: PrivacyNobody, Label: "Никто"},
+	}
+	friendsVisibilityOptions = []Option{
+		{Value: PrivacyEveryone, Label: "Все"},
+		{Value: PrivacyFriends, Label: "Только друзья"},
+		{Value: PrivacyNobody, Label: "Только я"},
+	}
+	friendRequestOptions = []Option{
+		{Value: PrivacyEveryone, Label: "Все"},
+		{Value: PrivacyFriendsOfFriends, Label: "Друзья друзей"},
+		{Value: PrivacyNobody, Label: "Никто"},
+	}
+	defaultAudienceOptions = []Option{
+		{Value: AudiencePublic, Label: "Все"},
+		{Value: AudienceFriends, Label: "Друзья"},
+		{Value: AudiencePrivate, Label: "Только я"},
+	}
+)
+
+// PrivacySettings — настройки приватности пользователя
+type PrivacySettings struct {
+	SearchVisibility  string // кто может найти пользователя в поиске и рекомендациях
+	FriendsVisibility string // кто видит список друзей и их количество
+	FriendRequests    string // кто может добавить пользователя в друзья
+	DefaultAudience   string // аудитория новых постов по умолчанию
+	HidePresence      bool   // скрывать статус в сети
+}
+
+// privacyCondition возвращает SQL-условие: настройка setting пользователя owner
+// разрешает действие пользователю viewer. Аргументы — выражения SQL.
+// Сам пользователь всегда проходит проверку
+func privacyCondition(setting, owner, viewer string) string {
+	friends := `EXISTS (
+			SELECT 1 FROM friendships f
+			WHERE (f.user_id = ` + owner + ` AND f.friend_id = ` + viewer + `)
+			   OR (f.user_id = ` + viewer + ` AND f.friend_id = ` + owner + `)
+		)`
+	friendsOfFriends := `EXISTS (
+			SELECT 1
+			FROM (SELECT user_id AS a, friend_id AS b FROM friendships
+			      UNION ALL SELECT friend_id, user_id FROM friendships) e1
+			JOIN (SELECT user_id AS a, friend_id AS b FROM friendships
+			      UNION ALL SELECT friend_id, user_id FROM friendships) e2 ON e2.a = e1.b
+			WHERE e1.a = ` + owner + ` AND e2.b = ` + viewer + `
+		)`
+	return `(` + owner + ` = ` + viewer + `
+		OR ` + setting + ` = 'everyone'
+		OR (` + setting + ` IN ('friends', 'friends_of_friends') AND ` + friends + `)
+		OR (` + setting + ` = 'friends_of_friends' AND ` + friendsOfFriends + `))`
+}
+
+// privacyAllows проверяет настройку приватности setting пользователя ownerID для viewerID
+func privacyAllows(setting string, ownerID, viewerID int) (bool, error) {
+	var allowed bool
+	err := DB.QueryRow(`
+		SELECT `+privacyCondition("u."+setting, "u.id", "$2")+`
+		FROM users u
+		WHERE u.id = $1
+	`, ownerID, viewerID).Scan(&allowed)
+	return allowed, err
+}
+
+// GetPrivacySettings возвращает настройки приватности пользователя
+func GetPrivacySettings(userID int) (PrivacySettings, error) {
+	var settings PrivacySettings
+	err := DB.QueryRow(`
+		SELECT search_visibility, friends_visibility, friend_requests, default_audience, hide_presence
+		FROM users
+		WHERE id = $1
+	`, userID).Scan(&settings.SearchVisibility, &settings.FriendsVisibility, &settings.FriendRequests,
+		&settings.DefaultAudience, &settings.HidePresence)
+	return settings, err
+}
+
+// SavePrivacySettings сохраняет настройки приватности пользователя
+func SavePrivacySettings(userID int, settings PrivacySettings) error {
+	if !hasOption(searchVisibilityOptions, settings.SearchVisibility) ||
+		!hasOption(friendsVisibilityOptions, settings.FriendsVisibility) ||
+		!hasOption(friendRequestOptions, settings.FriendRequests) ||
+		!hasOption(defaultAudienceOptions, settings.DefaultAudience) {
+		return ErrInvalidPrivacy
+	}
+	_, err := DB.Exec(`
+		UPDATE users
+		SET search_visibility = $2, friends_visibility = $3, friend_requests = $4,
+		    default_audience = $5, hide_presence = $6
+		WHERE id = $1
+	`, userID, settings.SearchVisibility, settings.FriendsVisibility, settings.FriendRequests,
+		settings.DefaultAudience, settings.HidePresence)
+	return err
+}
+
+// checkFriendRequestAllowed проверяет, принимает ли пользователь заявку в друзья от senderID
+func checkFriendRequestAllowed(senderID, userID int) error {
+	var setting string
+	err := DB.QueryRow(`SELECT friend_requests FROM users WHERE id = $1`, userID).Scan(&setting)
+	if err != nil {
+		return err
+	}
+	if setting == PrivacyNobody {
+		return ErrFriendRequestsClosed
+	}
+	allowed, err := privacyAllows(privacyFriendRequests, userID, senderID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrFriendRequestsLimited
+	}
+	return nil
+}
+
+// PrivacySettingsHandler показывает и сохраняет настройки приватности
+func PrivacySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		settings := PrivacySettings{
+			SearchVisibility:  r.FormValue("search_visibility"),
+			FriendsVisibility: r.FormValue("friends_visibility"),
+			FriendRequests:    r.FormValue("friend_requests"),
+			DefaultAudience:   r.FormValue("default_audience"),
+			HidePresence:      r.FormValue("hide_presence") != "",
+		}
+		err := SavePrivacySettings(userID, settings)
+		if err == ErrInvalidPrivacy {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("Ошибка при сохранении настроек приватности:", err)
+			http.Error(w, "Ошибка при сохранении настроек", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/settings/privacy?saved=1", http.StatusSeeOther)
+		return
+	}
+
+	header, err := loadHeaderData(userID)
+	if err != nil {
+		log.Println("Ошибка при получении данных пользователя:", err)
+		http.Error(w, "Ошибка при загрузке данных пользователя", http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := GetPrivacySettings(userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке настроек приватности:", err)
+		http.Error(w, "Ошибка при загрузке настроек", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Header          HeaderData
+		Settings        PrivacySettings
+		SearchOptions   []Option
+		FriendsOptions  []Option
+		RequestOptions  []Option
+		AudienceOptions []Option
+		Saved           bool
+	}{
+		Header:          header,
+		Settings:        settings,
+		SearchOptions:   searchVisibilityOptions,
+		FriendsOptions:  friendsVisibilityOptions,
+		RequestOptions:  friendRequestOptions,
+		AudienceOptions: defaultAudienceOptions,
+		Saved:           r.URL.Query().Get("saved") != "",
+	}
+
+	renderTemplate(w, "privacy-settings.html", data)
+}
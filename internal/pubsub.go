@@ -0,0 +1,144 @@
+// internal/pubsub.go
+package internal
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PubSub доставляет события реального времени всем экземплярам сервера.
+// Каждый экземпляр подписывается на общий поток и сам выбирает события для
+// своих подключённых клиентов
+type PubSub interface {
+	// Publish отправляет событие всем подписчикам, включая текущий экземпляр
+	Publish(event RealtimeEvent) error
+	// Subscribe регистрирует обработчик входящих событий
+	Subscribe(handler func(RealtimeEvent)) error
+	Close() error
+}
+
+// realtimeChannel — имя канала LISTEN/NOTIFY в Postgres
+const realtimeChannel = "realtime_events"
+
+// maxNotifyPayload — предел размера NOTIFY в Postgres (8000 байт) с запасом.
+// Более крупные события передаются ссылкой и дочитываются из таблицы realtime_events
+const maxNotifyPayload = 7500
+
+// memoryPubSub доставляет события внутри одного процесса
+type memoryPubSub struct {
+	mu       sync.RWMutex
+	handlers []func(RealtimeEvent)
+}
+
+// NewMemoryPubSub создаёт PubSub для запуска в одном экземпляре
+func NewMemoryPubSub() PubSub {
+	return &memoryPubSub{}
+}
+
+func (p *memoryPubSub) Publish(event RealtimeEvent) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, handler := range p.handlers {
+		handler(event)
+	}
+	return nil
+}
+
+func (p *memoryPubSub) Subscribe(handler func(RealtimeEvent)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers = append(p.handlers, handler)
+	return nil
+}
+
+func (p *memoryPubSub) Close() error {
+	return nil
+}
+
+// postgresPubSub передаёт события между экземплярами через LISTEN/NOTIFY
+type postgresPubSub struct {
+	listener *pq.Listener
+	done     chan struct{}
+}
+
+// pgEnvelope — содержимое NOTIFY. Для крупных событий передаётся только ссылка
+type pgEnvelope struct {
+	Ref   bool          `json:"ref,omitempty"`
+	Event RealtimeEvent `json:"event"`
+}
+
+// NewPostgresPubSub создаёт PubSub на основе LISTEN/NOTIFY
+func NewPostgresPubSub(connStr string) (PubSub, error) {
+	listener := pq.NewListener(connStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("Ошибка подписки на события Postgres:", err)
+		}
+	})
+	if err := listener.Listen(realtimeChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &postgresPubSub{listener: listener, done: make(chan struct{})}, nil
+}
+
+func (p *postgresPubSub) Publish(event RealtimeEvent) error {
+	payload, err := json.Marshal(pgEnvelope{Event: event})
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		if event.ID == 0 {
+			log.Printf("Событие %s слишком велико для NOTIFY и не сохранено, пропускаем\n", event.Type)
+			return nil
+		}
+		ref := RealtimeEvent{ID: event.ID, UserID: event.UserID, Type: event.Type}
+		if payload, err = json.Marshal(pgEnvelope{Ref: true, Event: ref}); err != nil {
+			return err
+		}
+	}
+
+	_, err = DB.Exec(`SELECT pg_notify($1, $2)`, realtimeChannel, string(payload))
+	return err
+}
+
+func (p *postgresPubSub) Subscribe(handler func(RealtimeEvent)) error {
+	go func() {
+		for {
+			select {
+			case <-p.done:
+				return
+			case notification := <-p.listener.Notify:
+				// nil приходит после переподключения: часть событий могла потеряться,
+				// клиенты догонят их при переподключении по last_event_id
+				if notification == nil {
+					continue
+				}
+				var envelope pgEnvelope
+				if err := json.Unmarshal([]byte(notification.Extra), &envelope); err != nil {
+					log.Println("Некорректное событие из Postgres:", err)
+					continue
+				}
+				event := envelope.Event
+				if envelope.Ref {
+					loaded, err := loadRealtimeEvent(event.UserID, event.ID)
+					if err != nil {
+						log.Println("Ошибка при загрузке события:", err)
+						continue
+					}
+					event = loaded
+				}
+				handler(event)
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *postgresPubSub) Close() error {
+	close(p.done)
+	return p.listener.Close()
+}
@@ -0,0 +1,208 @@
+// internal/reactions.go
+package internal
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// Reaction описывает доступную реакцию на пост
+type Reaction struct {
+	Code  string
+	Emoji string
+}
+
+// postReactions — доступные реакции в порядке отображения
+var postReactions = []Reaction{
+	{Code: "like", Emoji: "👍"},
+	{Code: "love", Emoji: "❤️"},
+	{Code: "haha", Emoji: "😄"},
+	{Code: "wow", Emoji: "😮"},
+	{Code: "sad", Emoji: "😢"},
+}
+
+var ErrInvalidReaction = errors.New("неизвестная реакция")
+
+// ReactionChange описывает результат нажатия на реакцию
+type ReactionChange int
+
+const (
+	ReactionAdded ReactionChange = iota
+	ReactionReplaced
+	ReactionRemoved
+)
+
+// ReactionCount — число реакций одного вида на пост
+type ReactionCount struct {
+	Code  string
+	Emoji string
+	Count int
+	Mine  bool
+}
+
+func isValidReaction(code string) bool {
+	for _, reaction := range postReactions {
+		if reaction.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// ToggleReaction ставит реакцию на пост, меняет её на другую или снимает,
+// если пользователь выбрал ту же самую
+func ToggleReaction(postID, userID int, code string) (ReactionChange, error) {
+	if !isValidReaction(code) {
+		return 0, ErrInvalidReaction
+	}
+
+	visible, err := CanViewPost(postID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if !visible {
+		return 0, ErrPostNotFound
+	}
+
+	result, err := DB.Exec(`DELETE FROM post_reactions WHERE post_id = $1 AND user_id = $2 AND reaction = $3`, postID, userID, code)
+	if err != nil {
+		return 0, err
+	}
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		return ReactionRemoved, nil
+	}
+
+	// xmax = 0 только у новой строки, у обновлённой при конфликте он заполнен
+	var inserted bool
+	err = DB.QueryRow(`
+		INSERT INTO post_reactions (post_id, user_id, reaction)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (post_id, user_id) DO UPDATE SET reaction = EXCLUDED.reaction, created_at = NOW()
+		RETURNING xmax = 0
+	`, postID, userID, code).Scan(&inserted)
+	if err != nil {
+		return 0, err
+	}
+	if inserted {
+		return ReactionAdded, nil
+	}
+	return ReactionReplaced, nil
+}
+
+// LoadReactions возвращает реакции на посты, сгруппированные по ID поста.
+// Для каждого поста возвращаются все виды реакций, включая нулевые
+func LoadReactions(postIDs []int, userID int) (map[int][]ReactionCount, error) {
+	reactions := make(map[int][]ReactionCount)
+	if len(postIDs) == 0 {
+		return reactions, nil
+	}
+
+	rows, err := DB.Query(`
+		SELECT post_id, reaction, COUNT(*), BOOL_OR(user_id = $2)
+		FROM post_reactions
+		WHERE post_id = ANY($1)
+		GROUP BY post_id, reaction
+	`, pq.Array(postIDs), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type key struct {
+		postID int
+		code   string
+	}
+	counts := make(map[key]ReactionCount)
+	for rows.Next() {
+		var postID int
+		var count ReactionCount
+		if err := rows.Scan(&postID, &count.Code, &count.Count, &count.Mine); err != nil {
+			return nil, err
+		}
+		counts[key{postID, count.Code}] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, postID := range postIDs {
+		for _, reaction := range postReactions {
+			count := counts[key{postID, reaction.Code}]
+			count.Code = reaction.Code
+			count.Emoji = reaction.Emoji
+			reactions[postID] = append(reactions[postID], count)
+		}
+	}
+	return reactions, nil
+}
+
+// attachReactions подгружает реакции к списку постов
+func attachReactions(posts []Post, userID int) {
+	postIDs := make([]int, 0, len(posts))
+	for _, post := range posts {
+		postIDs = append(postIDs, post.ID)
+	}
+
+	reactions, err := LoadReactions(postIDs, userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке реакций:", err)
+		return
+	}
+	for i := range posts {
+		posts[i].Reactions = reactions[posts[i].ID]
+	}
+}
+
+// ReactionHandler ставит или снимает реакцию на пост
+func ReactionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil || postID <= 0 {
+		http.Error(w, "Некорректный ID поста", http.StatusBadRequest)
+		return
+	}
+
+	change, err := ToggleReaction(postID, userID, r.FormValue("reaction"))
+	switch {
+	case err == ErrInvalidReaction:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err == ErrPostNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Println("Ошибка при сохранении реакции:", err)
+		http.Error(w, "Ошибка при сохранении реакции", http.StatusInternalServerError)
+		return
+	}
+
+	// Смена реакции не создаёт нового уведомления, снятие убирает непрочитанное
+	if change != ReactionReplaced {
+		var authorID int
+		if err := DB.QueryRow(`SELECT user_id FROM posts WHERE id = $1`, postID).Scan(&authorID); err != nil {
+			log.Println("Ошибка при получении автора поста:", err)
+		} else if change == ReactionAdded {
+			if err := Notify(authorID, NotificationReaction, userID, "post", postID, "Новая реакция на ваш пост"); err != nil {
+				log.Println("Ошибка при создании уведомления:", err)
+			}
+		} else if err := retractNotification(authorID, NotificationReaction, userID, "post", postID); err != nil {
+			log.Println("Ошибка при удалении уведомления:", err)
+		}
+	}
+
+	redirectBack(w, r, "/posts")
+}
@@ -0,0 +1,468 @@
+// internal/realtime.go
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Типы событий реального времени
+const (
+	RealtimeMessage      = "message"
+	RealtimeNotification = "notification"
+	RealtimeTyping       = "typing"
+	RealtimeUnread       = "unread"
+	RealtimePong         = "pong"
+	RealtimeResume       = "resume"
+)
+
+const (
+	// realtimePingInterval — как часто сервер проверяет, что клиент на связи
+	realtimePingInterval = 25 * time.Second
+	// realtimeReadTimeout — через сколько без входящих кадров соединение считается потерянным
+	realtimeReadTimeout = 60 * time.Second
+	// realtimeSendBuffer — сколько событий может ждать отправки медленному клиенту
+	realtimeSendBuffer = 64
+	// realtimeReplayLimit — сколько пропущенных событий досылается при переподключении
+	realtimeReplayLimit = 500
+	// realtimeEventRetention — сколько хранятся события для досылки
+	realtimeEventRetention = 3 * 24 * time.Hour
+)
+
+// ErrRealtimeDisabled возвращается, если доставка в реальном времени не запущена
+var ErrRealtimeDisabled = errors.New("доставка в реальном времени не запущена")
+
+// RealtimeEvent — событие для одного пользователя. У сохранённых событий есть ID —
+// порядковый номер среди событий этого пользователя, по которому клиент может
+// догнать пропущенное после переподключения
+type RealtimeEvent struct {
+	ID     int64           `json:"id,omitempty"`
+	UserID int             `json:"user_id"`
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// realtimeSubscriber — одно подключение пользователя к хабу
+type realtimeSubscriber struct {
+	userID  int
+	send    chan RealtimeEvent
+	dropped chan struct{}
+	once    sync.Once
+}
+
+// drop отключает подписчика, который не успевает забирать события
+func (s *realtimeSubscriber) drop() {
+	s.once.Do(func() { close(s.dropped) })
+}
+
+// realtimeHub хранит подключения текущего экземпляра и раздаёт им события из PubSub
+type realtimeHub struct {
+	mu          sync.RWMutex
+	subscribers map[int]map[*realtimeSubscriber]bool
+	pubsub      PubSub
+}
+
+var hub *realtimeHub
+
+// InitRealtime запускает хаб событий реального времени с PubSub из конфигурации
+func InitRealtime() {
+	var pubsub PubSub
+	switch AppConfig.PubSub {
+	case "", "memory":
+		pubsub = NewMemoryPubSub()
+	case "postgres":
+		var err error
+		if pubsub, err = NewPostgresPubSub(connectionString()); err != nil {
+			log.Fatal("Ошибка подключения к LISTEN/NOTIFY:", err)
+		}
+	default:
+		log.Fatalf("Неизвестный PubSub: %q\n", AppConfig.PubSub)
+	}
+
+	h := &realtimeHub{subscribers: make(map[int]map[*realtimeSubscriber]bool), pubsub: pubsub}
+	if err := pubsub.Subscribe(h.dispatch); err != nil {
+		log.Fatal("Ошибка подписки на события:", err)
+	}
+	hub = h
+}
+
+func (h *realtimeHub) subscribe(userID int) *realtimeSubscriber {
+	sub := &realtimeSubscriber{
+		userID:  userID,
+		send:    make(chan RealtimeEvent, realtimeSendBuffer),
+		dropped: make(chan struct{}),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[*realtimeSubscriber]bool)
+	}
+	h.subscribers[userID][sub] = true
+	return sub
+}
+
+func (h *realtimeHub) unsubscribe(sub *realtimeSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers[sub.userID], sub)
+	if len(h.subscribers[sub.userID]) == 0 {
+		delete(h.subscribers, sub.userID)
+	}
+}
+
+// hasSubscribers проверяет, подключён ли пользователь к этому экземпляру сервера
+func (h *realtimeHub) hasSubscribers(userID int) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers[userID]) > 0
+}
+
+// dispatch передаёт событие подключениям адресата. Медленные подключения
+// не задерживают остальных: при переполнении буфера они отключаются
+// и догоняют пропущенное при переподключении
+func (h *realtimeHub) dispatch(event RealtimeEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subscribers[event.UserID] {
+		select {
+		case sub.send <- event:
+		default:
+			sub.drop()
+		}
+	}
+}
+
+// PublishToUser сохраняет событие для досылки и отправляет его пользователю.
+// Событие сохраняется, только если пользователь был на связи за время хранения
+// событий: иначе у него нет клиента, который мог бы попросить досылку
+func PublishToUser(userID int, eventType string, data interface{}) error {
+	return publishEvent(userID, eventType, data, true)
+}
+
+// publishEphemeral отправляет событие без сохранения: оно теряется, если пользователь не в сети
+func publishEphemeral(userID int, eventType string, data interface{}) error {
+	return publishEvent(userID, eventType, data, false)
+}
+
+func publishEvent(userID int, eventType string, data interface{}, persist bool) error {
+	if hub == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	event := RealtimeEvent{UserID: userID, Type: eventType, Data: payload}
+	if persist {
+		// Номер выдаётся и событие сохраняется одним запросом: строка счётчика
+		// заблокирована до его завершения, поэтому событие с большим номером
+		// не может стать видимым раньше события с меньшим
+		err = DB.QueryRow(`
+			WITH active AS (
+				SELECT id FROM users
+				WHERE id = $1 AND last_heartbeat_at > NOW() - $4 * INTERVAL '1 second'
+			), next AS (
+				INSERT INTO realtime_sequences (user_id, seq)
+				SELECT id, 1 FROM active
+				ON CONFLICT (user_id) DO UPDATE SET seq = realtime_sequences.seq + 1
+				RETURNING seq
+			)
+			INSERT INTO realtime_events (user_id, seq, type, payload)
+			SELECT $1, seq, $2, $3 FROM next
+			RETURNING seq
+		`, userID, eventType, string(payload), realtimeEventRetention.Seconds()).Scan(&event.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	return hub.pubsub.Publish(event)
+}
+
+// loadRealtimeEvent загружает сохранённое событие пользователя по номеру
+func loadRealtimeEvent(userID int, seq int64) (RealtimeEvent, error) {
+	event := RealtimeEvent{ID: seq, UserID: userID}
+	var payload string
+	err := DB.QueryRow(`
+		SELECT type, payload FROM realtime_events WHERE user_id = $1 AND seq = $2
+	`, userID, seq).Scan(&event.Type, &payload)
+	event.Data = json.RawMessage(payload)
+	return event, err
+}
+
+// realtimeSequence возвращает номер последнего сохранённого события пользователя
+func realtimeSequence(userID int) (int64, error) {
+	var seq int64
+	err := DB.QueryRow(`SELECT seq FROM realtime_sequences WHERE user_id = $1`, userID).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// realtimeEventsSince возвращает события пользователя, сохранённые после lastEventID
+func realtimeEventsSince(userID int, lastEventID int64) ([]RealtimeEvent, error) {
+	rows, err := DB.Query(`
+		SELECT seq, type, payload
+		FROM realtime_events
+		WHERE user_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`, userID, lastEventID, realtimeReplayLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []RealtimeEvent
+	for rows.Next() {
+		event := RealtimeEvent{UserID: userID}
+		var payload string
+		if err := rows.Scan(&event.ID, &event.Type, &payload); err != nil {
+			return nil, err
+		}
+		event.Data = json.RawMessage(payload)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// cleanupRealtimeEvents удаляет события, которые уже не нужны для досылки,
+// и устаревшие отметки присутствия в памяти
+func cleanupRealtimeEvents() error {
+	prunePresenceWrites()
+	_, err := DB.Exec(`
+		DELETE FROM realtime_events WHERE created_at < NOW() - $1 * INTERVAL '1 second'
+	`, realtimeEventRetention.Seconds())
+	return err
+}
+
+// messageEvent — содержимое события о новом сообщении
+type messageEvent struct {
+	ID             int64  `json:"id"`
+	ConversationID int    `json:"conversation_id"`
+	SenderID       int    `json:"sender_id"`
+	SenderName     string `json:"sender_name"`
+	Kind           string `json:"kind"`
+	Body           string `json:"body"`
+	CreatedAt      string `json:"created_at"`
+	Unread         int    `json:"unread"`
+}
+
+// publishMessage рассылает новое сообщение всем участникам диалога
+func publishMessage(message Message) {
+	if hub == nil {
+		return
+	}
+
+	memberIDs, err := conversationMemberIDs(message.ConversationID)
+	if err != nil {
+		log.Println("Ошибка при получении участников диалога:", err)
+		return
+	}
+
+	event := messageEvent{
+		ID:             message.ID,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		SenderName:     message.SenderName,
+		Kind:           message.Kind,
+		Body:           message.Body,
+		CreatedAt:      message.CreatedAt.Format("02.01.2006 15:04"),
+	}
+	for _, memberID := range memberIDs {
+		if event.Unread, err = UnreadMessagesCount(memberID); err != nil {
+			log.Println("Ошибка при подсчёте непрочитанных сообщений:", err)
+		}
+		if err := PublishToUser(memberID, RealtimeMessage, event); err != nil {
+			log.Println("Ошибка при отправке события:", err)
+		}
+		if memberID != message.SenderID && message.Kind == MessageKindText {
+			push := pushMessage{
+				Title: message.SenderName,
+				Body:  message.Body,
+				URL:   fmt.Sprintf("/messages/conversation?id=%d", message.ConversationID),
+				Tag:   fmt.Sprintf("conversation-%d", message.ConversationID),
+			}
+			if err := queuePush(memberID, push); err != nil {
+				log.Println("Ошибка при подготовке push-уведомления:", err)
+			}
+		}
+	}
+}
+
+// realtimeClientMessage — сообщение, присланное клиентом по WebSocket
+type realtimeClientMessage struct {
+	Type           string `json:"type"`
+	ConversationID int    `json:"conversation_id"`
+	MessageID      int64  `json:"message_id"`
+}
+
+// handleClientMessage обрабатывает сообщение клиента: индикатор набора,
+// отметку о прочтении или пинг на уровне приложения
+func handleClientMessage(userID int, raw []byte) error {
+	var msg realtimeClientMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return err
+	}
+
+	switch msg.Type {
+	case "active":
+		TouchActivity(userID)
+	case "ping":
+		return publishEphemeral(userID, RealtimePong, nil)
+	case "typing":
+		member, err := IsConversationMember(msg.ConversationID, userID)
+		if err != nil || !member {
+			return err
+		}
+		memberIDs, err := conversationMemberIDs(msg.ConversationID)
+		if err != nil {
+			return err
+		}
+		typing := map[string]interface{}{
+			"conversation_id": msg.ConversationID,
+			"user_id":         userID,
+			"username":        usernameByID(userID),
+		}
+		for _, memberID := range memberIDs {
+			if memberID == userID {
+				continue
+			}
+			if err := publishEphemeral(memberID, RealtimeTyping, typing); err != nil {
+				return err
+			}
+		}
+	case "read":
+		if err := MarkConversationRead(msg.ConversationID, userID, msg.MessageID); err != nil {
+			return err
+		}
+		unread, err := UnreadMessagesCount(userID)
+		if err != nil {
+			return err
+		}
+		// Обновляем счётчик во всех открытых вкладках пользователя
+		return publishEphemeral(userID, RealtimeUnread, map[string]int{"messages": unread})
+	}
+	return nil
+}
+
+// RealtimeHandler открывает WebSocket-подключение для событий текущего пользователя.
+// Параметр last_event_id позволяет получить события, пропущенные при обрыве связи
+func RealtimeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+	if hub == nil {
+		http.Error(w, ErrRealtimeDisabled.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	lastEventID, _ := strconv.ParseInt(r.URL.Query().Get("last_event_id"), 10, 64)
+
+	// Номер больше последнего выданного остался от другого аккаунта в этой вкладке.
+	// Номер читается до подписки, поэтому события после него не потеряются
+	currentSeq, err := realtimeSequence(userID)
+	if err != nil {
+		log.Println("Ошибка при загрузке номера событий:", err)
+		http.Error(w, "Ошибка при подключении", http.StatusInternalServerError)
+		return
+	}
+	if lastEventID > currentSeq {
+		lastEventID = currentSeq
+	}
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Подписываемся до чтения пропущенных событий, чтобы ничего не потерять между ними
+	sub := hub.subscribe(userID)
+	defer hub.unsubscribe(sub)
+	touchHeartbeat(userID)
+
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		extendDeadline := func() { conn.SetReadDeadline(time.Now().Add(realtimeReadTimeout)) }
+		extendDeadline()
+		for {
+			opcode, message, err := conn.ReadMessage(extendDeadline)
+			if err != nil {
+				return
+			}
+			if opcode != wsOpText {
+				continue
+			}
+			if err := handleClientMessage(userID, message); err != nil {
+				log.Println("Ошибка при обработке сообщения WebSocket:", err)
+			}
+		}
+	}()
+
+	writeEvent := func(event RealtimeEvent) error {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return conn.WriteFrame(wsOpText, payload)
+	}
+
+	// Сообщаем клиенту, с какого номера продолжается досылка
+	resume, err := json.Marshal(map[string]int64{"last_id": lastEventID})
+	if err != nil {
+		return
+	}
+	if err := writeEvent(RealtimeEvent{UserID: userID, Type: RealtimeResume, Data: resume}); err != nil {
+		return
+	}
+
+	if lastEventID > 0 {
+		missed, err := realtimeEventsSince(userID, lastEventID)
+		if err != nil {
+			log.Println("Ошибка при загрузке пропущенных событий:", err)
+		}
+		for _, event := range missed {
+			if err := writeEvent(event); err != nil {
+				return
+			}
+			lastEventID = event.ID
+		}
+	}
+
+	ticker := time.NewTicker(realtimePingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-sub.send:
+			// Событие могло уже прийти при досылке
+			if event.ID != 0 && event.ID <= lastEventID {
+				continue
+			}
+			if err := writeEvent(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteFrame(wsOpPing, nil); err != nil {
+				return
+			}
+			touchHeartbeat(userID)
+		case <-sub.dropped:
+			conn.CloseWithStatus(wsCloseTryAgainLater, "slow consumer")
+			return
+		case <-readerDone:
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// internal/render.go
+package internal
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// HeaderData содержит данные для шапки сайта (шаблон header в partials.html)
+type HeaderData struct {
+	UserID      int
+	Username    string
+	AvatarURL   string
+	IsModerator bool
+	IsAdmin     bool
+
+	UnreadMessages      int
+	UnreadNotifications int
+}
+
+// Option — вариант выбора в форме: значение и подпись
+type Option struct {
+	Value string
+	Label string
+}
+
+// hasOption проверяет, что значение есть среди вариантов
+func hasOption(options []Option, value string) bool {
+	for _, option := range options {
+		if option.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// templateFuncs содержит вспомогательные функции, доступные во всех шаблонах
+var templateFuncs = template.FuncMap{
+	"reportReasonLabel": reportReasonLabel,
+	"reportStatusLabel": reportStatusLabel,
+	"reportTarget":      newReportTarget,
+	"auditActionLabel":  auditActionLabel,
+	"postText":          renderPostText,
+	"commentText":       renderCommentText,
+}
+
+// renderTemplate загружает шаблон из web/templates вместе с общими частями (partials.html) и рендерит его
+func renderTemplate(w http.ResponseWriter, name string, data interface{}) {
+	tmplPath := filepath.Join("web", "templates", name)
+	partialsPath := filepath.Join("web", "templates", "partials.html")
+	tmpl, err := template.New(name).Funcs(templateFuncs).ParseFiles(tmplPath, partialsPath)
+	if err != nil {
+		log.Printf("Ошибка при загрузке шаблона %s: %v\n", name, err)
+		http.Error(w, "Не удалось загрузить шаблон "+name, http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Ошибка при рендеринге шаблона %s: %v\n", name, err)
+	}
+}
+
+// loadHeaderData загружает данные текущего пользователя для шапки
+func loadHeaderData(userID int) (HeaderData, error) {
+	header := HeaderData{UserID: userID}
+	var role string
+	err := DB.QueryRow(`
+		SELECT username, COALESCE(avatar_url, '/static/avatar.jpg'), role
+		FROM users
+		WHERE id = $1
+	`, userID).Scan(&header.Username, &header.AvatarURL, &role)
+	if err != nil {
+		return header, err
+	}
+	header.IsModerator = role == RoleModerator || role == RoleAdmin
+	header.IsAdmin = role == RoleAdmin
+
+	// Счётчик непрочитанных сообщений не критичен для отображения страницы
+	if header.UnreadMessages, err = UnreadMessagesCount(userID); err != nil {
+		log.Println("Ошибка при подсчёте непрочитанных сообщений:", err)
+	}
+	if header.UnreadNotifications, err = UnreadNotificationsCount(userID); err != nil {
+		log.Println("Ошибка при подсчёте непрочитанных уведомлений:", err)
+	}
+	return header, nil
+}
+
+// isLocalPath проверяет, что путь ведёт на этот же сайт. Пути вида //host и /\host
+// браузеры считают адресами другого сайта
+func isLocalPath(path string) bool {
+	return strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") && !strings.HasPrefix(path, "/\\")
+}
+
+// redirectBack возвращает пользователя на страницу, с которой пришёл запрос.
+// Используется только путь из Referer, чтобы не допустить перенаправления на чужой сайт
+func redirectBack(w http.ResponseWriter, r *http.Request, fallback string) {
+	target := fallback
+	if referer, err := url.Parse(r.Referer()); err == nil && isLocalPath(referer.Path) {
+		target = referer.Path
+		if referer.RawQuery != "" {
+			target += "?" + referer.RawQuery
+		}
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
@@ -0,0 +1,170 @@
+// internal/richtext.go
+package internal
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Текст постов и комментариев хранится в исходном виде, как его ввёл автор,
+// и превращается в HTML при показе. Поддерживается упрощённая разметка:
+// абзацы через пустую строку, переносы строк, **жирный**, *курсив*, `код`,
+// ссылки, теги и упоминания. Весь текст экранируется, а теги HTML
+// создаёт только сам рендерер, поэтому внедрить разметку через пост нельзя
+
+var (
+	// textTokenPattern находит в тексте теги (#тег, группа 1) и упоминания
+	// (@имя, группа 2). Перед ними должно стоять начало строки или символ,
+	// который не может быть частью слова, адреса или HTML-сущности, поэтому
+	// якорь в ссылке (page#top), email (user@mail.ru) и &#39; не распознаются
+	textTokenPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&#@/.\-])(?:#([\p{L}\p{N}_]+)|@([\p{L}\p{N}_.\-]+))`)
+
+	paragraphSeparator = regexp.MustCompile(`\n[ \t]*\n\s*`)
+	codePattern        = regexp.MustCompile("`([^`\n]+)`")
+	boldPattern        = regexp.MustCompile(`\*\*(\S(?:[^\n]*?\S)?)\*\*`)
+	italicPattern      = regexp.MustCompile(`\*([^\s*](?:[^*\n]*?[^\s*])?)\*`)
+)
+
+// renderPostText превращает исходный текст поста в HTML: абзацы, переносы строк
+// и встроенную разметку. mentions сопоставляет имена упомянутых пользователей с их ID
+func renderPostText(content string, mentions map[string]int) template.HTML {
+	content = strings.TrimSpace(strings.ReplaceAll(content, "\r\n", "\n"))
+	if content == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, paragraph := range paragraphSeparator.Split(content, -1) {
+		b.WriteString("<p>")
+		for i, line := range strings.Split(strings.TrimSpace(paragraph), "\n") {
+			if i > 0 {
+				b.WriteString("<br>\n")
+			}
+			renderInline(&b, line, mentions)
+		}
+		b.WriteString("</p>\n")
+	}
+	return template.HTML(b.String())
+}
+
+// renderCommentText превращает текст комментария в HTML без деления на абзацы
+func renderCommentText(content string, mentions map[string]int) template.HTML {
+	var b strings.Builder
+	renderInline(&b, strings.TrimSpace(content), mentions)
+	return template.HTML(b.String())
+}
+
+// renderInline экранирует строку и оформляет встроенную разметку
+func renderInline(b *strings.Builder, s string, mentions map[string]int) {
+	for s != "" {
+		start, end, html := nextInline(s, mentions)
+		if start < 0 {
+			break
+		}
+		b.WriteString(template.HTMLEscapeString(s[:start]))
+		b.WriteString(html)
+		s = s[end:]
+	}
+	b.WriteString(template.HTMLEscapeString(s))
+}
+
+// nextInline находит в строке ближайший элемент разметки и возвращает его
+// границы и HTML. При совпадении начала приоритет у кода, затем у ссылок,
+// затем у выделения, так что внутри кода и адресов разметка не действует
+func nextInline(s string, mentions map[string]int) (start, end int, html string) {
+	start = -1
+	candidate := func(from, to int, render func() string) {
+		if from >= 0 && (start < 0 || from < start) {
+			start, end, html = from, to, render()
+		}
+	}
+
+	if m := codePattern.FindStringSubmatchIndex(s); m != nil {
+		candidate(m[0], m[1], func() string {
+			return "<code>" + template.HTMLEscapeString(s[m[2]:m[3]]) + "</code>"
+		})
+	}
+	if from, to, href := findURL(s); from >= 0 {
+		candidate(from, to, func() string {
+			return `<a href="` + template.HTMLEscapeString(href) + `" rel="nofollow noopener ugc" target="_blank">` +
+				template.HTMLEscapeString(s[from:to]) + `</a>`
+		})
+	}
+	for _, emphasis := range []struct {
+		pattern *regexp.Regexp
+		tag     string
+	}{{boldPattern, "strong"}, {italicPattern, "em"}} {
+		if m := findEmphasis(emphasis.pattern, s); m != nil {
+			tag := emphasis.tag
+			candidate(m[0], m[1], func() string {
+				var inner strings.Builder
+				renderInline(&inner, s[m[2]:m[3]], mentions)
+				return "<" + tag + ">" + inner.String() + "</" + tag + ">"
+			})
+		}
+	}
+	if from, to, link, class := findToken(s, mentions); from >= 0 {
+		candidate(from, to, func() string {
+			return `<a href="` + template.HTMLEscapeString(link) + `" class="` + class + `">` +
+				template.HTMLEscapeString(s[from:to]) + `</a>`
+		})
+	}
+	return start, end, html
+}
+
+// findURL находит первую ссылку и возвращает её границы и адрес для href.
+// Знаки препинания в конце и непарная закрывающая скобка считаются концом
+// предложения, а не адреса. Ссылкам вида www.example.com добавляется https://
+func findURL(s string) (from, to int, href string) {
+	for _, m := range urlPattern.FindAllStringIndex(s, -1) {
+		link := trimURLMatch(s[m[0]:m[1]])
+		href = link
+		if !strings.Contains(strings.ToLower(link), "://") {
+			href = "https://" + link
+		}
+		parsed, err := url.Parse(href)
+		if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			continue
+		}
+		return m[0], m[0] + len(link), parsed.String()
+	}
+	return -1, -1, ""
+}
+
+// findEmphasis находит первое выделение, которое не начинается внутри слова:
+// в «2*3*4» звёздочки остаются звёздочками
+func findEmphasis(pattern *regexp.Regexp, s string) []int {
+	for _, m := range pattern.FindAllStringSubmatchIndex(s, -1) {
+		if m[0] == 0 {
+			return m
+		}
+		if r, _ := utf8.DecodeLastRuneInString(s[:m[0]]); !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return m
+		}
+	}
+	return nil
+}
+
+// findToken находит первый тег или упоминание известного пользователя
+// и возвращает его границы вместе с символом # или @, адрес и CSS-класс ссылки
+func findToken(s string, mentions map[string]int) (from, to int, link, class string) {
+	for _, m := range textTokenPattern.FindAllStringSubmatchIndex(s, -1) {
+		if m[2] >= 0 {
+			// m[2]:m[3] — сам тег, символ # стоит перед ним
+			if tag := normalizeHashtag(s[m[2]:m[3]]); tag != "" {
+				return m[2] - 1, m[3], tagURL(tag), "hashtag"
+			}
+			continue
+		}
+		name := trimMention(s[m[4]:m[5]])
+		if userID, ok := mentions[name]; ok {
+			return m[4] - 1, m[4] + len(name), fmt.Sprintf("/profile?id=%d", userID), "mention"
+		}
+	}
+	return -1, -1, "", ""
+}
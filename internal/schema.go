@@ -0,0 +1,427 @@
+// internal/schema.go
+package internal
+
+import (
+	"log"
+)
+
+// schemaMigrations содержит DDL-запросы, применяемые при запуске сервера.
+// Базовые таблицы users, posts и friendships создаются вручную, здесь
+// описываются только дополнительные таблицы и колонки. Все запросы
+// идемпотентны, поэтому выполняются при каждом старте.
+var schemaMigrations = []string{
+	// Роли пользователей и блокировка аккаунтов модераторами
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_until TIMESTAMP`,
+
+	// Скрытие постов модераторами
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS hidden_at TIMESTAMP`,
+
+	// Комментарии к постам
+	`CREATE TABLE IF NOT EXISTS comments (
+		id SERIAL PRIMARY KEY,
+		post_id INT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		content TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		hidden_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS comments_post_id_idx ON comments (post_id, created_at)`,
+
+	// Жалобы на контент и очередь модерации
+	`CREATE TABLE IF NOT EXISTS reports (
+		id SERIAL PRIMARY KEY,
+		reporter_id INT REFERENCES users(id) ON DELETE SET NULL,
+		target_type TEXT NOT NULL,
+		target_id INT NOT NULL,
+		reason TEXT NOT NULL,
+		details TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'open',
+		moderator_id INT REFERENCES users(id) ON DELETE SET NULL,
+		resolution TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS reports_status_idx ON reports (status, created_at)`,
+
+	// Уведомления пользователей
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		actor_id INT REFERENCES users(id) ON DELETE SET NULL,
+		object_type TEXT NOT NULL DEFAULT '',
+		object_id INT NOT NULL DEFAULT 0,
+		message TEXT NOT NULL,
+		read_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS notifications_user_idx ON notifications (user_id, created_at DESC)`,
+
+	// Фильтр контента: ограничения длины, правила и задержанные публикации
+	`CREATE TABLE IF NOT EXISTS content_policy_settings (
+		id INT PRIMARY KEY CHECK (id = 1),
+		min_length INT NOT NULL DEFAULT 0,
+		max_length INT NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS content_rules (
+		id SERIAL PRIMARY KEY,
+		rule_type TEXT NOT NULL,
+		pattern TEXT NOT NULL,
+		action TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS held_at TIMESTAMP`,
+	`ALTER TABLE comments ADD COLUMN IF NOT EXISTS held_at TIMESTAMP`,
+
+	// Защита от спама: время создания дружбы и отпечаток текста поста
+	`ALTER TABLE friendships ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT NOW()`,
+	`CREATE INDEX IF NOT EXISTS friendships_user_created_idx ON friendships (user_id, created_at)`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS content_hash TEXT`,
+	`CREATE INDEX IF NOT EXISTS posts_content_hash_idx ON posts (content_hash, created_at)`,
+	`CREATE INDEX IF NOT EXISTS posts_user_created_idx ON posts (user_id, created_at)`,
+
+	// Журнал аудита. Записи нельзя изменять или удалять — это обеспечивают триггеры
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		actor_id INT,
+		action TEXT NOT NULL,
+		target_type TEXT NOT NULL DEFAULT '',
+		target_id INT NOT NULL DEFAULT 0,
+		ip TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		details TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_id, created_at)`,
+	`CREATE INDEX IF NOT EXISTS audit_log_action_idx ON audit_log (action, created_at)`,
+	`CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit_log is append-only';
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS audit_log_no_modify ON audit_log`,
+	`CREATE TRIGGER audit_log_no_modify BEFORE UPDATE OR DELETE ON audit_log
+		FOR EACH ROW EXECUTE FUNCTION audit_log_append_only()`,
+	`DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log`,
+	`CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+		FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only()`,
+
+	// Выгрузка персональных данных и удаление аккаунтов
+	`CREATE TABLE IF NOT EXISTS data_exports (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		status TEXT NOT NULL DEFAULT 'pending',
+		file_path TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		completed_at TIMESTAMP
+	)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_requested_at TIMESTAMP`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+
+	// Личные сообщения. Диалог двух пользователей однозначно определяется direct_key
+	`CREATE TABLE IF NOT EXISTS conversations (
+		id SERIAL PRIMARY KEY,
+		is_group BOOLEAN NOT NULL DEFAULT FALSE,
+		title TEXT NOT NULL DEFAULT '',
+		direct_key TEXT UNIQUE,
+		created_by INT REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		last_message_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS conversation_members (
+		conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role TEXT NOT NULL DEFAULT 'member',
+		joined_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		last_read_message_id BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (conversation_id, user_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS conversation_members_user_idx ON conversation_members (user_id)`,
+	`CREATE TABLE IF NOT EXISTS messages (
+		id BIGSERIAL PRIMARY KEY,
+		conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+		sender_id INT REFERENCES users(id) ON DELETE SET NULL,
+		kind TEXT NOT NULL DEFAULT 'text',
+		body TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS messages_conversation_idx ON messages (conversation_id, id)`,
+	`CREATE TABLE IF NOT EXISTS message_deletions (
+		message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		PRIMARY KEY (message_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS realtime_events (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		payload JSONB NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS realtime_events_user_idx ON realtime_events (user_id, id)`,
+	`CREATE INDEX IF NOT EXISTS realtime_events_created_idx ON realtime_events (created_at)`,
+
+	// Центр уведомлений: группировка однотипных событий и реакции на посты
+	`ALTER TABLE notifications ADD COLUMN IF NOT EXISTS group_key TEXT`,
+	`CREATE INDEX IF NOT EXISTS notifications_unread_idx ON notifications (user_id) WHERE read_at IS NULL`,
+	`CREATE TABLE IF NOT EXISTS post_reactions (
+		post_id INT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		reaction TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (post_id, user_id)
+	)`,
+
+	// Почтовые уведомления и дайджесты
+	`CREATE TABLE IF NOT EXISTS email_preferences (
+		user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		immediate_kinds TEXT[] NOT NULL DEFAULT '{friend_added,comment}',
+		digest TEXT NOT NULL DEFAULT 'weekly',
+		last_digest_at TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS email_outbox (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		to_address TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		body_html TEXT NOT NULL,
+		body_text TEXT NOT NULL,
+		unsubscribe_url TEXT NOT NULL DEFAULT '',
+		attempts INT NOT NULL DEFAULT 0,
+		last_error TEXT,
+		next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		sent_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE sent_at IS NULL`,
+
+	// Push-уведомления: подписки устройств и очередь доставки
+	`CREATE TABLE IF NOT EXISTS push_subscriptions (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		endpoint TEXT NOT NULL UNIQUE,
+		p256dh TEXT NOT NULL,
+		auth TEXT NOT NULL,
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		last_used_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS push_subscriptions_user_idx ON push_subscriptions (user_id)`,
+	`CREATE TABLE IF NOT EXISTS push_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		subscription_id BIGINT NOT NULL REFERENCES push_subscriptions(id) ON DELETE CASCADE,
+		payload TEXT NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		last_error TEXT,
+		next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS push_deliveries_pending_idx ON push_deliveries (next_attempt_at)`,
+
+	// Присутствие: время последнего действия, последнего сигнала подключения и настройка видимости
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS last_heartbeat_at TIMESTAMP`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS hide_presence BOOLEAN NOT NULL DEFAULT FALSE`,
+
+	// Чёрный список и рекомендации друзей
+	`CREATE TABLE IF NOT EXISTS user_blocks (
+		blocker_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		blocked_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (blocker_id, blocked_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS user_blocks_blocked_idx ON user_blocks (blocked_id)`,
+	`CREATE TABLE IF NOT EXISTS friend_suggestion_dismissals (
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		suggested_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		dismissed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (user_id, suggested_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS friend_suggestions (
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		suggested_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		mutual_friends INT NOT NULL DEFAULT 0,
+		shared_groups INT NOT NULL DEFAULT 0,
+		interactions INT NOT NULL DEFAULT 0,
+		score INT NOT NULL,
+		PRIMARY KEY (user_id, suggested_id)
+	)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS suggestions_computed_at TIMESTAMP`,
+
+	// Односторонние подписки на пользователей
+	`CREATE TABLE IF NOT EXISTS follows (
+		follower_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		followee_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (follower_id, followee_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS follows_followee_idx ON follows (followee_id)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS accept_followers BOOLEAN NOT NULL DEFAULT FALSE`,
+
+	// Списки друзей и аудитория постов. Существующие посты остаются видны всем
+	`CREATE TABLE IF NOT EXISTS friend_lists (
+		id SERIAL PRIMARY KEY,
+		owner_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		UNIQUE (owner_id, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS friend_list_members (
+		list_id INT NOT NULL REFERENCES friend_lists(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		PRIMARY KEY (list_id, user_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS friend_list_members_user_idx ON friend_list_members (user_id)`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS audience TEXT NOT NULL DEFAULT 'public'`,
+	`CREATE TABLE IF NOT EXISTS post_audience_lists (
+		post_id INT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		list_id INT NOT NULL REFERENCES friend_lists(id) ON DELETE CASCADE,
+		PRIMARY KEY (post_id, list_id)
+	)`,
+
+	// Настройки приватности
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS search_visibility TEXT NOT NULL DEFAULT 'everyone'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS friends_visibility TEXT NOT NULL DEFAULT 'everyone'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS friend_requests TEXT NOT NULL DEFAULT 'everyone'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS default_audience TEXT NOT NULL DEFAULT 'public'`,
+
+	// Режим ленты: новые или интересные посты
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS feed_mode TEXT NOT NULL DEFAULT 'latest'`,
+
+	// Материализованные ленты пользователей
+	`CREATE TABLE IF NOT EXISTS timelines (
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		post_id INT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		author_id INT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, post_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS timelines_user_created_idx ON timelines (user_id, created_at DESC)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS fanout_on_read BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS timeline_built_at TIMESTAMP`,
+
+	// Теги постов и популярные теги
+	`CREATE TABLE IF NOT EXISTS tags (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE
+	)`,
+	`CREATE TABLE IF NOT EXISTS post_tags (
+		post_id INT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		tag_id INT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (post_id, tag_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS post_tags_tag_created_idx ON post_tags (tag_id, created_at DESC)`,
+	`CREATE INDEX IF NOT EXISTS post_tags_created_idx ON post_tags (created_at)`,
+	`CREATE TABLE IF NOT EXISTS trending_tags (
+		tag_id INT PRIMARY KEY REFERENCES tags(id) ON DELETE CASCADE,
+		score DOUBLE PRECISION NOT NULL,
+		posts INT NOT NULL
+	)`,
+
+	// Упоминания пользователей в постах и комментариях
+	`CREATE TABLE IF NOT EXISTS post_mentions (
+		post_id INT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		PRIMARY KEY (post_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS comment_mentions (
+		comment_id INT NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		PRIMARY KEY (comment_id, user_id)
+	)`,
+
+	// Редактирование постов
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP`,
+
+	// Превью ссылок
+	`CREATE TABLE IF NOT EXISTS link_previews (
+		url TEXT PRIMARY KEY,
+		status TEXT NOT NULL DEFAULT 'pending',
+		title TEXT,
+		description TEXT,
+		site_name TEXT,
+		image_path TEXT,
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		fetched_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS link_previews_pending_idx ON link_previews (next_attempt_at) WHERE status = 'pending'`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS link_url TEXT`,
+	`CREATE INDEX IF NOT EXISTS posts_link_url_idx ON posts (link_url) WHERE link_url IS NOT NULL`,
+
+	// Вложения постов: несколько изображений и видео по порядку. Единственная
+	// картинка из posts.image_url переносится первым вложением
+	`CREATE TABLE IF NOT EXISTS post_attachments (
+		id SERIAL PRIMARY KEY,
+		post_id INT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		position INT NOT NULL,
+		kind TEXT NOT NULL,
+		path TEXT NOT NULL UNIQUE,
+		alt_text TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		UNIQUE (post_id, position)
+	)`,
+	`INSERT INTO post_attachments (post_id, position, kind, path, created_at)
+	 SELECT id, 0, 'image', image_url, created_at FROM posts
+	 WHERE image_url IS NOT NULL AND image_url <> ''
+	 ON CONFLICT DO NOTHING`,
+	`UPDATE posts SET image_url = NULL WHERE image_url IS NOT NULL`,
+
+	// Порядковые номера событий реального времени у каждого пользователя свои.
+	// Номер выдаётся под блокировкой строки счётчика, поэтому события пользователя
+	// становятся видны в порядке номеров. Счётчики существующих пользователей
+	// начинаются с последнего ID события, чтобы номера у клиентов не пошли назад
+	`CREATE TABLE IF NOT EXISTS realtime_sequences (
+		user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		seq BIGINT NOT NULL
+	)`,
+	`ALTER TABLE realtime_events ADD COLUMN IF NOT EXISTS seq BIGINT`,
+	`DO $$ BEGIN
+		IF EXISTS (SELECT 1 FROM realtime_events WHERE seq IS NULL) THEN
+			UPDATE realtime_events SET seq = id WHERE seq IS NULL;
+			INSERT INTO realtime_sequences (user_id, seq)
+			SELECT id, (SELECT COALESCE(MAX(id), 0) FROM realtime_events) FROM users
+			ON CONFLICT DO NOTHING;
+		END IF;
+	END $$`,
+	`ALTER TABLE realtime_events ALTER COLUMN seq SET NOT NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS realtime_events_user_seq_idx ON realtime_events (user_id, seq)`,
+	`DROP INDEX IF EXISTS realtime_events_user_idx`,
+
+	// Секреты, которые создаются при первом запуске, если не заданы в конфигурации
+	`CREATE TABLE IF NOT EXISTS app_secrets (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+
+	// Поиск пользователей по началу имени для автодополнения упоминаний
+	`CREATE INDEX IF NOT EXISTS users_username_prefix_idx ON users (LOWER(username) text_pattern_ops)`,
+
+	// Письма об упоминаниях включены по умолчанию. Строки, созданные отметкой дайджеста
+	// со старым значением по умолчанию, получают их один раз — пока не сменилось значение столбца
+	`DO $$ BEGIN
+		IF (SELECT column_default FROM information_schema.columns
+			WHERE table_name = 'email_preferences' AND column_name = 'immediate_kinds') NOT LIKE '%mention%' THEN
+			UPDATE email_preferences SET immediate_kinds = '{friend_added,mention,comment}'
+			WHERE immediate_kinds = '{friend_added,comment}';
+			ALTER TABLE email_preferences ALTER COLUMN immediate_kinds SET DEFAULT '{friend_added,mention,comment}';
+		END IF;
+	END $$`,
+}
+
+// migrateDB применяет все миграции схемы по порядку
+func migrateDB() {
+	for _, query := range schemaMigrations {
+		if _, err := DB.Exec(query); err != nil {
+			log.Fatal("Ошибка миграции БД:", err)
+		}
+	}
+}
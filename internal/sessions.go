@@ -2,8 +2,11 @@
 package internal
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/gorilla/sessions"
 )
@@ -11,17 +14,128 @@ import (
 // Инициализируем хранилище сессий с секретным ключом
 var store = sessions.NewCookieStore([]byte("4kukTPS-gIf-fW2D-QMZjWflvbcHvz50fnJKBszXfAA"))
 
-// GetUserIDFromSession возвращает ID пользователя из сессии
-func GetUserIDFromSession(r *http.Request) (int, error) {
+// sessionUser — пользователь из сессии и состояние его аккаунта
+type sessionUser struct {
+	ID        int
+	Suspended bool
+}
+
+// sessionCacheKey — ключ контекста запроса, под которым хранится sessionCache
+type sessionCacheKey struct{}
+
+// sessionCache хранит пользователя сессии, чтобы за один запрос
+// состояние аккаунта загружалось из БД не больше одного раза
+type sessionCache struct {
+	sync.Mutex
+	loaded bool
+	user   sessionUser
+	err    error
+}
+
+// withSessionCache добавляет в контекст запроса пустой sessionCache
+func withSessionCache(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), sessionCacheKey{}, &sessionCache{}))
+}
+
+// loadSessionUser возвращает пользователя из сессии. Сессии удалённых аккаунтов
+// больше не действительны. Внутри RequireActiveUser результат запоминается на весь запрос
+func loadSessionUser(r *http.Request) (sessionUser, error) {
+	cache, ok := r.Context().Value(sessionCacheKey{}).(*sessionCache)
+	if !ok {
+		return querySessionUser(r)
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	if !cache.loaded {
+		cache.user, cache.err = querySessionUser(r)
+		cache.loaded = true
+	}
+	return cache.user, cache.err
+}
+
+// forgetSessionUser сбрасывает запомненного пользователя после входа или выхода
+func forgetSessionUser(r *http.Request) {
+	if cache, ok := r.Context().Value(sessionCacheKey{}).(*sessionCache); ok {
+		cache.Lock()
+		cache.loaded = false
+		cache.Unlock()
+	}
+}
+
+// querySessionUser загружает пользователя из сессии и состояние его аккаунта
+func querySessionUser(r *http.Request) (sessionUser, error) {
 	session, err := store.Get(r, "session-name")
 	if err != nil {
-		return 0, err
+		return sessionUser{}, err
 	}
 	userID, ok := session.Values["userID"].(int)
 	if !ok {
-		return 0, errors.New("пользователь не авторизован")
+		return sessionUser{}, errors.New("пользователь не авторизован")
 	}
-	return userID, nil
+
+	user := sessionUser{ID: userID}
+	var deleted bool
+	err = DB.QueryRow(`
+		SELECT deleted_at IS NOT NULL, COALESCE(suspended_until > NOW(), FALSE) FROM users WHERE id = $1
+	`, userID).Scan(&deleted, &user.Suspended)
+	if err != nil || deleted {
+		return sessionUser{}, errors.New("пользователь не авторизован")
+	}
+	return user, nil
+}
+
+// GetUserIDFromSession возвращает ID пользователя из сессии
+func GetUserIDFromSession(r *http.Request) (int, error) {
+	user, err := loadSessionUser(r)
+	if err != nil {
+		return 0, err
+	}
+
+	// Любой запрос авторизованного пользователя считается активностью
+	TouchActivity(user.ID)
+	return user.ID, nil
+}
+
+// suspendedAllowedPaths — действия, доступные заблокированному пользователю:
+// выход, настройки и удаление аккаунта, выгрузка данных и защита от других пользователей
+var suspendedAllowedPaths = []string{
+	"/login",
+	"/logout",
+	"/change-password",
+	"/account",
+	"/settings/",
+	"/notifications/read",
+	"/email/unsubscribe",
+	"/push/subscription",
+	"/block",
+}
+
+// suspendedAllowed проверяет, доступно ли действие заблокированному пользователю
+func suspendedAllowed(path string) bool {
+	for _, allowed := range suspendedAllowedPaths {
+		if path == allowed || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(path, allowed)) ||
+			strings.HasPrefix(path, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireActiveUser не даёт заблокированным пользователям изменять данные: любые запросы,
+// кроме GET и HEAD, отклоняются, пока действует блокировка. Проверка выполняется
+// для всех обработчиков сразу, поэтому её нельзя забыть в новом обработчике.
+// Загруженный пользователь запоминается и переиспользуется обработчиком
+func RequireActiveUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withSessionCache(r)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !suspendedAllowed(r.URL.Path) {
+			if user, err := loadSessionUser(r); err == nil && user.Suspended {
+				http.Error(w, "Ваш аккаунт заблокирован", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // SetUserIDInSession сохраняет ID пользователя в сессии
@@ -31,5 +145,6 @@ func SetUserIDInSession(w http.ResponseWriter, r *http.Request, userID int) erro
 		return err
 	}
 	session.Values["userID"] = userID
+	forgetSessionUser(r)
 	return session.Save(r, w)
 }
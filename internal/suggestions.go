@@ -0,0 +1,354 @@
+// internal/suggestions.go
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Параметры рекомендаций друзей
+const (
+	suggestionsLimit      = 20             // сколько рекомендаций хранится для пользователя
+	suggestionsTTL        = 24 * time.Hour // через сколько рекомендации пересчитываются
+	suggestionsBatchSize  = 200            // сколько пользователей пересчитывает фоновая задача за раз
+	suggestionsActiveDays = 30             // пересчитываются только недавно заходившие пользователи
+)
+
+var ErrUserBlocked = errors.New("пользователь в чёрном списке")
+
+// Suggestion — рекомендованный пользователь и причины рекомендации
+type Suggestion struct {
+	ID            int
+	Username      string
+	AvatarURL     string
+	MutualFriends int
+	SharedGroups  int
+	Interactions  int
+}
+
+// suggestionsQuery ранжирует пользователей, которых $1 может знать: общие друзья весят
+// больше всего, затем общие групповые чаты и взаимодействия за последние 90 дней.
+// Друзья, пользователи из чёрного списка в любую сторону и отклонённые рекомендации исключаются
+const suggestionsQuery = `
+	WITH my_friends AS (
+		SELECT friend_id AS id FROM friendships WHERE user_id = $1
+		UNION
+		SELECT user_id FROM friendships WHERE friend_id = $1
+	),
+	mutual AS (
+		SELECT id, COUNT(DISTINCT via) AS n
+		FROM (
+			SELECT f.friend_id AS id, f.user_id AS via FROM friendships f JOIN my_friends mf ON mf.id = f.user_id
+			UNION ALL
+			SELECT f.user_id, f.friend_id FROM friendships f JOIN my_friends mf ON mf.id = f.friend_id
+		) fof
+		GROUP BY id
+	),
+	shared_chats AS (
+		SELECT other.user_id AS id, COUNT(*) AS n
+		FROM conversation_members me
+		JOIN conversations c ON c.id = me.conversation_id AND c.is_group
+		JOIN conversation_members other ON other.conversation_id = me.conversation_id
+		WHERE me.user_id = $1
+		GROUP BY other.user_id
+	),
+	interactions AS (
+		SELECT id, COUNT(*) AS n
+		FROM (
+			SELECT c.user_id AS id FROM comments c JOIN posts p ON p.id = c.post_id
+			WHERE p.user_id = $1 AND c.created_at > NOW() - INTERVAL '90 days'
+			UNION ALL
+			SELECT p.user_id FROM comments c JOIN posts p ON p.id = c.post_id
+			WHERE c.user_id = $1 AND c.created_at > NOW() - INTERVAL '90 days'
+			UNION ALL
+			SELECT r.user_id FROM post_reactions r JOIN posts p ON p.id = r.post_id
+			WHERE p.user_id = $1 AND r.created_at > NOW() - INTERVAL '90 days'
+			UNION ALL
+			SELECT p.user_id FROM post_reactions r JOIN posts p ON p.id = r.post_id
+			WHERE r.user_id = $1 AND r.created_at > NOW() - INTERVAL '90 days'
+		) i
+		GROUP BY id
+	),
+	signals AS (
+		SELECT id, SUM(mutual) AS mutual, SUM(chats) AS chats, SUM(interactions) AS interactions
+		FROM (
+			SELECT id, n AS mutual, 0 AS chats, 0 AS interactions FROM mutual
+			UNION ALL
+			SELECT id, 0, n, 0 FROM shared_chats
+			UNION ALL
+			SELECT id, 0, 0, n FROM interactions
+		) s
+		GROUP BY id
+	)
+	SELECT s.id, s.mutual, s.chats, s.interactions,
+	       s.mutual * 3 + s.chats * 2 + LEAST(s.interactions, 10) AS score
+	FROM signals s
+	JOIN users u ON u.id = s.id
+	WHERE s.id != $1 AND u.deleted_at IS NULL
+	  AND s.id NOT IN (SELECT id FROM my_friends)
+	  AND NOT EXISTS (
+		SELECT 1 FROM user_blocks b
+		WHERE (b.blocker_id = $1 AND b.blocked_id = s.id) OR (b.blocker_id = s.id AND b.blocked_id = $1)
+	  )
+	  AND NOT EXISTS (
+		SELECT 1 FROM friend_suggestion_dismissals d WHERE d.user_id = $1 AND d.suggested_id = s.id
+	  )
+	ORDER BY score DESC, s.mutual DESC, s.id
+	LIMIT $2`
+
+// refreshSuggestions пересчитывает сохранённые рекомендации пользователя
+func refreshSuggestions(userID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM friend_suggestions WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO friend_suggestions (user_id, suggested_id, mutual_friends, shared_groups, interactions, score)
+		SELECT $1, ranked.* FROM (`+suggestionsQuery+`) ranked
+	`, userID, suggestionsLimit)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET suggestions_computed_at = NOW() WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FriendSuggestions возвращает рекомендации друзей. Если сохранённые рекомендации
+// устарели или ещё не считались, они пересчитываются сразу.
+// Дружба, чёрный список, отклонения и настройки поиска проверяются при чтении
+func FriendSuggestions(userID, limit int) ([]Suggestion, error) {
+	var computedAt sql.NullTime
+	err := DB.QueryRow(`SELECT suggestions_computed_at FROM users WHERE id = $1`, userID).Scan(&computedAt)
+	if err != nil {
+		return nil, err
+	}
+	if !computedAt.Valid || time.Since(computedAt.Time) > suggestionsTTL {
+		if err := refreshSuggestions(userID); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := DB.Query(`
+		SELECT u.id, u.username, COALESCE(u.avatar_url, '/static/avatar.jpg'),
+		       s.mutual_friends, s.shared_groups, s.interactions
+		FROM friend_suggestions s
+		JOIN users u ON u.id = s.suggested_id
+		WHERE s.user_id = $1 AND u.deleted_at IS NULL
+		  AND `+privacyCondition("u."+privacySearch, "u.id", "$1")+`
+		  AND NOT EXISTS (
+			SELECT 1 FROM friendships f
+			WHERE (f.user_id = $1 AND f.friend_id = s.suggested_id) OR (f.user_id = s.suggested_id AND f.friend_id = $1)
+		  )
+		  AND NOT EXISTS (
+			SELECT 1 FROM user_blocks b
+			WHERE (b.blocker_id = $1 AND b.blocked_id = s.suggested_id) OR (b.blocker_id = s.suggested_id AND b.blocked_id = $1)
+		  )
+		  AND NOT EXISTS (
+			SELECT 1 FROM friend_suggestion_dismissals d WHERE d.user_id = $1 AND d.suggested_id = s.suggested_id
+		  )
+		ORDER BY s.score DESC, s.mutual_friends DESC, s.suggested_id
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suggestions []Suggestion
+	for rows.Next() {
+		var s Suggestion
+		if err := rows.Scan(&s.ID, &s.Username, &s.AvatarURL, &s.MutualFriends, &s.SharedGroups, &s.Interactions); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, rows.Err()
+}
+
+// refreshStaleSuggestions заранее пересчитывает рекомендации активных пользователей,
+// чтобы на больших графах страница поиска друзей не ждала тяжёлого запроса
+func refreshStaleSuggestions() error {
+	rows, err := DB.Query(`
+		SELECT id FROM users
+		WHERE deleted_at IS NULL
+		  AND last_seen_at > NOW() - $1 * INTERVAL '1 day'
+		  AND (suggestions_computed_at IS NULL OR suggestions_computed_at < NOW() - $2 * INTERVAL '1 second')
+		ORDER BY suggestions_computed_at NULLS FIRST
+		LIMIT $3
+	`, suggestionsActiveDays, int(suggestionsTTL.Seconds()), suggestionsBatchSize)
+	if err != nil {
+		return err
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range userIDs {
+		if err := refreshSuggestions(id); err != nil {
+			log.Printf("Ошибка при пересчёте рекомендаций пользователя %d: %v\n", id, err)
+		}
+	}
+	return nil
+}
+
+// DismissSuggestion запоминает, что пользователь не хочет видеть эту рекомендацию
+func DismissSuggestion(userID, suggestedID int) error {
+	_, err := DB.Exec(`
+		INSERT INTO friend_suggestion_dismissals (user_id, suggested_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, userID, suggestedID)
+	return err
+}
+
+// IsBlocked проверяет, внёс ли кто-то из пользователей другого в чёрный список
+func IsBlocked(userID, otherID int) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		)
+	`, userID, otherID).Scan(&exists)
+	return exists, err
+}
+
+// HasBlocked проверяет, внёс ли пользователь другого в свой чёрный список
+func HasBlocked(userID, otherID int) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2)
+	`, userID, otherID).Scan(&exists)
+	return exists, err
+}
+
+// BlockUser вносит пользователя в чёрный список, разрывает дружбу и подписки между ними
+func BlockUser(userID, otherID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO user_blocks (blocker_id, blocked_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, userID, otherID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		DELETE FROM friendships
+		WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)
+	`, userID, otherID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		DELETE FROM follows
+		WHERE (follower_id = $1 AND followee_id = $2) OR (follower_id = $2 AND followee_id = $1)
+	`, userID, otherID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := pruneTimeline(userID, otherID); err != nil {
+		return err
+	}
+	return pruneTimeline(otherID, userID)
+}
+
+// UnblockUser убирает пользователя из чёрного списка
+func UnblockUser(userID, otherID int) error {
+	_, err := DB.Exec(`DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2`, userID, otherID)
+	return err
+}
+
+// SuggestionDismissHandler скрывает рекомендацию друга
+func SuggestionDismissHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	suggestedID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil || suggestedID <= 0 {
+		http.Error(w, "Некорректный ID пользователя", http.StatusBadRequest)
+		return
+	}
+
+	if err := DismissSuggestion(userID, suggestedID); err != nil {
+		log.Println("Ошибка при скрытии рекомендации:", err)
+		http.Error(w, "Не удалось скрыть рекомендацию", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, "/find-friends")
+}
+
+// BlockHandler вносит пользователя в чёрный список или убирает из него
+func BlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	otherID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil || otherID <= 0 || otherID == userID {
+		http.Error(w, "Некорректный ID пользователя", http.StatusBadRequest)
+		return
+	}
+
+	action := AuditUserBlock
+	if r.FormValue("action") == "unblock" {
+		action = AuditUserUnblock
+		err = UnblockUser(userID, otherID)
+	} else {
+		err = BlockUser(userID, otherID)
+	}
+	if err != nil {
+		log.Println("Ошибка при изменении чёрного списка:", err)
+		http.Error(w, "Не удалось изменить чёрный список", http.StatusInternalServerError)
+		return
+	}
+	Audit(r, userID, action, "user", otherID, "")
+
+	http.Redirect(w, r, "/profile?id="+strconv.Itoa(otherID), http.StatusSeeOther)
+}
@@ -0,0 +1,266 @@
+// internal/timeline.go
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// timelineBackfillLimit — сколько последних постов автора попадает в ленту при новой дружбе или подписке
+	timelineBackfillLimit = 100
+	// timelineRebuildLimit — сколько постов попадает в ленту при её полной пересборке
+	timelineRebuildLimit = 500
+	// timelineMaxEntries — сколько записей хранится в ленте пользователя, остальные удаляются фоновой задачей
+	timelineMaxEntries = 1000
+)
+
+var ErrNotPostAuthor = errors.New("удалить пост может только его автор")
+
+// Лента хранится в таблице timelines: при публикации пост сразу раскладывается
+// по лентам читателей автора (fan-out on write). Посты авторов с очень большим
+// числом читателей не раскладываются — они помечаются fanout_on_read и
+// подмешиваются в ленту при чтении. Видимость и модерация проверяются при чтении,
+// поэтому лишняя запись в ленте не раскрывает пост
+
+// timelinePostsQuery выбирает ID постов ленты пользователя $1: материализованную
+// ленту и посты авторов с большим числом читателей
+const timelinePostsQuery = `
+	SELECT post_id FROM timelines WHERE user_id = $1
+	UNION ALL
+	SELECT fp.id FROM posts fp
+	JOIN users fa ON fa.id = fp.user_id AND fa.fanout_on_read
+	WHERE fp.user_id IN (` + feedAuthorsQuery + `)`
+
+// fanOutPost раскладывает пост по лентам. Автор всегда видит свой пост,
+// включая задержанный фильтром; остальные читатели — только опубликованный
+func fanOutPost(postID, authorID int) error {
+	_, err := DB.Exec(`
+		INSERT INTO timelines (user_id, post_id, author_id, created_at)
+		SELECT user_id, id, user_id, created_at FROM posts WHERE id = $1
+		ON CONFLICT DO NOTHING
+	`, postID)
+	if err != nil {
+		return err
+	}
+
+	var held, fanoutOnRead bool
+	var readers int
+	err = DB.QueryRow(`
+		SELECT p.held_at IS NOT NULL, u.fanout_on_read, (SELECT COUNT(*) FROM (`+feedReadersQuery+`) r)
+		FROM posts p JOIN users u ON u.id = p.user_id
+		WHERE p.id = $2
+	`, authorID, postID).Scan(&held, &fanoutOnRead, &readers)
+	if err != nil {
+		return err
+	}
+	if held || fanoutOnRead {
+		return nil
+	}
+
+	// Автор с большим числом читателей переходит на чтение по запросу.
+	// Обратно флаг снимает только пересборка лент, чтобы не потерять старые посты
+	if readers > AppConfig.FanoutLimit() {
+		_, err := DB.Exec(`UPDATE users SET fanout_on_read = TRUE WHERE id = $1`, authorID)
+		return err
+	}
+
+	_, err = DB.Exec(`
+		INSERT INTO timelines (user_id, post_id, author_id, created_at)
+		SELECT r.id, p.id, p.user_id, p.created_at
+		FROM (`+feedReadersQuery+`) r(id)
+		JOIN posts p ON p.id = $2
+		WHERE `+visiblePostCondition("r.id")+`
+		ON CONFLICT DO NOTHING
+	`, authorID, postID)
+	return err
+}
+
+// backfillTimeline добавляет в ленту пользователя последние посты автора,
+// например после добавления в друзья или подписки
+func backfillTimeline(userID, authorID int) error {
+	_, err := DB.Exec(`
+		INSERT INTO timelines (user_id, post_id, author_id, created_at)
+		SELECT $1, p.id, p.user_id, p.created_at
+		FROM posts p
+		JOIN users u ON u.id = p.user_id AND NOT u.fanout_on_read
+		WHERE p.user_id = $2 AND p.hidden_at IS NULL AND p.held_at IS NULL
+		  AND `+visiblePostCondition("$1")+`
+		ORDER BY p.created_at DESC
+		LIMIT $3
+		ON CONFLICT DO NOTHING
+	`, userID, authorID, timelineBackfillLimit)
+	return err
+}
+
+// pruneTimeline убирает из ленты пользователя посты автора, если тот больше
+// не входит в число его друзей и подписок
+func pruneTimeline(userID, authorID int) error {
+	_, err := DB.Exec(`
+		DELETE FROM timelines
+		WHERE user_id = $1 AND author_id = $2 AND author_id NOT IN (`+feedAuthorsQuery+`)
+	`, userID, authorID)
+	return err
+}
+
+// RebuildTimeline пересобирает ленту пользователя из постов его друзей и подписок
+func RebuildTimeline(userID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM timelines WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO timelines (user_id, post_id, author_id, created_at)
+		SELECT $1, p.id, p.user_id, p.created_at
+		FROM posts p
+		JOIN users u ON u.id = p.user_id AND (NOT u.fanout_on_read OR u.id = $1)
+		WHERE p.hidden_at IS NULL
+		  AND (p.user_id = $1 OR (p.held_at IS NULL AND p.user_id IN (`+feedAuthorsQuery+`)))
+		  AND `+visiblePostCondition("$1")+`
+		ORDER BY p.created_at DESC
+		LIMIT $2
+	`, userID, timelineRebuildLimit)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET timeline_built_at = NOW() WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureTimeline собирает ленту пользователя, если она ещё ни разу не собиралась
+func ensureTimeline(userID int) error {
+	var builtAt sql.NullTime
+	if err := DB.QueryRow(`SELECT timeline_built_at FROM users WHERE id = $1`, userID).Scan(&builtAt); err != nil {
+		return err
+	}
+	if builtAt.Valid {
+		return nil
+	}
+	return RebuildTimeline(userID)
+}
+
+// RebuildAllTimelines пересчитывает, каких авторов читать по запросу,
+// и пересобирает ленты всех пользователей
+func RebuildAllTimelines() error {
+	_, err := DB.Exec(`
+		UPDATE users u
+		SET fanout_on_read = (
+			SELECT COUNT(*) FROM (
+				SELECT user_id FROM friendships WHERE friend_id = u.id
+				UNION
+				SELECT friend_id FROM friendships WHERE user_id = u.id
+				UNION
+				SELECT follower_id FROM follows WHERE followee_id = u.id
+			) r
+		) > $1
+	`, AppConfig.FanoutLimit())
+	if err != nil {
+		return err
+	}
+
+	rows, err := DB.Query(`SELECT id FROM users WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, id := range userIDs {
+		if err := RebuildTimeline(id); err != nil {
+			return err
+		}
+		if (i+1)%1000 == 0 {
+			log.Printf("Пересобрано лент: %d из %d\n", i+1, len(userIDs))
+		}
+	}
+	return nil
+}
+
+// trimTimelines удаляет из лент записи сверх timelineMaxEntries
+func trimTimelines() error {
+	_, err := DB.Exec(`
+		DELETE FROM timelines t
+		USING (
+			SELECT user_id, post_id,
+			       ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY created_at DESC) AS position
+			FROM timelines
+		) ranked
+		WHERE t.user_id = ranked.user_id AND t.post_id = ranked.post_id AND ranked.position > $1
+	`, timelineMaxEntries)
+	return err
+}
+
+// DeletePost удаляет пост автора вместе с комментариями, реакциями и записями в лентах
+func DeletePost(postID, userID int) error {
+	attachments, err := ListPostAttachments(postID)
+	if err != nil {
+		return err
+	}
+
+	result, err := DB.Exec(`DELETE FROM posts WHERE id = $1 AND user_id = $2`, postID, userID)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return ErrNotPostAuthor
+	}
+
+	removeAttachmentFiles(attachments)
+	return nil
+}
+
+// DeletePostHandler удаляет пост текущего пользователя
+func DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil || postID <= 0 {
+		http.Error(w, "Некорректный ID поста", http.StatusBadRequest)
+		return
+	}
+
+	err = DeletePost(postID, userID)
+	if err == ErrNotPostAuthor {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при удалении поста:", err)
+		http.Error(w, "Не удалось удалить пост", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, "/profile")
+}
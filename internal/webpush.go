@@ -0,0 +1,468 @@
+// internal/webpush.go
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// pushRecordSize — размер записи aes128gcm. Всё сообщение помещается в одну запись
+	pushRecordSize = 4096
+	// pushMaxPayload — максимальный размер открытого текста. Сервисы доставки принимают
+	// не больше 4096 байт тела, из них 86 занимает заголовок, 16 — тег GCM и 1 — разделитель
+	pushMaxPayload = pushRecordSize - 86 - 16 - 1
+	// pushTTL — сколько сервис доставки хранит сообщение, если браузер не в сети
+	pushTTL = 24 * time.Hour
+	// pushMaxAttempts — сколько раз пытаемся доставить сообщение
+	pushMaxAttempts = 5
+	// pushBodyLength — длина текста уведомления
+	pushBodyLength = 200
+)
+
+var (
+	ErrPushSubscriptionInvalid = errors.New("некорректная push-подписка")
+	// errPushSubscriptionGone возвращается, если сервис доставки сообщил, что подписки больше нет
+	errPushSubscriptionGone = errors.New("push-подписка больше не существует")
+	// errPushRejected возвращается, если повторная отправка не поможет
+	errPushRejected = errors.New("сервис доставки отклонил сообщение")
+)
+
+// vapidKeys — ключи сервера для подписи запросов к сервисам доставки (RFC 8292)
+type vapidKeys struct {
+	private   *ecdsa.PrivateKey
+	publicB64 string
+	subject   string
+}
+
+var vapid *vapidKeys
+
+var pushClient = &http.Client{Timeout: 30 * time.Second}
+
+// InitWebPush загружает VAPID-ключи из конфигурации. Если ключей нет,
+// создаются новые и выводятся в лог: их нужно сохранить в config.json,
+// иначе после перезапуска существующие подписки перестанут работать
+func InitWebPush() {
+	privateKey := AppConfig.VAPIDPrivateKey
+	if privateKey == "" {
+		key, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal("Ошибка при создании VAPID-ключей:", err)
+		}
+		privateKey = base64.RawURLEncoding.EncodeToString(key.Bytes())
+		log.Printf("VAPID-ключи не заданы, созданы новые. Добавьте в config.json:\n"+
+			"\"VAPIDPublicKey\": %q,\n\"VAPIDPrivateKey\": %q\n",
+			base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), privateKey)
+	}
+
+	keys, err := parseVAPIDKeys(privateKey)
+	if err != nil {
+		log.Fatal("Некорректный VAPIDPrivateKey:", err)
+	}
+	if AppConfig.VAPIDPublicKey != "" && AppConfig.VAPIDPublicKey != keys.publicB64 {
+		log.Fatal("VAPIDPublicKey не соответствует VAPIDPrivateKey")
+	}
+	keys.subject = AppConfig.VAPIDSubject
+	if keys.subject == "" {
+		keys.subject = siteURL("")
+	}
+	vapid = keys
+}
+
+// parseVAPIDKeys восстанавливает пару ключей P-256 из закрытого ключа в base64url
+func parseVAPIDKeys(privateKeyB64 string) (*vapidKeys, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(privateKeyB64, "="))
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	public := key.PublicKey().Bytes()
+	private := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(public[1:33]),
+			Y:     new(big.Int).SetBytes(public[33:65]),
+		},
+		D: new(big.Int).SetBytes(raw),
+	}
+	return &vapidKeys{private: private, publicB64: base64.RawURLEncoding.EncodeToString(public)}, nil
+}
+
+// authorization возвращает заголовок Authorization для сервиса доставки
+func (k *vapidKeys) authorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": k.subject,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(k.private)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + token + ", k=" + k.publicB64, nil
+}
+
+// PushSubscription — подписка браузера на push-уведомления
+type PushSubscription struct {
+	ID       int64
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+// decodeBase64URL декодирует ключи подписки: браузеры отдают base64url без выравнивания
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// validatePushEndpoint проверяет адрес сервиса доставки. Разрешён только HTTPS,
+// обычный HTTP — лишь для локального адреса, чтобы можно было проверить доставку без браузера
+func validatePushEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return ErrPushSubscriptionInvalid
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	}
+	return ErrPushSubscriptionInvalid
+}
+
+// SavePushSubscription сохраняет подписку устройства пользователя
+func SavePushSubscription(userID int, sub PushSubscription, userAgent string) error {
+	if err := validatePushEndpoint(sub.Endpoint); err != nil {
+		return err
+	}
+	p256dh, err := decodeBase64URL(sub.P256dh)
+	if err != nil {
+		return ErrPushSubscriptionInvalid
+	}
+	if _, err := ecdh.P256().NewPublicKey(p256dh); err != nil {
+		return ErrPushSubscriptionInvalid
+	}
+	if auth, err := decodeBase64URL(sub.Auth); err != nil || len(auth) != 16 {
+		return ErrPushSubscriptionInvalid
+	}
+
+	// Один и тот же браузер может перейти к другому пользователю после повторного входа
+	_, err = DB.Exec(`
+		INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth, user_agent)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (endpoint) DO UPDATE
+		SET user_id = EXCLUDED.user_id, p256dh = EXCLUDED.p256dh, auth = EXCLUDED.auth,
+		    user_agent = EXCLUDED.user_agent, created_at = NOW()
+	`, userID, sub.Endpoint, sub.P256dh, sub.Auth, userAgent)
+	return err
+}
+
+// DeletePushSubscription удаляет подписку устройства
+func DeletePushSubscription(userID int, endpoint string) error {
+	_, err := DB.Exec(`DELETE FROM push_subscriptions WHERE user_id = $1 AND endpoint = $2`, userID, endpoint)
+	return err
+}
+
+// pushMessage — содержимое push-уведомления, которое показывает сервис-воркер
+type pushMessage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+// queuePush ставит уведомление в очередь на все устройства пользователя.
+// Пока пользователь на сайте, уведомления приходят через WebSocket, а push не нужен
+func queuePush(userID int, message pushMessage) error {
+	if vapid == nil || (hub != nil && hub.hasSubscribers(userID)) {
+		return nil
+	}
+	if utf8.RuneCountInString(message.Body) > pushBodyLength {
+		message.Body = string([]rune(message.Body)[:pushBodyLength]) + "…"
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`
+		INSERT INTO push_deliveries (subscription_id, payload)
+		SELECT id, $2 FROM push_subscriptions WHERE user_id = $1
+	`, userID, string(payload))
+	return err
+}
+
+// encryptPushPayload шифрует сообщение для подписки по RFC 8291 (aes128gcm)
+func encryptPushPayload(sub PushSubscription, plaintext []byte) ([]byte, error) {
+	// Одноразовая пара ключей сервера и соль для этого сообщения
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return encryptPushRecord(sub, plaintext, asPrivate, salt)
+}
+
+// encryptPushRecord шифрует сообщение заданными ключом сервера и солью
+func encryptPushRecord(sub PushSubscription, plaintext []byte, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	if len(plaintext) > pushMaxPayload {
+		return nil, fmt.Errorf("push-сообщение слишком большое: %d байт", len(plaintext))
+	}
+	uaPublicBytes, err := decodeBase64URL(sub.P256dh)
+	if err != nil {
+		return nil, err
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicBytes)
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := decodeBase64URL(sub.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	asPublic := asPrivate.PublicKey().Bytes()
+	sharedSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	// IKM = HKDF(auth_secret, ecdh_secret, "WebPush: info" || 0x00 || ua_public || as_public)
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublicBytes...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, authSecret, keyInfo), ikm); err != nil {
+		return nil, err
+	}
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Заголовок: salt (16) || rs (4) || idlen (1) || keyid (открытый ключ сервера)
+	body := make([]byte, 0, 21+len(asPublic)+len(plaintext)+1+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, pushRecordSize)
+	body = append(body, byte(len(asPublic)))
+	body = append(body, asPublic...)
+
+	// Единственная запись завершается разделителем 0x02
+	record := append(append([]byte{}, plaintext...), 0x02)
+	return gcm.Seal(body, nonce, record, nil), nil
+}
+
+// sendPush отправляет зашифрованное сообщение в сервис доставки подписки
+func sendPush(sub PushSubscription, payload []byte) error {
+	body, err := encryptPushPayload(sub, payload)
+	if err != nil {
+		return err
+	}
+	authorization, err := vapid.authorization(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprint(int(pushTTL.Seconds())))
+	req.Header.Set("Urgency", "normal")
+
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errPushSubscriptionGone
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("сервис доставки ответил %s", resp.Status)
+	default:
+		return fmt.Errorf("%w: %s", errPushRejected, resp.Status)
+	}
+}
+
+// processPushDeliveries доставляет push-уведомления из очереди. Временные ошибки
+// повторяются с растущей задержкой, подписки, которых больше нет, удаляются
+func processPushDeliveries() error {
+	if vapid == nil {
+		return nil
+	}
+	for {
+		var deliveryID int64
+		var sub PushSubscription
+		var payload string
+		err := DB.QueryRow(`
+			UPDATE push_deliveries d
+			SET attempts = d.attempts + 1,
+			    next_attempt_at = NOW() + (d.attempts + 1) * (d.attempts + 1) * INTERVAL '1 minute'
+			FROM push_subscriptions s
+			WHERE s.id = d.subscription_id AND d.id = (
+				SELECT id FROM push_deliveries
+				WHERE attempts < $1 AND next_attempt_at <= NOW()
+				ORDER BY id
+				FOR UPDATE SKIP LOCKED
+				LIMIT 1
+			)
+			RETURNING d.id, d.payload, s.id, s.endpoint, s.p256dh, s.auth
+		`, pushMaxAttempts).Scan(&deliveryID, &payload, &sub.ID, &sub.Endpoint, &sub.P256dh, &sub.Auth)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		sendErr := sendPush(sub, []byte(payload))
+		switch {
+		case sendErr == nil:
+			_, err = DB.Exec(`DELETE FROM push_deliveries WHERE id = $1`, deliveryID)
+			if err == nil {
+				_, err = DB.Exec(`UPDATE push_subscriptions SET last_used_at = NOW() WHERE id = $1`, sub.ID)
+			}
+		case errors.Is(sendErr, errPushSubscriptionGone):
+			// Вместе с подпиской удаляется и её очередь
+			_, err = DB.Exec(`DELETE FROM push_subscriptions WHERE id = $1`, sub.ID)
+		case errors.Is(sendErr, errPushRejected):
+			log.Printf("Push-уведомление %d отклонено: %v\n", deliveryID, sendErr)
+			_, err = DB.Exec(`DELETE FROM push_deliveries WHERE id = $1`, deliveryID)
+		default:
+			log.Printf("Ошибка при доставке push-уведомления %d: %v\n", deliveryID, sendErr)
+			_, err = DB.Exec(`UPDATE push_deliveries SET last_error = $2 WHERE id = $1`, deliveryID, sendErr.Error())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// cleanupPushDeliveries удаляет сообщения, которые так и не удалось доставить
+func cleanupPushDeliveries() error {
+	_, err := DB.Exec(`
+		DELETE FROM push_deliveries
+		WHERE attempts >= $1 OR created_at < NOW() - $2 * INTERVAL '1 second'
+	`, pushMaxAttempts, pushTTL.Seconds())
+	return err
+}
+
+// PushKeyHandler отдаёт открытый VAPID-ключ для подписки в браузере
+func PushKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if vapid == nil {
+		http.Error(w, "Push-уведомления не настроены", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"publicKey": vapid.publicB64})
+}
+
+// PushSubscriptionHandler сохраняет (POST) или удаляет (DELETE) подписку браузера.
+// Тело запроса — результат PushSubscription.toJSON() в браузере
+func PushSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Вы не авторизованы", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		Endpoint string `json:"endpoint"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 8192)).Decode(&body); err != nil || body.Endpoint == "" {
+		http.Error(w, ErrPushSubscriptionInvalid.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		sub := PushSubscription{Endpoint: body.Endpoint, P256dh: body.Keys.P256dh, Auth: body.Keys.Auth}
+		err = SavePushSubscription(userID, sub, r.UserAgent())
+	case http.MethodDelete:
+		err = DeletePushSubscription(userID, body.Endpoint)
+	default:
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	if err == ErrPushSubscriptionInvalid {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при сохранении push-подписки:", err)
+		http.Error(w, "Ошибка при сохранении подписки", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServiceWorkerHandler отдаёт сервис-воркер из корня сайта, чтобы его область
+// действия охватывала все страницы
+func ServiceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, "web/static/sw.js")
+}
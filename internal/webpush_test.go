@@ -0,0 +1,303 @@
+// internal/webpush_test.go
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Пример шифрования из приложения A RFC 8291
+const (
+	rfc8291Plaintext   = "When I grow up, I want to be a watermelon"
+	rfc8291ASPrivate   = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	rfc8291ASPublic    = "BP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A8"
+	rfc8291UAPrivate   = "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"
+	rfc8291UAPublic    = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	rfc8291Salt        = "DGv6ra1nlYgDCS1FRnbzlw"
+	rfc8291AuthSecret  = "BTBZMqHH6r4Tts7J_aSIgg"
+	rfc8291MessageBody = "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+)
+
+func mustDecodeBase64URL(t *testing.T, s string) []byte {
+	t.Helper()
+	raw, err := decodeBase64URL(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestEncryptPushRecordKnownAnswer(t *testing.T) {
+	asPrivate, err := ecdh.P256().NewPrivateKey(mustDecodeBase64URL(t, rfc8291ASPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := PushSubscription{P256dh: rfc8291UAPublic, Auth: rfc8291AuthSecret}
+
+	body, err := encryptPushRecord(sub, []byte(rfc8291Plaintext), asPrivate, mustDecodeBase64URL(t, rfc8291Salt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := base64.RawURLEncoding.EncodeToString(body); got != rfc8291MessageBody {
+		t.Errorf("тело сообщения:\n got %s\nwant %s", got, rfc8291MessageBody)
+	}
+}
+
+// decryptPushBody расшифровывает тело aes128gcm на стороне браузера (RFC 8291, раздел 3)
+func decryptPushBody(t *testing.T, uaPrivate *ecdh.PrivateKey, authSecret, body []byte) []byte {
+	t.Helper()
+	if len(body) < 21 || len(body) < 21+int(body[20]) {
+		t.Fatalf("тело слишком короткое: %d байт", len(body))
+	}
+	salt := body[:16]
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != pushRecordSize {
+		t.Errorf("размер записи %d", rs)
+	}
+	keyID := body[21 : 21+int(body[20])]
+	ciphertext := body[21+int(body[20]):]
+
+	asPublic, err := ecdh.P256().NewPublicKey(keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedSecret, err := uaPrivate.ECDH(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyInfo := append([]byte("WebPush: info\x00"), uaPrivate.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, keyID...)
+	ikm := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, sharedSecret, authSecret, keyInfo), ikm)
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	nonce := make([]byte, 12)
+	io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Запись заканчивается разделителем 0x02 и необязательными нулями
+	record = bytes.TrimRight(record, "\x00")
+	if len(record) == 0 || record[len(record)-1] != 0x02 {
+		t.Fatal("нет разделителя последней записи")
+	}
+	return record[:len(record)-1]
+}
+
+func TestEncryptPushPayloadDecryptsWithRFC8291Keys(t *testing.T) {
+	uaPrivate, err := ecdh.P256().NewPrivateKey(mustDecodeBase64URL(t, rfc8291UAPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := PushSubscription{P256dh: rfc8291UAPublic, Auth: rfc8291AuthSecret}
+	authSecret := mustDecodeBase64URL(t, rfc8291AuthSecret)
+
+	// Пример из RFC расшифровывается так же, как браузер расшифрует наши сообщения
+	if got := decryptPushBody(t, uaPrivate, authSecret, mustDecodeBase64URL(t, rfc8291MessageBody)); string(got) != rfc8291Plaintext {
+		t.Errorf("пример RFC: %q", got)
+	}
+
+	first, err := encryptPushPayload(sub, []byte(rfc8291Plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := encryptPushPayload(sub, []byte(rfc8291Plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("соль и ключ сервера должны быть новыми для каждого сообщения")
+	}
+	if got := decryptPushBody(t, uaPrivate, authSecret, first); string(got) != rfc8291Plaintext {
+		t.Errorf("расшифровано %q", got)
+	}
+
+	if _, err := encryptPushPayload(sub, make([]byte, pushMaxPayload)); err != nil {
+		t.Errorf("сообщение максимального размера: %v", err)
+	}
+	if body, _ := encryptPushPayload(sub, make([]byte, pushMaxPayload)); len(body) > pushRecordSize {
+		t.Errorf("тело %d байт больше записи", len(body))
+	}
+	if _, err := encryptPushPayload(sub, make([]byte, pushMaxPayload+1)); err == nil {
+		t.Error("слишком большое сообщение зашифровано")
+	}
+}
+
+func TestParseVAPIDKeys(t *testing.T) {
+	keys, err := parseVAPIDKeys(rfc8291ASPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.publicB64 != rfc8291ASPublic {
+		t.Errorf("открытый ключ %s, ожидался %s", keys.publicB64, rfc8291ASPublic)
+	}
+	if !keys.private.Curve.IsOnCurve(keys.private.X, keys.private.Y) {
+		t.Error("открытый ключ не на кривой P-256")
+	}
+}
+
+// verifyVAPID проверяет заголовок Authorization по RFC 8292 и возвращает утверждения токена
+func verifyVAPID(t *testing.T, header string) jwt.MapClaims {
+	t.Helper()
+	rest, ok := strings.CutPrefix(header, "vapid ")
+	if !ok {
+		t.Fatalf("заголовок без схемы vapid: %q", header)
+	}
+	params := map[string]string{}
+	for _, param := range strings.Split(rest, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		params[name] = value
+	}
+
+	public, err := ecdh.P256().NewPublicKey(mustDecodeBase64URL(t, params["k"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := public.Bytes()
+	key := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(point[1:33]),
+		Y:     new(big.Int).SetBytes(point[33:65]),
+	}
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(params["t"], claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodES256 {
+			return nil, errors.New("ожидалась подпись ES256")
+		}
+		return key, nil
+	})
+	if err != nil || !token.Valid {
+		t.Fatalf("токен не прошёл проверку: %v", err)
+	}
+	return claims
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	keys, err := parseVAPIDKeys(rfc8291ASPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.subject = "mailto:admin@example.com"
+
+	header, err := keys.authorization("https://push.example.net:8443/send/abc?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := verifyVAPID(t, header)
+	if claims["aud"] != "https://push.example.net:8443" {
+		t.Errorf("aud = %v", claims["aud"])
+	}
+	if claims["sub"] != keys.subject {
+		t.Errorf("sub = %v", claims["sub"])
+	}
+	// RFC 8292 запрещает срок действия больше суток
+	exp, _ := claims["exp"].(float64)
+	if expires := time.Unix(int64(exp), 0); expires.Before(time.Now()) || expires.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("exp = %v", expires)
+	}
+	if !strings.HasSuffix(header, ", k="+rfc8291ASPublic) {
+		t.Errorf("в заголовке нет открытого ключа: %q", header)
+	}
+}
+
+func TestSendPushToLocalEndpoint(t *testing.T) {
+	keys, err := parseVAPIDKeys(rfc8291ASPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.subject = "mailto:admin@example.com"
+	previous := vapid
+	vapid = keys
+	t.Cleanup(func() { vapid = previous })
+
+	uaPrivate, err := ecdh.P256().NewPrivateKey(mustDecodeBase64URL(t, rfc8291UAPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := mustDecodeBase64URL(t, rfc8291AuthSecret)
+
+	// Сервис доставки запоминает запрос, проверяется он уже в горутине теста
+	var status int
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	endpoint := server.URL + "/push/device"
+	if err := validatePushEndpoint(endpoint); err != nil {
+		t.Fatalf("локальный адрес для проверки доставки отклонён: %v", err)
+	}
+	sub := PushSubscription{Endpoint: endpoint, P256dh: rfc8291UAPublic, Auth: rfc8291AuthSecret}
+
+	cases := []struct {
+		status   int
+		wantErr  error
+		retrying bool
+	}{
+		{http.StatusCreated, nil, false},
+		{http.StatusGone, errPushSubscriptionGone, false},
+		{http.StatusNotFound, errPushSubscriptionGone, false},
+		{http.StatusBadRequest, errPushRejected, false},
+		{http.StatusRequestEntityTooLarge, errPushRejected, false},
+		{http.StatusTooManyRequests, nil, true},
+		{http.StatusServiceUnavailable, nil, true},
+	}
+	for _, c := range cases {
+		status = c.status
+		header, body = nil, nil
+		err := sendPush(sub, []byte(`{"title":"Новое сообщение"}`))
+		if header == nil {
+			t.Fatalf("%d: запрос не дошёл до сервиса доставки: %v", c.status, err)
+		}
+
+		if claims := verifyVAPID(t, header.Get("Authorization")); claims["aud"] != server.URL {
+			t.Errorf("aud = %v, ожидался адрес сервиса доставки %s", claims["aud"], server.URL)
+		}
+		if header.Get("Content-Encoding") != "aes128gcm" || header.Get("TTL") != "86400" {
+			t.Errorf("заголовки запроса: %v", header)
+		}
+		if received := decryptPushBody(t, uaPrivate, authSecret, body); string(received) != `{"title":"Новое сообщение"}` {
+			t.Errorf("%d: сервис доставки получил %q", c.status, received)
+		}
+		switch {
+		case c.retrying:
+			if err == nil || errors.Is(err, errPushSubscriptionGone) || errors.Is(err, errPushRejected) {
+				t.Errorf("%d: ожидалась временная ошибка, получено %v", c.status, err)
+			}
+		case !errors.Is(err, c.wantErr) || (c.wantErr == nil && err != nil):
+			t.Errorf("%d: ошибка %v, ожидалась %v", c.status, err, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// internal/websocket.go
+package internal
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Минимальная серверная реализация протокола WebSocket (RFC 6455): рукопожатие,
+// чтение фрагментированных сообщений клиента и запись кадров без маски.
+// Расширения (например, permessage-deflate) не поддерживаются
+
+// Коды операций кадров WebSocket
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// wsCloseTryAgainLater — код закрытия для клиентов, которым стоит переподключиться позже
+const wsCloseTryAgainLater = 1013
+
+// wsAcceptGUID — константа из RFC 6455 для вычисления Sec-WebSocket-Accept
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxMessageSize ограничивает размер сообщения от клиента
+const wsMaxMessageSize = 64 * 1024
+
+var (
+	ErrWSProtocol = errors.New("нарушение протокола WebSocket")
+	ErrWSTooLarge = errors.New("слишком большое сообщение WebSocket")
+)
+
+// wsConn — установленное WebSocket-соединение
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// headerHasToken проверяет, содержит ли заголовок токен (без учёта регистра)
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkSameOrigin защищает от подключения со сторонних сайтов, которые могли бы
+// воспользоваться cookie сессии пользователя
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(parsed.Host, r.Host)
+}
+
+// upgradeWebSocket выполняет рукопожатие и забирает соединение у HTTP-сервера
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet ||
+		!headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Ожидается WebSocket-подключение", http.StatusBadRequest)
+		return nil, ErrWSProtocol
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Неподдерживаемая версия WebSocket", http.StatusUpgradeRequired)
+		return nil, ErrWSProtocol
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Некорректный Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrWSProtocol
+	}
+	if !checkSameOrigin(r) {
+		http.Error(w, "Запрещённый источник запроса", http.StatusForbidden)
+		return nil, ErrWSProtocol
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket не поддерживается", http.StatusInternalServerError)
+		return nil, errors.New("http.ResponseWriter не поддерживает Hijack")
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, reader: buffered.Reader}, nil
+}
+
+// readFrame читает один кадр и снимает маску клиента
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.reader, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	if header[0]&0x70 != 0 {
+		// Биты RSV используются только расширениями, которые мы не согласовывали
+		err = ErrWSProtocol
+		return
+	}
+	masked := header[1]&0x80 != 0
+	if !masked {
+		// Клиент обязан маскировать все кадры
+		err = ErrWSProtocol
+		return
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= wsOpClose && (length > 125 || !fin) {
+		err = ErrWSProtocol
+		return
+	}
+	if length > wsMaxMessageSize {
+		err = ErrWSTooLarge
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// ReadMessage возвращает следующее текстовое или бинарное сообщение. Служебные
+// кадры обрабатываются внутри: на ping отправляется pong, на close — ответный close.
+// onFrame вызывается на каждый полученный кадр и используется для продления таймаута
+func (c *wsConn) ReadMessage(onFrame func()) (byte, []byte, error) {
+	var messageType byte
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		if onFrame != nil {
+			onFrame()
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := c.WriteFrame(wsOpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.WriteFrame(wsOpClose, payload)
+			return 0, nil, io.EOF
+		case wsOpText, wsOpBinary:
+			if messageType != 0 {
+				return 0, nil, ErrWSProtocol
+			}
+			messageType = opcode
+		case wsOpContinuation:
+			if messageType == 0 {
+				return 0, nil, ErrWSProtocol
+			}
+		default:
+			return 0, nil, ErrWSProtocol
+		}
+
+		if len(message)+len(payload) > wsMaxMessageSize {
+			return 0, nil, ErrWSTooLarge
+		}
+		message = append(message, payload...)
+		if fin {
+			return messageType, message, nil
+		}
+	}
+}
+
+// WriteFrame отправляет один кадр. Безопасен для вызова из нескольких горутин
+func (c *wsConn) WriteFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	header := make([]byte, 0, 10)
+	header = append(header, 0x80|opcode)
+	switch length := len(payload); {
+	case length <= 125:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, byte(length>>8), byte(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// CloseWithStatus отправляет кадр закрытия с кодом и закрывает соединение
+func (c *wsConn) CloseWithStatus(code uint16, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	payload = append(payload, reason...)
+	c.WriteFrame(wsOpClose, payload)
+	return c.conn.Close()
+}
+
+// SetReadDeadline задаёт таймаут чтения
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Close закрывает соединение без кадра закрытия
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,60 @@
+// Вложения поста: для каждого выбранного файла показывается превью и поле
+// для описания. Поля alt идут в том же порядке, что и файлы, по нему сервер
+// сопоставляет описания с вложениями.
+(function () {
+    'use strict';
+
+    var input = document.querySelector('input[type="file"][data-attachments]');
+    if (!input) {
+        return;
+    }
+    var container = document.getElementById(input.dataset.attachments);
+    var maxFiles = parseInt(input.dataset.maxFiles, 10) || 10;
+    var previews = [];
+
+    function clear() {
+        previews.forEach(function (url) {
+            URL.revokeObjectURL(url);
+        });
+        previews = [];
+        container.innerHTML = '';
+    }
+
+    input.addEventListener('change', function () {
+        clear();
+        if (input.files.length > maxFiles) {
+            input.setCustomValidity('Можно прикрепить не больше ' + maxFiles + ' файлов');
+            input.reportValidity();
+            return;
+        }
+        input.setCustomValidity('');
+
+        Array.prototype.forEach.call(input.files, function (file, i) {
+            var item = document.createElement('div');
+            item.className = 'attachment-item';
+
+            var url = URL.createObjectURL(file);
+            previews.push(url);
+            var preview;
+            if (file.type.indexOf('video/') === 0) {
+                preview = document.createElement('video');
+                preview.muted = true;
+            } else {
+                preview = document.createElement('img');
+                preview.alt = '';
+            }
+            preview.src = url;
+
+            var alt = document.createElement('input');
+            alt.type = 'text';
+            alt.name = 'alt';
+            alt.maxLength = 500;
+            alt.placeholder = 'Описание для незрячих: что на ' + (file.type.indexOf('video/') === 0 ? 'видео' : 'фото');
+            alt.setAttribute('aria-label', 'Описание файла ' + (i + 1) + ': ' + file.name);
+
+            item.appendChild(preview);
+            item.appendChild(alt);
+            container.appendChild(item);
+        });
+    });
+})();
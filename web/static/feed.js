@@ -0,0 +1,44 @@
+// Поток новых постов друзей: вместо того чтобы сразу вставлять посты в ленту,
+// показываем баннер «N новых постов», по нажатию на который лента перезагружается.
+(function () {
+    'use strict';
+
+    var banner = document.querySelector('.new-posts-banner');
+    if (!banner || !window.EventSource) {
+        return;
+    }
+
+    var seen = {};
+    var count = 0;
+
+    function plural(n, one, few, many) {
+        var mod10 = n % 10;
+        var mod100 = n % 100;
+        if (mod10 === 1 && mod100 !== 11) {
+            return one;
+        }
+        if (mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14)) {
+            return few;
+        }
+        return many;
+    }
+
+    // Браузер сам передаёт Last-Event-ID при переподключении,
+    // параметр нужен только для первого подключения
+    var source = new EventSource('/events/feed?last_event_id=' + encodeURIComponent(banner.dataset.lastPostId));
+    source.addEventListener('post', function (e) {
+        var post;
+        try {
+            post = JSON.parse(e.data);
+        } catch (err) {
+            return;
+        }
+        if (seen[post.id]) {
+            return;
+        }
+        seen[post.id] = true;
+        count++;
+        banner.textContent = count + ' ' + plural(count, 'новый пост', 'новых поста', 'новых постов');
+        banner.hidden = false;
+    });
+})();
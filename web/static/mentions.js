@@ -0,0 +1,109 @@
+// Автодополнение упоминаний: при вводе @имя в полях с атрибутом data-mentions
+// под полем показывается список подходящих пользователей, сначала друзья.
+(function () {
+    'use strict';
+
+    var list = document.createElement('ul');
+    list.className = 'mention-suggestions';
+    list.hidden = true;
+    document.body.appendChild(list);
+
+    var field = null;
+    var timer = null;
+    var lastQuery = '';
+
+    // mentionAt возвращает начало и текст упоминания, которое вводится перед курсором
+    function mentionAt(el) {
+        var before = el.value.slice(0, el.selectionStart);
+        var match = /(^|[^\w@.\-])@([^\s@]{1,20})$/.exec(before);
+        if (!match) {
+            return null;
+        }
+        return { start: before.length - match[2].length - 1, query: match[2] };
+    }
+
+    function hide() {
+        list.hidden = true;
+        list.innerHTML = '';
+        lastQuery = '';
+    }
+
+    function insert(el, username) {
+        var mention = mentionAt(el);
+        if (!mention) {
+            return;
+        }
+        var end = el.selectionStart;
+        el.value = el.value.slice(0, mention.start) + '@' + username + ' ' + el.value.slice(end);
+        var caret = mention.start + username.length + 2;
+        el.setSelectionRange(caret, caret);
+        el.focus();
+        hide();
+    }
+
+    function show(el, users) {
+        list.innerHTML = '';
+        if (!users.length) {
+            hide();
+            return;
+        }
+        users.forEach(function (user) {
+            var item = document.createElement('li');
+            item.textContent = '@' + user.username + (user.friend ? ' · друг' : '');
+            item.addEventListener('mousedown', function (e) {
+                e.preventDefault();
+                insert(el, user.username);
+            });
+            list.appendChild(item);
+        });
+        var rect = el.getBoundingClientRect();
+        list.style.left = (rect.left + window.scrollX) + 'px';
+        list.style.top = (rect.bottom + window.scrollY) + 'px';
+        list.style.minWidth = rect.width / 2 + 'px';
+        list.hidden = false;
+    }
+
+    function lookup() {
+        var el = field;
+        var mention = el && mentionAt(el);
+        if (!mention) {
+            hide();
+            return;
+        }
+        if (mention.query === lastQuery) {
+            return;
+        }
+        lastQuery = mention.query;
+        fetch('/users/autocomplete?q=' + encodeURIComponent(mention.query), { credentials: 'same-origin' })
+            .then(function (response) {
+                return response.ok ? response.json() : [];
+            })
+            .then(function (users) {
+                if (field === el && lastQuery === mention.query) {
+                    show(el, users);
+                }
+            })
+            .catch(hide);
+    }
+
+    document.addEventListener('input', function (e) {
+        if (!e.target.hasAttribute || !e.target.hasAttribute('data-mentions')) {
+            return;
+        }
+        field = e.target;
+        clearTimeout(timer);
+        timer = setTimeout(lookup, 200);
+    });
+
+    document.addEventListener('keydown', function (e) {
+        if (e.key === 'Escape' && !list.hidden) {
+            hide();
+        }
+    });
+
+    document.addEventListener('focusout', function (e) {
+        if (e.target === field) {
+            hide();
+        }
+    });
+})();
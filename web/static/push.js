@@ -0,0 +1,93 @@
+// Включение и отключение push-уведомлений на этом устройстве.
+(function () {
+    'use strict';
+
+    var block = document.querySelector('.push-settings');
+    if (!block) {
+        return;
+    }
+    var status = block.querySelector('.push-status');
+    var enableButton = block.querySelector('.push-enable');
+    var disableButton = block.querySelector('.push-disable');
+
+    if (!('serviceWorker' in navigator) || !('PushManager' in window)) {
+        status.textContent = 'Этот браузер не поддерживает push-уведомления.';
+        return;
+    }
+
+    function urlBase64ToUint8Array(base64) {
+        var padding = '='.repeat((4 - base64.length % 4) % 4);
+        var raw = atob((base64 + padding).replace(/-/g, '+').replace(/_/g, '/'));
+        var bytes = new Uint8Array(raw.length);
+        for (var i = 0; i < raw.length; i++) {
+            bytes[i] = raw.charCodeAt(i);
+        }
+        return bytes;
+    }
+
+    function sendSubscription(method, subscription) {
+        return fetch('/push/subscription', {
+            method: method,
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'same-origin',
+            body: JSON.stringify(subscription)
+        }).then(function (response) {
+            if (!response.ok) {
+                throw new Error(response.statusText);
+            }
+        });
+    }
+
+    function render(subscription) {
+        enableButton.hidden = Boolean(subscription);
+        disableButton.hidden = !subscription;
+        status.textContent = subscription
+            ? 'Push-уведомления включены на этом устройстве.'
+            : 'Push-уведомления на этом устройстве выключены.';
+    }
+
+    var registration = navigator.serviceWorker.register('/sw.js');
+
+    registration.then(function (reg) {
+        return reg.pushManager.getSubscription();
+    }).then(function (subscription) {
+        // Обновляем подписку на сервере: она могла смениться или принадлежать другому аккаунту
+        if (subscription) {
+            sendSubscription('POST', subscription).catch(function () {});
+        }
+        render(subscription);
+    });
+
+    enableButton.addEventListener('click', function () {
+        Promise.all([registration, fetch('/push/key').then(function (r) { return r.json(); })])
+            .then(function (results) {
+                return results[0].pushManager.subscribe({
+                    userVisibleOnly: true,
+                    applicationServerKey: urlBase64ToUint8Array(results[1].publicKey)
+                });
+            })
+            .then(function (subscription) {
+                return sendSubscription('POST', subscription).then(function () {
+                    render(subscription);
+                });
+            })
+            .catch(function () {
+                status.textContent = 'Не удалось включить push-уведомления. Проверьте разрешения браузера.';
+            });
+    });
+
+    disableButton.addEventListener('click', function () {
+        registration.then(function (reg) {
+            return reg.pushManager.getSubscription();
+        }).then(function (subscription) {
+            if (!subscription) {
+                return null;
+            }
+            return sendSubscription('DELETE', subscription).then(function () {
+                return subscription.unsubscribe();
+            });
+        }).then(function () {
+            render(null);
+        });
+    });
+})();
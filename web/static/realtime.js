@@ -0,0 +1,223 @@
+// Подключение к событиям реального времени: новые сообщения, уведомления
+// и индикатор набора текста. При обрыве связи переподключается с нарастающей
+// задержкой и догоняет пропущенные события по номеру последнего полученного.
+// Номера событий у каждого пользователя свои; сервер при подключении
+// присылает событие resume с номером, с которого продолжается досылка.
+(function () {
+    'use strict';
+
+    var lastEventKey = 'realtime:lastEventId';
+    var socket = null;
+    var retryDelay = 1000;
+    var maxRetryDelay = 30000;
+    var typingTimers = {};
+    var lastTypingSent = 0;
+    var lastActivitySent = 0;
+
+    var messages = document.querySelector('.messages[data-conversation-id]');
+    var conversationId = messages ? parseInt(messages.dataset.conversationId, 10) : 0;
+    var currentUserId = messages ? parseInt(messages.dataset.userId, 10) : 0;
+
+    function lastEventId() {
+        return parseInt(sessionStorage.getItem(lastEventKey) || '0', 10);
+    }
+
+    function connect() {
+        var protocol = location.protocol === 'https:' ? 'wss:' : 'ws:';
+        var url = protocol + '//' + location.host + '/ws';
+        var last = lastEventId();
+        if (last > 0) {
+            url += '?last_event_id=' + last;
+        }
+
+        socket = new WebSocket(url);
+        socket.onopen = function () {
+            retryDelay = 1000;
+        };
+        socket.onmessage = function (e) {
+            var event;
+            try {
+                event = JSON.parse(e.data);
+            } catch (err) {
+                return;
+            }
+            if (event.id) {
+                if (event.id <= lastEventId()) {
+                    return;
+                }
+                sessionStorage.setItem(lastEventKey, String(event.id));
+            }
+            handleEvent(event);
+        };
+        socket.onclose = function () {
+            socket = null;
+            setTimeout(connect, retryDelay + Math.random() * 1000);
+            retryDelay = Math.min(retryDelay * 2, maxRetryDelay);
+        };
+    }
+
+    function send(payload) {
+        if (socket && socket.readyState === WebSocket.OPEN) {
+            socket.send(JSON.stringify(payload));
+        }
+    }
+
+    function handleEvent(event) {
+        var data = event.data || {};
+        switch (event.type) {
+        case 'resume':
+            sessionStorage.setItem(lastEventKey, String(data.last_id || 0));
+            break;
+        case 'message':
+            if (data.conversation_id === conversationId) {
+                appendMessage(data);
+                hideTyping(data.sender_id);
+                if (document.visibilityState === 'visible') {
+                    send({ type: 'read', conversation_id: conversationId, message_id: data.id });
+                    return;
+                }
+            }
+            setBadge('messages', data.unread);
+            break;
+        case 'unread':
+            if ('messages' in data) {
+                setBadge('messages', data.messages);
+            }
+            if ('notifications' in data) {
+                setBadge('notifications', data.notifications);
+            }
+            break;
+        case 'typing':
+            if (data.conversation_id === conversationId) {
+                showTyping(data.user_id, data.username);
+            }
+            break;
+        case 'notification':
+            setBadge('notifications', data.unread);
+            showToast(data.message);
+            break;
+        }
+    }
+
+    function setBadge(name, count) {
+        var link = document.querySelector('.badge-link[data-badge="' + name + '"]');
+        if (!link) {
+            return;
+        }
+        var badge = link.querySelector('.badge');
+        if (!count) {
+            if (badge) {
+                badge.remove();
+            }
+            return;
+        }
+        if (!badge) {
+            badge = document.createElement('span');
+            badge.className = 'badge';
+            link.appendChild(badge);
+        }
+        badge.textContent = String(count);
+    }
+
+    function appendMessage(data) {
+        if (messages.querySelector('[data-message-id="' + data.id + '"]')) {
+            return;
+        }
+
+        var item = document.createElement('div');
+        item.dataset.messageId = data.id;
+        if (data.kind === 'system') {
+            item.className = 'message system';
+            var note = document.createElement('small');
+            note.textContent = data.body + ' · ' + data.created_at;
+            item.appendChild(note);
+        } else {
+            item.className = 'message' + (data.sender_id === currentUserId ? ' own' : '');
+            var author = document.createElement('strong');
+            author.textContent = data.sender_name;
+            var body = document.createElement('p');
+            body.textContent = data.body;
+            var time = document.createElement('small');
+            time.textContent = data.created_at;
+            item.appendChild(author);
+            item.appendChild(body);
+            item.appendChild(time);
+        }
+        messages.appendChild(item);
+        item.scrollIntoView({ block: 'end' });
+    }
+
+    function typingIndicator() {
+        return document.querySelector('.typing-indicator');
+    }
+
+    function renderTyping() {
+        var indicator = typingIndicator();
+        if (!indicator) {
+            return;
+        }
+        var names = Object.keys(typingTimers).map(function (id) {
+            return typingTimers[id].username;
+        });
+        indicator.hidden = names.length === 0;
+        indicator.textContent = names.length ? names.join(', ') + ' печатает…' : '';
+    }
+
+    function showTyping(userId, username) {
+        if (typingTimers[userId]) {
+            clearTimeout(typingTimers[userId].timer);
+        }
+        typingTimers[userId] = {
+            username: username,
+            timer: setTimeout(function () { hideTyping(userId); }, 5000)
+        };
+        renderTyping();
+    }
+
+    function hideTyping(userId) {
+        if (typingTimers[userId]) {
+            clearTimeout(typingTimers[userId].timer);
+            delete typingTimers[userId];
+            renderTyping();
+        }
+    }
+
+    function showToast(text) {
+        if (!text) {
+            return;
+        }
+        var toast = document.createElement('div');
+        toast.className = 'toast';
+        toast.textContent = text;
+        document.body.appendChild(toast);
+        setTimeout(function () { toast.remove(); }, 6000);
+    }
+
+    // Действия в открытой вкладке означают, что пользователь на месте.
+    // Без них открытая вкладка показывает статус «нет на месте»
+    function reportActivity() {
+        var now = Date.now();
+        if (document.visibilityState === 'visible' && now - lastActivitySent > 60000) {
+            lastActivitySent = now;
+            send({ type: 'active' });
+        }
+    }
+    ['keydown', 'mousedown', 'scroll', 'touchstart', 'visibilitychange'].forEach(function (name) {
+        document.addEventListener(name, reportActivity, { passive: true });
+    });
+
+    if (messages) {
+        var input = document.querySelector('.message-form textarea');
+        if (input) {
+            input.addEventListener('input', function () {
+                var now = Date.now();
+                if (now - lastTypingSent > 3000) {
+                    lastTypingSent = now;
+                    send({ type: 'typing', conversation_id: conversationId });
+                }
+            });
+        }
+    }
+
+    connect();
+})();
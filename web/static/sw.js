@@ -0,0 +1,34 @@
+// Сервис-воркер: показывает push-уведомления, когда вкладка сайта закрыта,
+// и открывает нужную страницу по нажатию на уведомление.
+'use strict';
+
+self.addEventListener('push', function (event) {
+    var data = {};
+    try {
+        data = event.data ? event.data.json() : {};
+    } catch (err) {
+        data = { body: event.data ? event.data.text() : '' };
+    }
+
+    event.waitUntil(self.registration.showNotification(data.title || 'Социальная сеть', {
+        body: data.body || '',
+        tag: data.tag || undefined,
+        renotify: Boolean(data.tag),
+        icon: '/static/default_avatar.jpg',
+        data: { url: data.url || '/' }
+    }));
+});
+
+self.addEventListener('notificationclick', function (event) {
+    event.notification.close();
+    var url = new URL(event.notification.data.url, self.location.origin).href;
+
+    event.waitUntil(self.clients.matchAll({ type: 'window', includeUncontrolled: true }).then(function (windows) {
+        for (var i = 0; i < windows.length; i++) {
+            if (windows[i].url === url && 'focus' in windows[i]) {
+                return windows[i].focus();
+            }
+        }
+        return self.clients.openWindow(url);
+    }));
+});